@@ -30,6 +30,157 @@ type SecretValueFromSource struct {
 	SecretKeyRef *corev1.SecretKeySelector `json:"SecretKeyRef,omitempty"`
 }
 
+// GithubAppSpec 以 GitHub App 身份访问 GitHub API
+type GithubAppSpec struct {
+	// AppID GitHub App 的 ID
+	// +kubebuilder:validation:Minimum=1
+	AppID int64 `json:"appId"`
+
+	// InstallationID GitHub App 的安装 ID，不指定时按仓库自动查找
+	// +optional
+	InstallationID int64 `json:"installationId,omitempty"`
+
+	// PrivateKey GitHub App 的 PEM 私钥，保存在 Kubernetes Secret 中
+	PrivateKey SecretValueFromSource `json:"privateKey"`
+}
+
+// SinkSpec 事件转发目标，Ref 与 URI 至少指定一个
+type SinkSpec struct {
+	// Ref 可寻址的资源，如 Knative Eventing Broker、Knative Service 或 Kubernetes Service
+	// +optional
+	Ref *corev1.ObjectReference `json:"ref,omitempty"`
+
+	// URI 未指定 Ref 时为目标的绝对地址，否则为相对 Ref 地址的路径
+	// +optional
+	URI string `json:"uri,omitempty"`
+}
+
+// ParamSpec 传给 PipelineRun 的参数，JSONPath 与 Value 二选一
+type ParamSpec struct {
+	// Name 参数名称
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// JSONPath 从事件中取值的 JSONPath，如 {.pullRequest.title} 或 {.payload.release.name}，
+	// 数组与对象以 JSON 传入
+	// +optional
+	JSONPath string `json:"jsonPath,omitempty"`
+
+	// Value 参数的固定值
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// Default JSONPath 在事件中不存在时的取值，不指定时事件不触发 PipelineRun
+	// +optional
+	Default *string `json:"default,omitempty"`
+}
+
+// RunTemplateRef 引用 ConfigMap 中的 PipelineRun spec 模板，模板为 YAML 或 JSON，
+// receiver 在事件到达时读取，修改模板不会重新部署 receiver
+type RunTemplateRef struct {
+	// Name ConfigMap 名称，与 GitHook 位于同一命名空间
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Key 模板所在的键，默认为 runSpec
+	// +optional
+	Key string `json:"key,omitempty"`
+}
+
+// TriggerSpec 触发器，匹配的事件各自运行一个 PipelineRun
+type TriggerSpec struct {
+	// Name 触发器名称，作为 PipelineRun 名称前缀的一部分
+	// +kubebuilder:validation:Pattern=^[a-z0-9]([-a-z0-9]*[a-z0-9])?$
+	Name string `json:"name"`
+
+	// EventTypes 触发的事件类型，注册 webhook 时与其他触发器的事件类型合并
+	// +kubebuilder:validation:MinItems=1
+	EventTypes []gitEvent `json:"eventTypes"`
+
+	// When 触发条件，CEL 表达式，与 GitHook 的 When 相同
+	// +optional
+	When []string `json:"when,omitempty"`
+
+	// Params 追加到 PipelineRun 的参数，覆盖 GitHook 的同名参数
+	// +optional
+	Params []ParamSpec `json:"params,omitempty"`
+
+	// RunSpec 触发时运行的 tekton pipelinerun spec，与 RunTemplateRef 二选一
+	// +optional
+	RunSpec tektonv1alpha1.PipelineRunSpec `json:"runSpec,omitempty"`
+
+	// RunTemplateRef 触发时运行的 pipelinerun spec 模板，与 RunSpec 二选一
+	// +optional
+	RunTemplateRef *RunTemplateRef `json:"runTemplateRef,omitempty"`
+}
+
+// ChatOpsSpec PR/MR 评论中的斜杠命令：/retest 重新运行 PR 的 PipelineRun，
+// /test <trigger> 运行指定触发器，/cancel 取消 PR 正在运行的 PipelineRun。
+// 命令以 PR head commit 的 pull_request 事件（GitLab 为 merge_request）运行触发器，action 为命令名称
+type ChatOpsSpec struct {
+	// AllowedUsers 可执行命令的用户
+	// +optional
+	AllowedUsers []string `json:"allowedUsers,omitempty"`
+
+	// AllowedTeams 可执行命令的团队，GitHub 为 org/team，GitLab 为 group 路径
+	// +optional
+	AllowedTeams []string `json:"allowedTeams,omitempty"`
+
+	// Permission 拥有仓库该权限的用户可执行命令，
+	// 未指定 AllowedUsers、AllowedTeams 与 Permission 时为 write
+	// +kubebuilder:validation:Enum=read;write;admin
+	// +optional
+	Permission string `json:"permission,omitempty"`
+}
+
+// UntrustedPolicy 不受信任的 PR 的处理方式
+// +kubebuilder:validation:Enum=Run;RequireOkToTest;Never
+type UntrustedPolicy string
+
+const (
+	// UntrustedRun 与其他 PR 一样运行
+	UntrustedRun UntrustedPolicy = "Run"
+	// UntrustedRequireOkToTest 有 ok-to-test 标签，或协作者评论 /ok-to-test 后运行
+	UntrustedRequireOkToTest UntrustedPolicy = "RequireOkToTest"
+	// UntrustedNever 不运行
+	UntrustedNever UntrustedPolicy = "Never"
+)
+
+// PullRequestSpec PR 的运行策略
+type PullRequestSpec struct {
+	// UntrustedPolicy 作者对仓库没有 write 权限的 fork PR 的处理方式，默认为 Run。
+	// 添加 ok-to-test 标签与 /ok-to-test 评论以 ok-to-test 为 action 重新运行 PR 的触发器
+	// +optional
+	UntrustedPolicy UntrustedPolicy `json:"untrustedPolicy,omitempty"`
+}
+
+// CABundleSource CA 证书来源，Secret 或 ConfigMap 中的 PEM 证书
+type CABundleSource struct {
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+	// +optional
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+}
+
+// TransportSpec 访问 Git 服务 API 的传输配置
+type TransportSpec struct {
+	// CABundle 校验 Git 服务证书时额外信任的 CA
+	// +optional
+	CABundle *CABundleSource `json:"caBundle,omitempty"`
+
+	// InsecureSkipVerify 不校验 Git 服务证书
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+
+	// ProxyURL 访问 Git 服务使用的 HTTP 代理
+	// +optional
+	ProxyURL string `json:"proxyUrl,omitempty"`
+
+	// NoProxy 不经过代理访问的主机、域名后缀或 CIDR
+	// +optional
+	NoProxy []string `json:"noProxy,omitempty"`
+}
+
 // +kubebuilder:validation:Enum=gitlab;github;gogs
 
 // GitProvider Git 仓库类型
@@ -41,7 +192,7 @@ var (
 	Gogs   GitProvider = "gogs"
 )
 
-// +kubebuilder:validation:Enum=create;delete;fork;push;issues;issue_comment;pull_request;release
+// +kubebuilder:validation:Enum=create;delete;fork;push;issues;issue_comment;pull_request;release;tag_push;merge_request;note;pipeline;job;wiki_page
 type gitEvent string
 
 // GitHookSpec defines the desired state of GitHook
@@ -57,24 +208,103 @@ type GitHookSpec struct {
 	// +kubebuilder:validation:MinLength=1
 	ProjectURL string `json:"projectUrl"`
 
+	// ServerURL Git 服务地址，如 https://example.com/gitlab，
+	// Git 服务部署在子路径下或 ProjectURL 为 SSH 地址时指定
+	// +optional
+	ServerURL string `json:"serverUrl,omitempty"`
+
 	// GitProvider Git 仓库类型
 	GitProvider string `json:"gitProvider"`
 
-	// EventTypes 从 Gogs 接收的事件类型
-	EventTypes []gitEvent `json:"eventTypes"`
+	// EventTypes 从 Git 服务接收的事件类型，RunSpec 只由这些事件触发，
+	// 注册 webhook 时与各 Trigger 的事件类型合并
+	// +optional
+	EventTypes []gitEvent `json:"eventTypes,omitempty"`
 
-	// AccessToken Gogs 的 access token，保存在 Kubernetes Secret 中
-	AccessToken SecretValueFromSource `json:"accessToken"`
+	// AccessToken Gogs 的 access token，保存在 Kubernetes Secret 中，
+	// 使用 GithubApp 时不需要指定
+	// +optional
+	AccessToken SecretValueFromSource `json:"accessToken,omitempty"`
+
+	// GithubApp 以 GitHub App 身份管理 webhook 与提交状态，替代 AccessToken
+	// +optional
+	GithubApp *GithubAppSpec `json:"githubApp,omitempty"`
 
 	// SecretToken Gogs 的 secret token，保存在 Kubernetes Secret 中
 	SecretToken SecretValueFromSource `json:"secretToken"`
 
-	// SSLVerify 触发 hook 时是否执行 SSL 验证
+	// SSLVerify 触发 hook 时 Git 服务是否校验 receiver 的 SSL 证书
 	// +optional
 	SSLVerify bool `json:"sslVerify,omitempty"`
 
-	// RunSpec 事件触发时要运行的 tekton pipelinerun spec
-	RunSpec tektonv1alpha1.PipelineRunSpec `json:"runSpec"`
+	// Transport 访问 Git 服务 API 的 CA、TLS 与代理配置
+	// +optional
+	Transport *TransportSpec `json:"transport,omitempty"`
+
+	// When 事件触发条件，CEL 表达式全部为 true 时事件才转发到 Sink 并触发 PipelineRun。
+	// 表达式中 event 为统一格式的事件，event.changedFiles 为变更的文件，
+	// body 为 Git 服务发送的原始 payload，header 为小写名称的请求头，
+	// 如 event.ref == "refs/heads/main" && !event.sender.login.endsWith("[bot]")
+	// +optional
+	When []string `json:"when,omitempty"`
+
+	// RunSpec 事件触发时要运行的 tekton pipelinerun spec，不指定时只转发事件到 Sink
+	// +optional
+	RunSpec tektonv1alpha1.PipelineRunSpec `json:"runSpec,omitempty"`
+
+	// RunTemplateRef 事件触发时要运行的 pipelinerun spec 模板，替代 RunSpec
+	// +optional
+	RunTemplateRef *RunTemplateRef `json:"runTemplateRef,omitempty"`
+
+	// Params 按事件取值后追加到 PipelineRun 的参数，与 RunSpec 中的同名参数覆盖后者
+	// +optional
+	Params []ParamSpec `json:"params,omitempty"`
+
+	// Triggers 各自按事件类型与条件运行 PipelineRun 的触发器，共用一个 webhook 与 receiver
+	// +optional
+	Triggers []TriggerSpec `json:"triggers,omitempty"`
+
+	// ChatOps 识别 PR/MR 评论中的斜杠命令，receiver 使用 AccessToken 或 GithubApp 调用 Git 服务 API，
+	// 不支持 gogs
+	// +optional
+	ChatOps *ChatOpsSpec `json:"chatops,omitempty"`
+
+	// PullRequest fork PR 的运行策略，receiver 使用 AccessToken 或 GithubApp 查询作者权限，不支持 gogs
+	// +optional
+	PullRequest *PullRequestSpec `json:"pullRequest,omitempty"`
+
+	// CommitStatus 为 true 时 receiver 创建 PipelineRun 后在事件的 commit 上报告 pending 状态，
+	// 使用 AccessToken 或 GithubApp 调用 Git 服务 API，仅支持 github
+	// +optional
+	CommitStatus bool `json:"commitStatus,omitempty"`
+
+	// Sink 接收事件的目标，每个事件以 CloudEvent 转发，类型为 dev.githook.<事件类型>
+	// +optional
+	Sink *SinkSpec `json:"sink,omitempty"`
+
+	// RateLimit 事件限流，防抖与每分钟上限由 receiver 的所有副本共享
+	// +optional
+	RateLimit *RateLimitSpec `json:"rateLimit,omitempty"`
+
+	// DryRun 为 true 时 receiver 照常过滤事件并生成 PipelineRun，但不创建，
+	// 生成的 PipelineRun 记录在 Event 与保存的 delivery 中，手动运行同样只生成不创建
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// RateLimitSpec 运行触发器的事件限流，PR 评论中的斜杠命令与重放的 delivery 不受限流。
+// 被合并或丢弃的事件记录在 delivery 与 githook_rate_limited_events_total 指标中
+type RateLimitSpec struct {
+	// DebounceSeconds 同一 ref 同类事件的防抖窗口（秒），事件在窗口结束时仍是该 ref 最新的事件才运行，
+	// 否则合并到之后的事件，0 不防抖
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	DebounceSeconds int32 `json:"debounceSeconds,omitempty"`
+
+	// MaxPerMinute GitHook 每分钟运行触发器的事件数上限，超出的事件被丢弃，0 不限制
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxPerMinute int32 `json:"maxPerMinute,omitempty"`
 }
 
 // GitHookStatus defines the observed state of GitHook
@@ -84,6 +314,56 @@ type GitHookStatus struct {
 
 	// ID Gogs 项目 hook id
 	ID string `json:"Id,omitempty"`
+
+	// SinkURI 解析后的事件转发地址
+	// +optional
+	SinkURI string `json:"sinkUri,omitempty"`
+
+	// Conditions GitHook 的最新状态
+	// +optional
+	Conditions []GitHookCondition `json:"conditions,omitempty"`
+}
+
+// GitHookConditionType GitHook 状态类型
+type GitHookConditionType string
+
+const (
+	// WebhookReady git webhook 已注册且与 GitHook 一致
+	WebhookReady GitHookConditionType = "WebhookReady"
+)
+
+// GitHook 的操作注解，controller 处理后即清除，结果以 Event 报告
+const (
+	// RunAnnotation 手动运行 GitHook，值为 {"ref": "main", "trigger": "build"}，
+	// ref 可以是分支、refs/ 开头的完整引用或 commit，trigger 为空时运行 GitHook 自身的 runSpec
+	RunAnnotation = "githook.tools/run"
+
+	// SyncAnnotation 强制以 GitHook 的配置重新注册 git webhook，值任意，通常为请求时间
+	SyncAnnotation = "githook.tools/sync"
+
+	// ReplayAnnotation 重放 receiver 保存的 delivery，值为 delivery ID，重放时不做去重
+	ReplayAnnotation = "githook.tools/replay"
+)
+
+// GitHookCondition GitHook 的状态
+type GitHookCondition struct {
+	// Type 状态类型
+	Type GitHookConditionType `json:"type"`
+
+	// Status True、False 或 Unknown
+	Status corev1.ConditionStatus `json:"status"`
+
+	// Reason 状态原因，调用 Git 服务 API 失败时为 NotFound、Unauthorized、RateLimited 或 Transient
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Message 状态详情
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// LastTransitionTime 状态最近一次变化的时间
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
 }
 
 // +kubebuilder:object:root=true
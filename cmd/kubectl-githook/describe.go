@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/knative/pkg/apis"
+	"github.com/zhd173/githook/api/v1alpha1"
+	"github.com/zhd173/githook/pkg/githook"
+	"github.com/zhd173/githook/pkg/tekton"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func describeFlags(p *plugin, flags *flag.FlagSet) {
+	flags.IntVar(&p.limit, "limit", 10, "The number of recent PipelineRuns and deliveries shown.")
+}
+
+func (p *plugin) describe(args []string) error {
+	hook, err := p.getGitHook(args)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", hook.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", hook.Namespace)
+	fmt.Fprintf(w, "Provider:\t%s\n", hook.Spec.GitProvider)
+	fmt.Fprintf(w, "Project:\t%s\n", hook.Spec.ProjectURL)
+	fmt.Fprintf(w, "Hook ID:\t%s\n", orNone(hook.Status.ID))
+	fmt.Fprintf(w, "Receiver URL:\t%s\n", orNone(p.receiverURL(hook)))
+	fmt.Fprintf(w, "Sink:\t%s\n", orNone(hook.Status.SinkURI))
+	fmt.Fprintf(w, "Dry run:\t%t\n", hook.Spec.DryRun)
+	fmt.Fprintf(w, "Rate limit:\t%s\n", rateLimit(hook.Spec.RateLimit))
+
+	fmt.Fprintln(w, "\nConditions:")
+	if len(hook.Status.Conditions) == 0 {
+		fmt.Fprintln(w, "  <none>")
+	} else {
+		fmt.Fprintln(w, "  TYPE\tSTATUS\tREASON\tAGE\tMESSAGE")
+		for _, condition := range hook.Status.Conditions {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", condition.Type, condition.Status, orNone(condition.Reason),
+				age(condition.LastTransitionTime), condition.Message)
+		}
+	}
+
+	fmt.Fprintln(w, "\nRecent runs:")
+	if err := p.describeRuns(w, hook); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nRecent deliveries:")
+	if err := p.describeDeliveries(w, hook); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nEvents:")
+	if err := p.describeEvents(w, hook); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
+
+// rateLimit describes the rate limits of a GitHook
+func rateLimit(spec *v1alpha1.RateLimitSpec) string {
+	var limits []string
+	if spec != nil && spec.DebounceSeconds > 0 {
+		limits = append(limits, fmt.Sprintf("debounce %ds per ref", spec.DebounceSeconds))
+	}
+	if spec != nil && spec.MaxPerMinute > 0 {
+		limits = append(limits, fmt.Sprintf("%d events per minute", spec.MaxPerMinute))
+	}
+	return orNone(strings.Join(limits, ", "))
+}
+
+// describeRuns prints the latest PipelineRuns created for a GitHook
+func (p *plugin) describeRuns(w *tabwriter.Writer, hook *v1alpha1.GitHook) error {
+	selector := labels.SelectorFromSet(map[string]string{tekton.LabelGitHook: hook.Name}).String()
+	runs, err := p.tekton.TektonV1alpha1().PipelineRuns(hook.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	if len(runs.Items) == 0 {
+		fmt.Fprintln(w, "  <none>")
+		return nil
+	}
+
+	sort.Slice(runs.Items, func(i, j int) bool {
+		return runs.Items[j].CreationTimestamp.Before(&runs.Items[i].CreationTimestamp)
+	})
+
+	fmt.Fprintln(w, "  NAME\tTRIGGER\tPULL REQUEST\tSTATUS\tAGE")
+	for i, run := range runs.Items {
+		if i == p.limit {
+			break
+		}
+
+		status := "Pending"
+		if condition := run.Status.GetCondition(apis.ConditionSucceeded); condition != nil {
+			switch condition.Status {
+			case corev1.ConditionTrue:
+				status = "Succeeded"
+			case corev1.ConditionFalse:
+				status = "Failed"
+			default:
+				status = "Running"
+			}
+			if condition.Reason != "" && condition.Reason != status {
+				status = fmt.Sprintf("%s (%s)", status, condition.Reason)
+			}
+		}
+
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\n", run.Name, orNone(run.Labels[tekton.LabelTrigger]),
+			orNone(run.Labels[tekton.LabelPullRequest]), status, age(run.CreationTimestamp))
+	}
+	return nil
+}
+
+// describeDeliveries prints the latest deliveries stored by the receiver
+func (p *plugin) describeDeliveries(w *tabwriter.Writer, hook *v1alpha1.GitHook) error {
+	selector := labels.SelectorFromSet(map[string]string{tekton.LabelGitHook: hook.Name, githook.LabelDelivery: "true"}).String()
+	list, err := p.kube.CoreV1().ConfigMaps(hook.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+
+	var records []*githook.DeliveryRecord
+	for i := range list.Items {
+		record, err := githook.ParseDeliveryRecord(&list.Items[i])
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		fmt.Fprintln(w, "  <none>")
+		return nil
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[j].Received.Before(records[i].Received)
+	})
+
+	fmt.Fprintln(w, "  ID\tEVENT\tDECISION\tRUNS\tREPLAYS\tAGE\tERROR")
+	for i, record := range records {
+		if i == p.limit {
+			break
+		}
+		runs := strings.Join(record.Runs, ",")
+		if record.DryRun {
+			runs = fmt.Sprintf("%d rendered (dry run)", len(record.Rendered))
+		}
+		if record.CoalescedInto != "" {
+			runs = "coalesced into " + record.CoalescedInto
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%d\t%s\t%s\n", record.ID, record.Event, orNone(record.Decision),
+			orNone(runs), record.Replays, age(metav1.NewTime(record.Received)), record.Error)
+	}
+	return nil
+}
+
+// describeEvents prints the latest events of a GitHook, emitted by the
+// controller and the receiver
+func (p *plugin) describeEvents(w *tabwriter.Writer, hook *v1alpha1.GitHook) error {
+	selector := fields.Set{
+		"involvedObject.kind": "GitHook",
+		"involvedObject.name": hook.Name,
+		"involvedObject.uid":  string(hook.UID),
+	}.AsSelector().String()
+
+	list, err := p.kube.CoreV1().Events(hook.Namespace).List(metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return err
+	}
+	if len(list.Items) == 0 {
+		fmt.Fprintln(w, "  <none>")
+		return nil
+	}
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[j].LastTimestamp.Before(&list.Items[i].LastTimestamp)
+	})
+
+	fmt.Fprintln(w, "  TYPE\tREASON\tAGE\tMESSAGE")
+	for i, event := range list.Items {
+		if i == p.limit {
+			break
+		}
+		seen := age(event.LastTimestamp)
+		if event.Count > 1 {
+			seen = fmt.Sprintf("%s (x%d)", seen, event.Count)
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", event.Type, event.Reason, seen, event.Message)
+	}
+	return nil
+}
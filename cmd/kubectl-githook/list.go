@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	servingv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/zhd173/githook/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// receiverLabel is the label of the Knative Services of the receivers
+const receiverLabel = "receive-adapter"
+
+func listFlags(p *plugin, flags *flag.FlagSet) {
+	flags.BoolVar(&p.allNamespaces, "all-namespaces", false, "List the GitHooks of all namespaces.")
+	flags.BoolVar(&p.allNamespaces, "A", false, "Shorthand for --all-namespaces.")
+}
+
+func (p *plugin) list(args []string) error {
+	var options []client.ListOptionFunc
+	if !p.allNamespaces {
+		options = append(options, client.InNamespace(p.namespace))
+	}
+
+	hooks := &v1alpha1.GitHookList{}
+	if err := p.client.List(context.TODO(), hooks, options...); err != nil {
+		return err
+	}
+	if len(hooks.Items) == 0 {
+		fmt.Fprintln(p.out, "No GitHooks found.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 8, 2, ' ', 0)
+	if p.allNamespaces {
+		fmt.Fprint(w, "NAMESPACE\t")
+	}
+	fmt.Fprintln(w, "NAME\tPROVIDER\tREADY\tREASON\tHOOK ID\tURL\tAGE")
+
+	for i := range hooks.Items {
+		hook := &hooks.Items[i]
+		ready, reason := readiness(hook)
+
+		if p.allNamespaces {
+			fmt.Fprintf(w, "%s\t", hook.Namespace)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", hook.Name, hook.Spec.GitProvider, ready, orNone(reason),
+			orNone(hook.Status.ID), orNone(p.receiverURL(hook)), age(hook.CreationTimestamp))
+	}
+	return w.Flush()
+}
+
+// readiness returns the status and reason of the WebhookReady condition
+func readiness(hook *v1alpha1.GitHook) (string, string) {
+	for _, condition := range hook.Status.Conditions {
+		if condition.Type == v1alpha1.WebhookReady {
+			return string(condition.Status), condition.Reason
+		}
+	}
+	return string(corev1.ConditionUnknown), ""
+}
+
+// receiver returns the Knative Service of the receiver of a GitHook
+func (p *plugin) receiver(hook *v1alpha1.GitHook) (*servingv1alpha1.Service, error) {
+	services := &servingv1alpha1.ServiceList{}
+	err := p.client.List(context.TODO(), services, client.InNamespace(hook.Namespace), client.MatchingLabels(map[string]string{receiverLabel: hook.Name}))
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range services.Items {
+		if owner := metav1.GetControllerOf(&services.Items[i]); owner != nil && owner.UID == hook.UID {
+			return &services.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no receiver found for GitHook %s/%s", hook.Namespace, hook.Name)
+}
+
+// receiverURL returns the address the webhook delivers to, empty until the
+// receiver is ready
+func (p *plugin) receiverURL(hook *v1alpha1.GitHook) string {
+	ksvc, err := p.receiver(hook)
+	if err != nil {
+		return ""
+	}
+	if ksvc.Status.URL != nil {
+		return ksvc.Status.URL.String()
+	}
+	return ksvc.Status.DeprecatedDomain
+}
+
+func age(timestamp metav1.Time) string {
+	if timestamp.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(timestamp.Time))
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
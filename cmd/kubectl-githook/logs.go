@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// knativeServiceLabel selects the pods of a Knative Service
+	knativeServiceLabel = "serving.knative.dev/service"
+	// receiverContainer is the container Knative runs the receiver image in
+	receiverContainer = "user-container"
+)
+
+func logsFlags(p *plugin, flags *flag.FlagSet) {
+	flags.BoolVar(&p.follow, "follow", false, "Stream the logs.")
+	flags.BoolVar(&p.follow, "f", false, "Shorthand for --follow.")
+	flags.Int64Var(&p.tail, "tail", -1, "The number of recent lines shown of each pod, all lines when negative.")
+}
+
+// logs prints the logs of the receiver pods of a GitHook, prefixed with the
+// pod name when the receiver is scaled out
+func (p *plugin) logs(args []string) error {
+	hook, err := p.getGitHook(args)
+	if err != nil {
+		return err
+	}
+	ksvc, err := p.receiver(hook)
+	if err != nil {
+		return err
+	}
+
+	selector := labels.SelectorFromSet(map[string]string{knativeServiceLabel: ksvc.Name}).String()
+	pods, err := p.kube.CoreV1().Pods(hook.Namespace).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	if len(pods.Items) == 0 {
+		return fmt.Errorf("the receiver of GitHook %s has no pod, it is scaled to zero until the next delivery", hook.Name)
+	}
+
+	options := &corev1.PodLogOptions{Container: receiverContainer, Follow: p.follow}
+	if p.tail >= 0 {
+		options.TailLines = &p.tail
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(chan error, len(pods.Items))
+	for _, pod := range pods.Items {
+		prefix := ""
+		if len(pods.Items) > 1 {
+			prefix = fmt.Sprintf("[%s] ", pod.Name)
+		}
+
+		stream, err := p.kube.CoreV1().Pods(hook.Namespace).GetLogs(pod.Name, options).Stream()
+		if err != nil {
+			return fmt.Errorf("failed to get the logs of %s: %s", pod.Name, err)
+		}
+
+		wg.Add(1)
+		go func(stream io.ReadCloser, prefix string) {
+			defer wg.Done()
+			defer stream.Close()
+
+			scanner := bufio.NewScanner(stream)
+			for scanner.Scan() {
+				mu.Lock()
+				fmt.Fprintf(p.out, "%s%s\n", prefix, scanner.Text())
+				mu.Unlock()
+			}
+			if err := scanner.Err(); err != nil {
+				errs <- err
+			}
+		}(stream, prefix)
+	}
+
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
@@ -0,0 +1,192 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-githook is the kubectl plugin inspecting and operating GitHooks,
+// installed on the PATH it runs as `kubectl githook`.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	servingv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	"github.com/zhd173/githook/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const usage = `Inspect and operate GitHooks.
+
+Usage:
+  kubectl githook [flags] <command> [command flags]
+
+Commands:
+  list                      List the GitHooks with their webhook readiness, provider hook ID and receiver URL
+  describe <name>           Show the conditions, recent PipelineRuns, stored deliveries and events of a GitHook
+  sync <name>               Force the controller to register the webhook again
+  trigger <name> --ref REF  Run a GitHook on a branch, refs/ reference or commit
+  replay <name> <delivery>  Handle a stored delivery again, even when it was handled before
+  logs <name>               Print the logs of the receiver of a GitHook
+
+Flags:
+`
+
+// command is a subcommand of the plugin
+type command struct {
+	run   func(p *plugin, args []string) error
+	flags func(p *plugin, flags *flag.FlagSet)
+}
+
+var commands = map[string]command{
+	"list":     {run: (*plugin).list, flags: listFlags},
+	"describe": {run: (*plugin).describe, flags: describeFlags},
+	"sync":     {run: (*plugin).sync},
+	"trigger":  {run: (*plugin).trigger, flags: triggerFlags},
+	"replay":   {run: (*plugin).replay},
+	"logs":     {run: (*plugin).logs, flags: logsFlags},
+}
+
+// plugin holds the clients and the flags of the commands
+type plugin struct {
+	kubeconfig  string
+	kubecontext string
+	namespace   string
+
+	client client.Client
+	kube   kubernetes.Interface
+	tekton versioned.Interface
+	out    io.Writer
+	now    func() time.Time
+
+	allNamespaces bool
+	limit         int
+	ref           string
+	triggerName   string
+	follow        bool
+	tail          int64
+}
+
+func main() {
+	p := &plugin{out: os.Stdout, now: time.Now}
+
+	flags := flag.NewFlagSet("kubectl-githook", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	p.globalFlags(flags)
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// 子命令之后同样可以指定 namespace 等全局参数
+	cmdFlags := flag.NewFlagSet(name, flag.ExitOnError)
+	p.globalFlags(cmdFlags)
+	if cmd.flags != nil {
+		cmd.flags(p, cmdFlags)
+	}
+	cmdFlags.Parse(flags.Args()[1:])
+
+	if err := p.connect(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+	if err := cmd.run(p, cmdFlags.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func (p *plugin) globalFlags(flags *flag.FlagSet) {
+	flags.StringVar(&p.kubeconfig, "kubeconfig", p.kubeconfig, "Path to the kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config.")
+	flags.StringVar(&p.kubecontext, "context", p.kubecontext, "The kubeconfig context to use.")
+	flags.StringVar(&p.namespace, "namespace", p.namespace, "The namespace of the GitHooks, defaults to the namespace of the context.")
+	flags.StringVar(&p.namespace, "n", p.namespace, "Shorthand for --namespace.")
+}
+
+// connect creates the clients from the kubeconfig, like kubectl does
+func (p *plugin) connect() error {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = p.kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: p.kubecontext})
+
+	if p.namespace == "" {
+		namespace, _, err := clientConfig.Namespace()
+		if err != nil {
+			return err
+		}
+		p.namespace = namespace
+	}
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return err
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := servingv1alpha1.AddToScheme(scheme); err != nil {
+		return err
+	}
+
+	if p.client, err = client.New(config, client.Options{Scheme: scheme}); err != nil {
+		return err
+	}
+	if p.kube, err = kubernetes.NewForConfig(config); err != nil {
+		return err
+	}
+	if p.tekton, err = versioned.NewForConfig(config); err != nil {
+		return err
+	}
+	return nil
+}
+
+// getGitHook returns the GitHook named by the only argument of a command
+func (p *plugin) getGitHook(args []string) (*v1alpha1.GitHook, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected the name of one GitHook, got %d arguments", len(args))
+	}
+
+	hook := &v1alpha1.GitHook{}
+	err := p.client.Get(context.TODO(), client.ObjectKey{Namespace: p.namespace, Name: args[0]}, hook)
+	if err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/zhd173/githook/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func triggerFlags(p *plugin, flags *flag.FlagSet) {
+	flags.StringVar(&p.ref, "ref", "", "The branch, refs/ reference or commit to run on.")
+	flags.StringVar(&p.triggerName, "trigger", "", "The trigger to run, the runSpec of the GitHook when empty.")
+}
+
+// sync asks the controller to register the webhook again, even when it
+// matches the GitHook
+func (p *plugin) sync(args []string) error {
+	hook, err := p.getGitHook(args)
+	if err != nil {
+		return err
+	}
+
+	if err := p.annotate(hook, v1alpha1.SyncAnnotation, p.now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "githook/%s sync requested, see `kubectl githook describe %s` for the outcome\n", hook.Name, hook.Name)
+	return nil
+}
+
+// trigger asks the controller to run a GitHook on a ref
+func (p *plugin) trigger(args []string) error {
+	if p.ref == "" {
+		return fmt.Errorf("--ref is required")
+	}
+	hook, err := p.getGitHook(args)
+	if err != nil {
+		return err
+	}
+
+	run := map[string]string{"ref": p.ref}
+	if p.triggerName != "" {
+		run["trigger"] = p.triggerName
+	}
+	value, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	if err := p.annotate(hook, v1alpha1.RunAnnotation, string(value)); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "githook/%s run requested on %s, see `kubectl githook describe %s` for the PipelineRun\n", hook.Name, p.ref, hook.Name)
+	return nil
+}
+
+// replay asks the controller to replay a stored delivery through the receiver
+func (p *plugin) replay(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected the name of a GitHook and a delivery ID, got %d arguments", len(args))
+	}
+	hook, err := p.getGitHook(args[:1])
+	if err != nil {
+		return err
+	}
+
+	if err := p.annotate(hook, v1alpha1.ReplayAnnotation, args[1]); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "githook/%s replay of delivery %s requested, see `kubectl githook describe %s` for the outcome\n", hook.Name, args[1], hook.Name)
+	return nil
+}
+
+// annotate sets an annotation the controller consumes
+func (p *plugin) annotate(hook *v1alpha1.GitHook, key, value string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{key: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.client.Patch(context.TODO(), hook, client.ConstantPatch(types.MergePatchType, patch))
+}
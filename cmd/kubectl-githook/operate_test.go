@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/zhd173/githook/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// patchRecorder records the patches sent to the GitHooks
+type patchRecorder struct {
+	client.Client
+	patchType types.PatchType
+	patch     string
+}
+
+func (recorder *patchRecorder) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOptionFunc) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	recorder.patchType, recorder.patch = patch.Type(), string(data)
+	return nil
+}
+
+func TestOperateCommandsPatchAnnotation(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	hook := &v1alpha1.GitHook{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hook"}}
+
+	for _, test := range []struct {
+		name        string
+		ref         string
+		triggerName string
+		run         func(p *plugin, args []string) error
+		args        []string
+		patch       string
+	}{{
+		name:  "sync",
+		run:   (*plugin).sync,
+		args:  []string{"hook"},
+		patch: `{"metadata":{"annotations":{"githook.tools/sync":"2019-06-01T10:00:00Z"}}}`,
+	}, {
+		name:  "trigger on a ref",
+		ref:   "main",
+		run:   (*plugin).trigger,
+		args:  []string{"hook"},
+		patch: `{"metadata":{"annotations":{"githook.tools/run":"{\"ref\":\"main\"}"}}}`,
+	}, {
+		name:        "trigger a named trigger",
+		ref:         "refs/tags/v1.0.0",
+		triggerName: "release",
+		run:         (*plugin).trigger,
+		args:        []string{"hook"},
+		patch:       `{"metadata":{"annotations":{"githook.tools/run":"{\"ref\":\"refs/tags/v1.0.0\",\"trigger\":\"release\"}"}}}`,
+	}, {
+		name:  "replay",
+		run:   (*plugin).replay,
+		args:  []string{"hook", "delivery-1"},
+		patch: `{"metadata":{"annotations":{"githook.tools/replay":"delivery-1"}}}`,
+	}} {
+		recorder := &patchRecorder{Client: fake.NewFakeClientWithScheme(scheme, hook.DeepCopy())}
+		p := &plugin{
+			namespace:   "default",
+			client:      recorder,
+			out:         ioutil.Discard,
+			now:         func() time.Time { return time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC) },
+			ref:         test.ref,
+			triggerName: test.triggerName,
+		}
+
+		if err := test.run(p, test.args); err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err)
+		}
+		if recorder.patchType != types.MergePatchType || recorder.patch != test.patch {
+			t.Errorf("%s: expected merge patch %s, got %s %s", test.name, test.patch, recorder.patchType, recorder.patch)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/zhd173/githook/api/v1alpha1"
+	githookclient "github.com/zhd173/githook/pkg/client"
+	"github.com/zhd173/githook/pkg/githook"
+	"github.com/zhd173/githook/pkg/metrics"
+	"github.com/zhd173/githook/pkg/model"
+	"github.com/zhd173/githook/pkg/tekton"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	envSecretToken          = "SECRET_TOKEN"
+	envPreviousSecretToken  = "PREVIOUS_SECRET_TOKEN"
+	envPreviousTokenExpires = "PREVIOUS_SECRET_TOKEN_EXPIRES"
+	envPort                 = "PORT"
+	envAccessToken          = "GIT_ACCESS_TOKEN"
+	envGithubAppPrivateKey  = "GITHUB_APP_PRIVATE_KEY"
+	envCABundle             = "GIT_CA_BUNDLE"
+)
+
+func main() {
+	var gitProvider, namespace, name, uid, configPath, sink, githubEnterpriseHost, metricsAddr string
+	var projectURL, serverURL, proxyURL, noProxy string
+	var githubAppID, githubAppInstallationID int64
+	var insecureSkipVerify bool
+	var deliveryLimit, deliveryBodySize, queueSize, workers int
+	var drainTimeout time.Duration
+	flag.StringVar(&gitProvider, "gitprovider", "", "The git provider sending the webhook events.")
+	flag.StringVar(&namespace, "namespace", "", "The namespace of the GitHook.")
+	flag.StringVar(&name, "name", "", "The name of the GitHook.")
+	flag.StringVar(&uid, "uid", "", "The uid of the GitHook, Events are emitted on the GitHook when set.")
+	flag.StringVar(&configPath, "config", "", "The receiver config file with the triggers, params and when expressions, reloaded when it changes. No PipelineRun is created without config.")
+	flag.StringVar(&sink, "sink", "", "The address the events are sent to as CloudEvents.")
+	flag.StringVar(&githubEnterpriseHost, "github-enterprise-host", "", "The GitHub Enterprise Server host sending the webhook events.")
+	flag.StringVar(&metricsAddr, "metrics-addr", ":9090", "The address the metric endpoint binds to.")
+	flag.StringVar(&projectURL, "project-url", "", "The project url of the GitHook, the git provider api is called for the slash commands, the pull request policy and the commit statuses when set.")
+	flag.StringVar(&serverURL, "server-url", "", "The git server url of the GitHook.")
+	flag.Int64Var(&githubAppID, "github-app-id", 0, "The GitHub App the git provider api is called as, instead of the access token.")
+	flag.Int64Var(&githubAppInstallationID, "github-app-installation-id", 0, "The installation of the GitHub App, looked up from the repository when zero.")
+	flag.BoolVar(&insecureSkipVerify, "insecure-skip-verify", false, "Do not verify the certificate of the git provider api.")
+	flag.StringVar(&proxyURL, "proxy-url", "", "The http proxy the git provider api is called through.")
+	flag.StringVar(&noProxy, "no-proxy", "", "The comma separated hosts the git provider api is called without proxy for.")
+	flag.IntVar(&deliveryLimit, "delivery-limit", 0, "The number of recent deliveries stored in ConfigMaps for inspection and replay, none when zero.")
+	flag.IntVar(&deliveryBodySize, "delivery-body-size", githook.DefaultDeliveryBodySize, "The largest payload stored with a delivery, larger deliveries are stored without payload and cannot be replayed.")
+	flag.IntVar(&queueSize, "queue-size", githook.DefaultQueueSize, "The number of deliveries queued for the workers, answered 503 when full. Deliveries are handled within the request when zero.")
+	flag.IntVar(&workers, "workers", githook.DefaultWorkers, "The number of workers handling the queued deliveries.")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 25*time.Second, "How long the queued deliveries are handled on SIGTERM before exiting.")
+	flag.Parse()
+
+	secrets, err := secretTokensFromEnv()
+	if err != nil {
+		log.Fatalf("failed to read secret tokens: %s", err)
+	}
+
+	hookServer, err := newHookServer(gitProvider, secrets, githubEnterpriseHost)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var config *githook.ConfigFile
+	if configPath != "" {
+		config = &githook.ConfigFile{Path: configPath}
+		if err := config.Load(); err != nil {
+			log.Fatalf("failed to load receiver config: %s", err)
+		}
+	}
+
+	tektonClient, err := tekton.New()
+	if err != nil {
+		log.Fatalf("failed to create tekton client: %s", err)
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(ctrl.GetConfigOrDie())
+	if err != nil {
+		log.Fatalf("failed to create kubernetes client: %s", err)
+	}
+	recorder := newEventRecorder(kubeClient, namespace)
+
+	ra := &githook.ReceiveAdapter{
+		TektonClient: tektonClient,
+		HookServer:   hookServer,
+		Provider:     gitProvider,
+		Namespace:    namespace,
+		Name:         name,
+		Config:       config,
+		Recorder:     recorder,
+		Secrets:      secrets,
+	}
+	if sink != "" {
+		ra.Sender = githook.NewCloudEventSender(sink)
+	}
+	if projectURL != "" {
+		transport := &model.TransportOptions{
+			CABundle:           []byte(os.Getenv(envCABundle)),
+			InsecureSkipVerify: insecureSkipVerify,
+			ProxyURL:           proxyURL,
+		}
+		if noProxy != "" {
+			transport.NoProxy = strings.Split(noProxy, ",")
+		}
+
+		ra.ChatOps, err = newChatOps(gitProvider, projectURL, serverURL, githubAppID, githubAppInstallationID, transport)
+		if err != nil {
+			log.Fatalf("failed to create chatops client: %s", err)
+		}
+	}
+	if uid != "" {
+		ra.Source = &corev1.ObjectReference{
+			APIVersion: v1alpha1.GroupVersion.String(),
+			Kind:       "GitHook",
+			Namespace:  namespace,
+			Name:       name,
+			UID:        types.UID(uid),
+		}
+	}
+	var owner *metav1.OwnerReference
+	if uid != "" {
+		owner = &metav1.OwnerReference{
+			APIVersion: v1alpha1.GroupVersion.String(),
+			Kind:       "GitHook",
+			Name:       name,
+			UID:        types.UID(uid),
+		}
+	}
+	if deliveryLimit > 0 {
+		ra.Deliveries = &githook.ConfigMapDeliveryStore{
+			Client:    kubeClient.CoreV1(),
+			Namespace: namespace,
+			Name:      name,
+			Owner:     owner,
+			Limit:     deliveryLimit,
+			BodySize:  deliveryBodySize,
+		}
+	}
+	// 限流状态由所有副本共享，receiver 配置中设置 rateLimit 时使用
+	ra.RateLimits = &githook.ConfigMapRateLimitState{
+		Client:    kubeClient.CoreV1(),
+		Namespace: namespace,
+		Name:      name,
+		Owner:     owner,
+	}
+
+	port := os.Getenv(envPort)
+	if port == "" {
+		port = "8080"
+	}
+
+	go serveMetrics(metricsAddr)
+
+	if queueSize > 0 {
+		ra.Queue = githook.NewEventQueue(queueSize)
+		ra.Backoff = githook.DefaultRetryBackoff
+		ra.StartWorkers(workers)
+	}
+
+	log.Printf("receive adapter listening on :%s", port)
+	mux := http.NewServeMux()
+	mux.HandleFunc(githook.ReplayPath, ra.HandleReplay)
+	mux.HandleFunc("/", ra.HandleRequest)
+	server := &http.Server{Addr: ":" + port, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	<-signals
+
+	// 先停止接收请求，再处理完队列中的 delivery
+	log.Printf("receive adapter shutting down, draining the queue for up to %s", drainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("failed to shut down the server: %s", err)
+	}
+	// 防抖中的 delivery 不再等待窗口结束，随队列一起处理完
+	if err := ra.DrainDebounced(ctx); err != nil {
+		log.Printf("failed to settle the debounced deliveries: %s", err)
+	}
+	if ra.Queue != nil {
+		if err := ra.Queue.Drain(ctx); err != nil {
+			log.Printf("failed to drain the queue: %s", err)
+		}
+	}
+}
+
+// newEventRecorder creates the recorder emitting the Events of the receiver
+func newEventRecorder(kubeClient kubernetes.Interface, namespace string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events(namespace)})
+
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "githook-receive-adapter"})
+}
+
+// serveMetrics serves the receiver metrics apart from the public webhook endpoint
+func serveMetrics(addr string) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}), prometheus.NewGoCollector())
+	metrics.RegisterReceiver(registry)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	log.Printf("metrics listening on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Printf("failed to serve metrics: %s", err)
+	}
+}
+
+// newChatOps creates the git provider client of the slash commands, with the
+// access token or GitHub App private key read from the environment
+func newChatOps(gitProvider, projectURL, serverURL string, appID, installationID int64, transport *model.TransportOptions) (*githook.ChatOps, error) {
+	repo, err := model.ParseRepository(gitProvider, projectURL, serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &model.HookOptions{
+		Repository:  repo,
+		BaseURL:     repo.BaseURL(),
+		Project:     repo.Name,
+		Owner:       repo.Namespace,
+		AccessToken: os.Getenv(envAccessToken),
+		Transport:   transport,
+	}
+
+	httpClient, err := githookclient.NewHTTPClient(transport)
+	if err != nil {
+		return nil, err
+	}
+
+	var chatopsClient githook.ChatOpsClient
+	var statusClient githook.CommitStatusClient
+	switch gitProvider {
+	case string(v1alpha1.Github):
+		var githubClient *githookclient.GithubClient
+		if appID != 0 {
+			app := &model.GithubAppOptions{
+				AppID:          appID,
+				InstallationID: installationID,
+				PrivateKey:     []byte(os.Getenv(envGithubAppPrivateKey)),
+			}
+			githubClient, err = githookclient.NewGithubAppClient(options.BaseURL, app, options.Owner, options.Project, httpClient)
+		} else {
+			githubClient, err = githookclient.NewGithubClient(options.BaseURL, options.AccessToken, httpClient)
+		}
+		if err != nil {
+			return nil, err
+		}
+		chatopsClient, statusClient = githubClient, githubClient
+	case string(v1alpha1.Gitlab):
+		gitlabClient, err := githookclient.NewGitlabClient(options.BaseURL, options.AccessToken, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		chatopsClient = gitlabClient
+	default:
+		return nil, fmt.Errorf("chatops is not supported by git provider %s", gitProvider)
+	}
+
+	return &githook.ChatOps{Client: chatopsClient, Options: options, Statuses: statusClient}, nil
+}
+
+func newHookServer(gitProvider string, secrets *githook.SecretTokens, githubEnterpriseHost string) (githook.HookServer, error) {
+	switch gitProvider {
+	case string(v1alpha1.Gogs):
+		return &githook.GogsHookServer{Secrets: secrets}, nil
+	case string(v1alpha1.Github):
+		return &githook.GithubHookServer{Secrets: secrets, EnterpriseHost: githubEnterpriseHost}, nil
+	case string(v1alpha1.Gitlab):
+		return &githook.GitlabHookServer{Secrets: secrets}, nil
+	default:
+		return nil, fmt.Errorf("git provider %s not support", gitProvider)
+	}
+}
+
+// secretTokensFromEnv reads the current token and, during a rotation, the
+// previous token together with the time it stops being accepted
+func secretTokensFromEnv() (*githook.SecretTokens, error) {
+	secrets := &githook.SecretTokens{
+		Current:  os.Getenv(envSecretToken),
+		Previous: os.Getenv(envPreviousSecretToken),
+	}
+
+	if expires := os.Getenv(envPreviousTokenExpires); expires != "" {
+		expiry, err := time.Parse(time.RFC3339, expires)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", envPreviousTokenExpires, err)
+		}
+		secrets.PreviousExpiry = expiry
+	}
+
+	return secrets, nil
+}
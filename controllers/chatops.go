@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zhd173/githook/api/v1alpha1"
+	"github.com/zhd173/githook/pkg/githook"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// chatopsEvents 斜杠命令所在的评论事件
+var chatopsEvents = map[string]string{
+	string(v1alpha1.Github): "issue_comment",
+	string(v1alpha1.Gitlab): "note",
+}
+
+// receiverChatOps 传给 receiver 的斜杠命令权限配置
+func receiverChatOps(source *v1alpha1.GitHook) *githook.ChatOpsConfig {
+	chatops := source.Spec.ChatOps
+	if chatops == nil {
+		return nil
+	}
+
+	return &githook.ChatOpsConfig{
+		AllowedUsers: chatops.AllowedUsers,
+		AllowedTeams: chatops.AllowedTeams,
+		Permission:   chatops.Permission,
+	}
+}
+
+// receiverPullRequest 传给 receiver 的不受信任 PR 策略
+func receiverPullRequest(source *v1alpha1.GitHook) *githook.PullRequestConfig {
+	if source.Spec.PullRequest == nil {
+		return nil
+	}
+
+	return &githook.PullRequestConfig{
+		UntrustedPolicy: string(source.Spec.PullRequest.UntrustedPolicy),
+	}
+}
+
+// holdsUntrusted 是否需要检查 PR 作者的权限
+func holdsUntrusted(source *v1alpha1.GitHook) bool {
+	pullRequest := source.Spec.PullRequest
+	return pullRequest != nil && pullRequest.UntrustedPolicy != "" && pullRequest.UntrustedPolicy != v1alpha1.UntrustedRun
+}
+
+// receiverAPIArgs receiver 调用 Git 服务 API 所需的参数与凭证，凭证以环境变量引用 Secret，
+// 不写入 ksvc
+func receiverAPIArgs(source *v1alpha1.GitHook) ([]string, []corev1.EnvVar) {
+	if source.Spec.ChatOps == nil && !holdsUntrusted(source) && !source.Spec.CommitStatus {
+		return nil, nil
+	}
+
+	args := []string{fmt.Sprintf("--project-url=%s", source.Spec.ProjectURL)}
+	var env []corev1.EnvVar
+
+	if source.Spec.ServerURL != "" {
+		args = append(args, fmt.Sprintf("--server-url=%s", source.Spec.ServerURL))
+	}
+
+	if app := source.Spec.GithubApp; app != nil {
+		args = append(args, fmt.Sprintf("--github-app-id=%d", app.AppID))
+		if app.InstallationID != 0 {
+			args = append(args, fmt.Sprintf("--github-app-installation-id=%d", app.InstallationID))
+		}
+		env = append(env, corev1.EnvVar{
+			Name:      "GITHUB_APP_PRIVATE_KEY",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: app.PrivateKey.SecretKeyRef},
+		})
+	} else if source.Spec.AccessToken.SecretKeyRef != nil {
+		env = append(env, corev1.EnvVar{
+			Name:      "GIT_ACCESS_TOKEN",
+			ValueFrom: &corev1.EnvVarSource{SecretKeyRef: source.Spec.AccessToken.SecretKeyRef},
+		})
+	}
+
+	if transport := source.Spec.Transport; transport != nil {
+		if transport.InsecureSkipVerify {
+			args = append(args, "--insecure-skip-verify")
+		}
+		if transport.ProxyURL != "" {
+			args = append(args, fmt.Sprintf("--proxy-url=%s", transport.ProxyURL))
+		}
+		if len(transport.NoProxy) > 0 {
+			args = append(args, fmt.Sprintf("--no-proxy=%s", strings.Join(transport.NoProxy, ",")))
+		}
+		if caBundle := transport.CABundle; caBundle != nil {
+			env = append(env, corev1.EnvVar{
+				Name: "GIT_CA_BUNDLE",
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef:    caBundle.SecretKeyRef,
+					ConfigMapKeyRef: caBundle.ConfigMapKeyRef,
+				},
+			})
+		}
+	}
+
+	return args, env
+}
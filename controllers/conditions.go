@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/zhd173/githook/api/v1alpha1"
+	githookclient "github.com/zhd173/githook/pkg/client"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	// ReasonReconciled git webhook 与 GitHook 一致
+	ReasonReconciled = "Reconciled"
+	// ReasonReconcileFailed 调和失败且不是 Git 服务 API 错误
+	ReasonReconcileFailed = "ReconcileFailed"
+
+	// DefaultRateLimitRequeue Git 服务未返回 rate limit 重置时间时的重试间隔
+	DefaultRateLimitRequeue = time.Minute
+)
+
+// setWebhookCondition 根据调和结果更新 WebhookReady 状态
+func setWebhookCondition(source *v1alpha1.GitHook, reconcileErr error) {
+	condition := v1alpha1.GitHookCondition{
+		Type:   v1alpha1.WebhookReady,
+		Status: corev1.ConditionTrue,
+		Reason: ReasonReconciled,
+	}
+
+	if reconcileErr != nil {
+		condition.Status = corev1.ConditionFalse
+		condition.Reason = reconcileReason(reconcileErr)
+		condition.Message = reconcileErr.Error()
+	}
+
+	setCondition(&source.Status, condition)
+}
+
+// reconcileReason 调和结果的原因，Git 服务 API 错误使用错误类型
+func reconcileReason(reconcileErr error) string {
+	if reconcileErr == nil {
+		return ReasonReconciled
+	}
+	if errorType, ok := githookclient.ErrorTypeOf(reconcileErr); ok {
+		return string(errorType)
+	}
+	return ReasonReconcileFailed
+}
+
+// setCondition 替换同类型的状态，状态值变化时更新 LastTransitionTime
+func setCondition(status *v1alpha1.GitHookStatus, condition v1alpha1.GitHookCondition) {
+	condition.LastTransitionTime = metav1.Now()
+
+	for i, existing := range status.Conditions {
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		status.Conditions[i] = condition
+		return
+	}
+
+	status.Conditions = append(status.Conditions, condition)
+}
+
+// resultFor 根据 Git 服务 API 错误类型决定重新调和的时间：
+// rate limit 等待重置后重试，认证失败与项目不存在等待下次定期调和或 Secret 变化，
+// 其它错误交给 controller-runtime 退避重试
+func (r *GitHookReconciler) resultFor(reconcileErr error) (ctrl.Result, error) {
+	if reconcileErr == nil {
+		return ctrl.Result{RequeueAfter: r.resyncInterval()}, nil
+	}
+
+	switch {
+	case githookclient.IsRateLimited(reconcileErr):
+		if retryAfter := githookclient.RetryAfter(reconcileErr); retryAfter > 0 {
+			return ctrl.Result{RequeueAfter: retryAfter}, nil
+		}
+		return ctrl.Result{RequeueAfter: DefaultRateLimitRequeue}, nil
+	case githookclient.IsUnauthorized(reconcileErr), githookclient.IsNotFound(reconcileErr):
+		return ctrl.Result{RequeueAfter: r.resyncInterval()}, nil
+	}
+
+	return ctrl.Result{}, reconcileErr
+}
@@ -19,31 +19,57 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net/url"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
 	servinv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
 	servingv1beta1 "github.com/knative/serving/pkg/apis/serving/v1beta1"
+	tektonv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
 	"github.com/zhd173/githook/api/v1alpha1"
 	githookclient "github.com/zhd173/githook/pkg/client"
 	"github.com/zhd173/githook/pkg/githook"
+	"github.com/zhd173/githook/pkg/metrics"
 	"github.com/zhd173/githook/pkg/model"
 	corev1 "k8s.io/api/core/v1"
 	apiequality "k8s.io/apimachinery/pkg/api/equality"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 const (
 	controllerAgentName = "githook-controller"
 	runKsvcAs           = "pipeline-runner" // see tektonrole.yaml
 	finalizerName       = controllerAgentName
+
+	// DefaultResyncInterval is how often webhooks are checked for drift
+	DefaultResyncInterval = 10 * time.Minute
+
+	reasonServiceCreated          = "ServiceCreated"
+	reasonServiceUpdated          = "ServiceUpdated"
+	reasonWebhookCreated          = "WebhookCreated"
+	reasonWebhookUpdated          = "WebhookUpdated"
+	reasonWebhookDeleted          = "WebhookDeleted"
+	reasonWebhookDeleteFailed     = "WebhookDeleteFailed"
+	reasonWebhookRecreated        = "WebhookRecreated"
+	reasonWebhookDriftCorrected   = "WebhookDriftCorrected"
+	reasonWebhookAdopted          = "WebhookAdopted"
+	reasonWebhookDuplicateRemoved = "WebhookDuplicateRemoved"
+	reasonWebhookDuplicateFound   = "WebhookDuplicateFound"
+	reasonManualRunCreated        = "ManualRunCreated"
+	reasonManualRunFailed         = "ManualRunFailed"
+	reasonManualRunRendered       = "ManualRunRendered"
+	reasonDeliveryReplayed        = "DeliveryReplayed"
+	reasonDeliveryReplayFailed    = "DeliveryReplayFailed"
 )
 
 // GitHookReconciler reconciles a GitHook object
@@ -52,6 +78,23 @@ type GitHookReconciler struct {
 	Log          logr.Logger
 	Scheme       *runtime.Scheme
 	WebhookImage string
+
+	// SecretRotationOverlap 轮换 secret token 后旧 token 仍被 receiver 接受的时长
+	SecretRotationOverlap time.Duration
+
+	// ResyncInterval 定期重新校验 git webhook，修复在 git 仓库中被删除或修改的 hook
+	ResyncInterval time.Duration
+
+	Recorder record.EventRecorder
+
+	// TektonClient 创建手动运行的 PipelineRun，为空时不处理 run 注解
+	TektonClient githook.PipelineRunClient
+
+	// DeliveryLimit receiver 在 ConfigMap 中保存的最近 delivery 数量，为 0 时不保存，也无法重放
+	DeliveryLimit int
+
+	// GitClients 创建调用 Git 服务 API 的 client，为空时按 gitProvider 创建
+	GitClients func(source *v1alpha1.GitHook, options *model.HookOptions) (*githook.Client, error)
 }
 
 func (r *GitHookReconciler) requestLogger(req ctrl.Request) logr.Logger {
@@ -74,8 +117,12 @@ func ignoreNotFound(err error) error {
 // +kubebuilder:rbac:groups=tools.github.com/zhd173,resources=githooks,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=tools.github.com/zhd173,resources=githooks/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=serving.knative.dev,resources=services,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch
 // +kubebuilder:rbac:groups=eventing.knative.dev,resources=channels,verbs=get;list;watch
+// +kubebuilder:rbac:groups=eventing.knative.dev,resources=brokers,verbs=get
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=tekton.dev,resources=pipelineruns;pipelineresources,verbs=get;list;create;update
 
 // Reconcile ...
 func (r *GitHookReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
@@ -94,9 +141,12 @@ func (r *GitHookReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	source := sourceOrg.DeepCopyObject()
 
 	var reconcileErr error
+	var rotation *tokenRotation
 	if sourceOrg.ObjectMeta.DeletionTimestamp == nil {
 		// 新建、更新
-		reconcileErr = r.reconcile(source.(*v1alpha1.GitHook))
+		rotation, reconcileErr = r.reconcile(source.(*v1alpha1.GitHook))
+		setWebhookCondition(source.(*v1alpha1.GitHook), reconcileErr)
+		metrics.Reconciles.WithLabelValues(reconcileReason(reconcileErr)).Inc()
 	} else {
 		// 删除：通过 DeletionTimestamp != nil 判定是否删除，调用 finalize 方法删除依赖资源
 		if r.hasFinalizer(source.(*v1alpha1.GitHook).Finalizers) {
@@ -109,42 +159,102 @@ func (r *GitHookReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		log.Error(err, "Failed to update")
 		return ctrl.Result{}, err
 	}
-	return ctrl.Result{}, reconcileErr
 
+	if sourceOrg.ObjectMeta.DeletionTimestamp != nil {
+		return ctrl.Result{}, reconcileErr
+	}
+
+	if reconcileErr != nil {
+		log.Error(reconcileErr, "Failed to reconcile")
+		r.Recorder.Event(source, corev1.EventTypeWarning, reconcileReason(reconcileErr), reconcileErr.Error())
+	}
+
+	// 定期重新调和，检测 git webhook 是否被删除或修改
+	result, err := r.resultFor(reconcileErr)
+	return requeueBefore(result, rotation), err
+
+}
+
+func (r *GitHookReconciler) resyncInterval() time.Duration {
+	if r.ResyncInterval > 0 {
+		return r.ResyncInterval
+	}
+	return DefaultResyncInterval
 }
 
-// 新建、更新逻辑
-func (r *GitHookReconciler) reconcile(source *v1alpha1.GitHook) error {
+// 新建、更新逻辑，返回 secret token 的轮换状态
+func (r *GitHookReconciler) reconcile(source *v1alpha1.GitHook) (*tokenRotation, error) {
+	hookOptions, err := r.buildHookFromSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	// secret token 变化时需要滚动 receiver 并同步到 git webhook
+	rotation, err := r.reconcileSecretToken(source, hookOptions.SecretToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return rotation, r.reconcileReceiver(source, hookOptions, rotation)
+}
+
+// 调和 receiver 与 git webhook
+func (r *GitHookReconciler) reconcileReceiver(source *v1alpha1.GitHook, hookOptions *model.HookOptions, rotation *tokenRotation) error {
 	log := r.sourceLogger(source)
 
-	ksvc, err := r.reconcileWebhookService(source)
+	if err := validateGitHook(source); err != nil {
+		return err
+	}
+
+	if err := r.checkRunTemplates(source); err != nil {
+		return err
+	}
+
+	if err := r.reconcileReceiverConfig(source); err != nil {
+		return err
+	}
+
+	// 手动运行不依赖 webhook，注解在运行前移除
+	r.runManual(source, hookOptions)
+
+	// receiver 将事件转发到解析后的 Sink 地址
+	sinkURI, err := r.resolveSink(source)
 	if err != nil {
 		return err
 	}
+	source.Status.SinkURI = sinkURI
 
-	hookOptions, err := r.buildHookFromSource(source)
+	ksvc, err := r.reconcileWebhookService(source, rotation)
 	if err != nil {
 		return err
 	}
 
+	// 重放经由 receiver 处理，需要 receiver 就绪
+	r.replayDelivery(source, ksvc, hookOptions.SecretToken)
+
 	// 使用 Knative Service URL 注册 git webhook，并保存返回的 ID
 	hookOptions.URL = getWebhookURL(source, ksvc)
-	hookID, err := r.reconcileWebhook(source, hookOptions)
+	hookID, err := r.reconcileWebhook(source, hookOptions, rotation.pending, syncRequested(source))
 	if err != nil {
 		return err
 	}
+	delete(source.Annotations, v1alpha1.SyncAnnotation)
 	source.Status.ID = hookID
 
+	if err := r.markTokenPushed(source, rotation); err != nil {
+		return err
+	}
+
 	log.Info("add finalizer to the source")
 	r.addFinalizer(source)
 	return nil
 }
 
 // 注册 git webhook
-func (r *GitHookReconciler) reconcileWebhook(source *v1alpha1.GitHook, hookOptions *model.HookOptions) (string, error) {
+func (r *GitHookReconciler) reconcileWebhook(source *v1alpha1.GitHook, hookOptions *model.HookOptions, secretRotated, resync bool) (string, error) {
 	log := r.sourceLogger(source)
 
-	gitClient, err := getGitClient(source, hookOptions)
+	gitClient, err := r.gitClient(source, hookOptions)
 
 	if err != nil {
 		return "", err
@@ -157,6 +267,23 @@ func (r *GitHookReconciler) reconcileWebhook(source *v1alpha1.GitHook, hookOptio
 	}
 
 	if !exists {
+		// 认领仓库中已注册的 hook，避免 status 丢失后重复创建
+		adoptedID, err := r.adoptWebhook(source, gitClient, hookOptions)
+		if err != nil {
+			return "", err
+		}
+		if adoptedID != "" {
+			staleID := hookOptions.ID
+			hookOptions.ID = adoptedID
+			if _, err := gitClient.Update(hookOptions); err != nil {
+				return "", err
+			}
+			r.Recorder.Eventf(source, corev1.EventTypeNormal, reasonWebhookAdopted,
+				"Adopted existing webhook %s on %s/%s", adoptedID, hookOptions.Owner, hookOptions.Project)
+			log.Info("adopt existing webhook successfully", "project", hookOptions.Project, "id", adoptedID, "staleID", staleID)
+			return adoptedID, nil
+		}
+
 		log.Info("create new webhook", "project", hookOptions.Project)
 		hookID, err := gitClient.Create(hookOptions)
 
@@ -164,6 +291,15 @@ func (r *GitHookReconciler) reconcileWebhook(source *v1alpha1.GitHook, hookOptio
 			return "", err
 		}
 		log.Info("create new webhook successfully", "project", hookOptions.Project)
+
+		// status 中记录了 ID 但 hook 已不存在，说明 hook 在 git 仓库中被删除
+		if hookOptions.ID != "" {
+			r.Recorder.Eventf(source, corev1.EventTypeWarning, reasonWebhookRecreated,
+				"Webhook %s was missing from %s/%s and has been recreated as %s", hookOptions.ID, hookOptions.Owner, hookOptions.Project, hookID)
+		} else {
+			r.Recorder.Eventf(source, corev1.EventTypeNormal, reasonWebhookCreated,
+				"Created webhook %s on %s/%s", hookID, hookOptions.Owner, hookOptions.Project)
+		}
 		return hookID, err
 	}
 
@@ -171,8 +307,8 @@ func (r *GitHookReconciler) reconcileWebhook(source *v1alpha1.GitHook, hookOptio
 		return "", err
 	}
 
-	if changed == true {
-		log.Info("update existing webhook", "project", hookOptions.Project)
+	if changed == true || secretRotated || resync {
+		log.Info("update existing webhook", "project", hookOptions.Project, "secretRotated", secretRotated, "resync", resync)
 		hookID, err := gitClient.Update(hookOptions)
 
 		if err != nil {
@@ -181,6 +317,18 @@ func (r *GitHookReconciler) reconcileWebhook(source *v1alpha1.GitHook, hookOptio
 
 		log.Info("update existing webhook successfully", "project", hookOptions.Project)
 
+		switch {
+		case changed:
+			r.Recorder.Eventf(source, corev1.EventTypeWarning, reasonWebhookDriftCorrected,
+				"Webhook %s on %s/%s did not match the desired configuration and has been updated", hookID, hookOptions.Owner, hookOptions.Project)
+		case secretRotated:
+			r.Recorder.Eventf(source, corev1.EventTypeNormal, reasonWebhookUpdated,
+				"Updated webhook %s on %s/%s with the rotated secret token", hookID, hookOptions.Owner, hookOptions.Project)
+		default:
+			r.Recorder.Eventf(source, corev1.EventTypeNormal, reasonWebhookUpdated,
+				"Re-registered webhook %s on %s/%s as requested by the %s annotation", hookID, hookOptions.Owner, hookOptions.Project, v1alpha1.SyncAnnotation)
+		}
+
 		return hookID, nil
 	}
 
@@ -188,11 +336,40 @@ func (r *GitHookReconciler) reconcileWebhook(source *v1alpha1.GitHook, hookOptio
 	return hookOptions.ID, nil
 }
 
+// 查找仓库中指向本 GitHook 的 hook，认领第一个并删除其余重复的 hook
+func (r *GitHookReconciler) adoptWebhook(source *v1alpha1.GitHook, gitClient *githook.Client, hookOptions *model.HookOptions) (string, error) {
+	log := r.sourceLogger(source)
+
+	hookIDs, err := gitClient.Find(hookOptions)
+	if err != nil {
+		return "", err
+	}
+
+	if len(hookIDs) == 0 {
+		return "", nil
+	}
+
+	for _, duplicateID := range hookIDs[1:] {
+		duplicate := *hookOptions
+		duplicate.ID = duplicateID
+		if err := gitClient.Delete(&duplicate); err != nil {
+			log.Error(err, "failed to remove duplicate webhook", "id", duplicateID)
+			r.Recorder.Eventf(source, corev1.EventTypeWarning, reasonWebhookDuplicateFound,
+				"Duplicate webhook %s on %s/%s could not be removed: %s", duplicateID, hookOptions.Owner, hookOptions.Project, err)
+			continue
+		}
+		r.Recorder.Eventf(source, corev1.EventTypeNormal, reasonWebhookDuplicateRemoved,
+			"Removed duplicate webhook %s on %s/%s", duplicateID, hookOptions.Owner, hookOptions.Project)
+	}
+
+	return hookIDs[0], nil
+}
+
 // 调和 Knative Service，不存在则创建，否则更新
-func (r *GitHookReconciler) reconcileWebhookService(source *v1alpha1.GitHook) (*servinv1alpha1.Service, error) {
+func (r *GitHookReconciler) reconcileWebhookService(source *v1alpha1.GitHook, rotation *tokenRotation) (*servinv1alpha1.Service, error) {
 	log := r.sourceLogger(source)
 
-	desiredKsvc, err := r.generateKnativeServiceObject(source, r.WebhookImage)
+	desiredKsvc, err := r.generateKnativeServiceObject(source, r.WebhookImage, rotation)
 	if err != nil {
 		return nil, err
 	}
@@ -210,6 +387,7 @@ func (r *GitHookReconciler) reconcileWebhookService(source *v1alpha1.GitHook) (*
 		}
 		ksvc = desiredKsvc
 		log.Info("webhook service created successfully", "name", ksvc.Name)
+		r.Recorder.Eventf(source, corev1.EventTypeNormal, reasonServiceCreated, "Created Knative Service %s", ksvc.Name)
 	}
 
 	// should update
@@ -217,16 +395,21 @@ func (r *GitHookReconciler) reconcileWebhookService(source *v1alpha1.GitHook) (*
 
 		templateUpdated := !apiequality.Semantic.DeepEqual(
 			desiredKsvc.Spec.ConfigurationSpec.Template.Spec.PodSpec,
-			ksvc.Spec.ConfigurationSpec.Template.Spec.PodSpec)
+			ksvc.Spec.ConfigurationSpec.Template.Spec.PodSpec) ||
+			!apiequality.Semantic.DeepEqual(
+				desiredKsvc.Spec.ConfigurationSpec.Template.Annotations,
+				ksvc.Spec.ConfigurationSpec.Template.Annotations)
 
 		if templateUpdated == true {
 			log.Info("webhook service template update")
 			desiredKsvc.Spec.ConfigurationSpec.Template.Spec.PodSpec.DeepCopyInto(&ksvc.Spec.ConfigurationSpec.Template.Spec.PodSpec)
+			ksvc.Spec.ConfigurationSpec.Template.Annotations = desiredKsvc.Spec.ConfigurationSpec.Template.Annotations
 
 			if err = r.Update(context.TODO(), ksvc); err != nil {
 				return nil, err
 			}
 			log.Info("webhook service template update successfully")
+			r.Recorder.Eventf(source, corev1.EventTypeNormal, reasonServiceUpdated, "Updated Knative Service %s", ksvc.Name)
 		}
 	}
 
@@ -241,29 +424,46 @@ func (r *GitHookReconciler) reconcileWebhookService(source *v1alpha1.GitHook) (*
 }
 
 // 生成期望 Knative Service 对象
-func (r *GitHookReconciler) generateKnativeServiceObject(source *v1alpha1.GitHook, receiveAdapterImage string) (*servinv1alpha1.Service, error) {
+func (r *GitHookReconciler) generateKnativeServiceObject(source *v1alpha1.GitHook, receiveAdapterImage string, rotation *tokenRotation) (*servinv1alpha1.Service, error) {
 	labels := map[string]string{
 		"receive-adapter": source.Name,
 	}
-	env := []corev1.EnvVar{
-		{
-			Name: "SECRET_TOKEN",
-			ValueFrom: &corev1.EnvVarSource{
-				SecretKeyRef: source.Spec.SecretToken.SecretKeyRef,
-			},
-		},
-	}
+	env := receiverSecretEnv(source, rotation)
 
-	runSpecJSON, err := json.Marshal(source.Spec.RunSpec)
-	if err != nil {
-		return nil, err
+	// 注解变化会生成新的 revision，使 receiver 重新读取 secret token
+	annotations := map[string]string{}
+	if rotation != nil {
+		annotations[secretTokenGenerationAnnotation] = rotation.generation
 	}
 
 	containerArgs := []string{
 		fmt.Sprintf("--gitprovider=%s", source.Spec.GitProvider),
 		fmt.Sprintf("--namespace=%s", source.Namespace),
 		fmt.Sprintf("--name=%s", source.Name),
-		fmt.Sprintf("--runSpecJSON=%s", string(runSpecJSON)),
+		fmt.Sprintf("--uid=%s", source.UID),
+	}
+
+	// 触发器、参数与 when 表达式由挂载的 ConfigMap 提供，修改后 receiver 自动重新加载
+	containerArgs = append(containerArgs, fmt.Sprintf("--config=%s", path.Join(receiverConfigDir, receiverConfigKey)))
+	volumes, volumeMounts := receiverVolumes(source)
+
+	apiArgs, apiEnv := receiverAPIArgs(source)
+	containerArgs = append(containerArgs, apiArgs...)
+	env = append(env, apiEnv...)
+
+	if source.Status.SinkURI != "" {
+		containerArgs = append(containerArgs, fmt.Sprintf("--sink=%s", source.Status.SinkURI))
+	}
+
+	if r.DeliveryLimit > 0 {
+		containerArgs = append(containerArgs, fmt.Sprintf("--delivery-limit=%d", r.DeliveryLimit))
+	}
+
+	if source.Spec.GitProvider == string(v1alpha1.Github) {
+		repo, err := model.ParseRepository(source.Spec.GitProvider, source.Spec.ProjectURL, source.Spec.ServerURL)
+		if err == nil && githookclient.IsGithubEnterprise(repo.BaseURL()) {
+			containerArgs = append(containerArgs, fmt.Sprintf("--github-enterprise-host=%s", repo.Hostname()))
+		}
 	}
 
 	ksvc := &servinv1alpha1.Service{
@@ -275,15 +475,20 @@ func (r *GitHookReconciler) generateKnativeServiceObject(source *v1alpha1.GitHoo
 		Spec: servinv1alpha1.ServiceSpec{
 			ConfigurationSpec: servinv1alpha1.ConfigurationSpec{
 				Template: &servinv1alpha1.RevisionTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Annotations: annotations,
+					},
 					Spec: servinv1alpha1.RevisionSpec{
 						RevisionSpec: servingv1beta1.RevisionSpec{
 							PodSpec: servingv1beta1.PodSpec{
 								ServiceAccountName: runKsvcAs,
 								Containers: []corev1.Container{corev1.Container{
-									Image: receiveAdapterImage,
-									Env:   env,
-									Args:  containerArgs,
+									Image:        receiveAdapterImage,
+									Env:          env,
+									Args:         containerArgs,
+									VolumeMounts: volumeMounts,
 								}},
+								Volumes: volumes,
 							},
 						},
 					},
@@ -337,23 +542,35 @@ func (r *GitHookReconciler) waitForKnativeServiceReady(source *v1alpha1.GitHook)
 func (r *GitHookReconciler) buildHookFromSource(source *v1alpha1.GitHook) (*model.HookOptions, error) {
 	hookOptions := &model.HookOptions{}
 
-	baseURL, owner, projectName, err := parseGitURL(source.Spec.ProjectURL)
+	repo, err := model.ParseRepository(source.Spec.GitProvider, source.Spec.ProjectURL, source.Spec.ServerURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process project url to get the project name: " + err.Error())
 	}
 
-	hookOptions.BaseURL = baseURL
-	hookOptions.Project = projectName
-	hookOptions.Owner = owner
+	hookOptions.Repository = repo
+	hookOptions.BaseURL = repo.BaseURL()
+	hookOptions.Project = repo.Name
+	hookOptions.Owner = repo.Namespace
 	hookOptions.ID = source.Status.ID
+	hookOptions.SSLVerify = source.Spec.SSLVerify
+	hookOptions.Marker = webhookMarker(source)
 
-	for _, event := range source.Spec.EventTypes {
-		hookOptions.Events = append(hookOptions.Events, string(event))
-	}
-	hookOptions.AccessToken, err = r.secretFrom(source.Namespace, source.Spec.AccessToken.SecretKeyRef)
+	hookOptions.Events = webhookEvents(source)
+	if source.Spec.GithubApp != nil {
+		hookOptions.GithubApp, err = r.githubAppFrom(source)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if source.Spec.AccessToken.SecretKeyRef == nil {
+			return nil, fmt.Errorf("either accessToken or githubApp must be specified")
+		}
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to get accesstoken from secret %s/%s", source.Namespace, source.Spec.AccessToken.SecretKeyRef.Key)
+		hookOptions.AccessToken, err = r.secretFrom(source.Namespace, source.Spec.AccessToken.SecretKeyRef)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to get accesstoken from secret %s/%s", source.Namespace, source.Spec.AccessToken.SecretKeyRef.Key)
+		}
 	}
 
 	hookOptions.SecretToken, err = r.secretFrom(source.Namespace, source.Spec.SecretToken.SecretKeyRef)
@@ -362,21 +579,84 @@ func (r *GitHookReconciler) buildHookFromSource(source *v1alpha1.GitHook) (*mode
 		return nil, fmt.Errorf("failed to get secret token from secret %s/%s", source.Namespace, source.Spec.AccessToken.SecretKeyRef.Key)
 	}
 
+	hookOptions.Transport, err = r.transportFrom(source)
+	if err != nil {
+		return nil, err
+	}
+
 	return hookOptions, nil
 }
 
-func parseGitURL(gitURL string) (baseURL string, owner string, project string, err error) {
-	u, err := url.Parse(gitURL)
+// githubAppFrom 读取 GitHub App 的认证信息
+func (r *GitHookReconciler) githubAppFrom(source *v1alpha1.GitHook) (*model.GithubAppOptions, error) {
+	app := source.Spec.GithubApp
+	if source.Spec.GitProvider != string(v1alpha1.Github) {
+		return nil, fmt.Errorf("githubApp is not supported by git provider %s", source.Spec.GitProvider)
+	}
+	if app.PrivateKey.SecretKeyRef == nil {
+		return nil, fmt.Errorf("githubApp privateKey must be specified")
+	}
+
+	privateKey, err := r.secretFrom(source.Namespace, app.PrivateKey.SecretKeyRef)
 	if err != nil {
-		return "", "", "", err
+		return nil, fmt.Errorf("failed to get github app private key from secret %s/%s: %s", source.Namespace, app.PrivateKey.SecretKeyRef.Name, err)
 	}
 
-	paths := strings.Split(u.Path[1:], "/")
-	baseURL = fmt.Sprintf("%s://%s", u.Scheme, u.Host)
-	owner = paths[0]
-	project = paths[1]
+	return &model.GithubAppOptions{
+		AppID:          app.AppID,
+		InstallationID: app.InstallationID,
+		PrivateKey:     []byte(privateKey),
+	}, nil
+}
+
+// transportFrom 读取访问 Git 服务 API 的 CA、TLS 与代理配置
+func (r *GitHookReconciler) transportFrom(source *v1alpha1.GitHook) (*model.TransportOptions, error) {
+	transport := source.Spec.Transport
+	if transport == nil {
+		return nil, nil
+	}
 
-	return baseURL, owner, project, nil
+	options := &model.TransportOptions{
+		InsecureSkipVerify: transport.InsecureSkipVerify,
+		ProxyURL:           transport.ProxyURL,
+		NoProxy:            transport.NoProxy,
+	}
+
+	if transport.CABundle == nil {
+		return options, nil
+	}
+
+	switch {
+	case transport.CABundle.SecretKeyRef != nil:
+		caBundle, err := r.secretFrom(source.Namespace, transport.CABundle.SecretKeyRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ca bundle from secret %s/%s: %s", source.Namespace, transport.CABundle.SecretKeyRef.Name, err)
+		}
+		options.CABundle = []byte(caBundle)
+	case transport.CABundle.ConfigMapKeyRef != nil:
+		caBundle, err := r.configMapFrom(source.Namespace, transport.CABundle.ConfigMapKeyRef)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ca bundle from configmap %s/%s: %s", source.Namespace, transport.CABundle.ConfigMapKeyRef.Name, err)
+		}
+		options.CABundle = []byte(caBundle)
+	}
+
+	return options, nil
+}
+
+func (r *GitHookReconciler) configMapFrom(namespace string, configMapKeySelector *corev1.ConfigMapKeySelector) (string, error) {
+	configMap := &corev1.ConfigMap{}
+	err := r.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: configMapKeySelector.Name}, configMap)
+	if err != nil {
+		return "", err
+	}
+
+	configMapVal, ok := configMap.Data[configMapKeySelector.Key]
+	if !ok {
+		return "", fmt.Errorf(`key "%s" not found in configmap "%s"`, configMapKeySelector.Key, configMapKeySelector.Name)
+	}
+
+	return configMapVal, nil
 }
 
 func (r *GitHookReconciler) getSecret(namespace string, secretKeySelector *corev1.SecretKeySelector) (*corev1.Secret, error) {
@@ -400,6 +680,11 @@ func (r *GitHookReconciler) secretFrom(namespace string, secretKeySelector *core
 	return string(secretVal), nil
 }
 
+// hasRunSpec 是否指定了事件触发时运行的 pipelinerun
+func hasRunSpec(source *v1alpha1.GitHook) bool {
+	return !apiequality.Semantic.DeepEqual(source.Spec.RunSpec, tektonv1alpha1.PipelineRunSpec{})
+}
+
 func (r *GitHookReconciler) addFinalizer(source *v1alpha1.GitHook) {
 	source.Finalizers = insertFinalizer(source.Finalizers)
 }
@@ -410,16 +695,42 @@ func insertFinalizer(finalizers []string) []string {
 	return set.List()
 }
 
+// gitClient 创建调用 Git 服务 API 的 client
+func (r *GitHookReconciler) gitClient(source *v1alpha1.GitHook, options *model.HookOptions) (*githook.Client, error) {
+	if r.GitClients != nil {
+		return r.GitClients(source, options)
+	}
+	return getGitClient(source, options)
+}
+
 func getGitClient(source *v1alpha1.GitHook, options *model.HookOptions) (*githook.Client, error) {
 	var gitClient githook.GitClient
 
+	httpClient, err := githookclient.NewHTTPClient(options.Transport)
+	if err != nil {
+		return nil, err
+	}
+
 	switch source.Spec.GitProvider {
 	case string(v1alpha1.Gogs):
-		gitClient = githookclient.NewGogsClient(options.BaseURL, options.AccessToken)
+		gitClient = githookclient.NewGogsClient(options.BaseURL, options.AccessToken, httpClient)
 	case string(v1alpha1.Github):
-		gitClient = githookclient.NewGithubClient(options.AccessToken)
+		var githubClient *githookclient.GithubClient
+		if options.GithubApp != nil {
+			githubClient, err = githookclient.NewGithubAppClient(options.BaseURL, options.GithubApp, options.Owner, options.Project, httpClient)
+		} else {
+			githubClient, err = githookclient.NewGithubClient(options.BaseURL, options.AccessToken, httpClient)
+		}
+		if err != nil {
+			return nil, err
+		}
+		gitClient = githubClient
 	case string(v1alpha1.Gitlab):
-		gitClient = githookclient.NewGitlabClient(options.BaseURL, options.AccessToken)
+		gitlabClient, err := githookclient.NewGitlabClient(options.BaseURL, options.AccessToken, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		gitClient = gitlabClient
 	default:
 		return nil, fmt.Errorf("git provider %s not support", source.Spec.GitProvider)
 	}
@@ -427,8 +738,73 @@ func getGitClient(source *v1alpha1.GitHook, options *model.HookOptions) (*githoo
 	return githook.New(gitClient, options.BaseURL, options.AccessToken)
 }
 
-// 删除逻辑
-func (r *GitHookReconciler) finalize(*v1alpha1.GitHook) error {
+// 删除逻辑：删除 git 仓库中的 webhook 后移除 finalizer
+func (r *GitHookReconciler) finalize(source *v1alpha1.GitHook) error {
+	if source.Status.ID != "" {
+		if err := r.deleteWebhook(source); err != nil {
+			// 暂时性错误稍后重试，其它错误保留 hook，避免 GitHook 无法删除
+			if githookclient.IsTransient(err) || githookclient.IsRateLimited(err) {
+				return err
+			}
+			r.Recorder.Eventf(source, corev1.EventTypeWarning, reasonWebhookDeleteFailed,
+				"Webhook %s could not be deleted and is left in place: %s", source.Status.ID, err)
+		}
+	}
+
+	r.removeFinalizer(source)
+	return nil
+}
+
+func (r *GitHookReconciler) deleteWebhook(source *v1alpha1.GitHook) error {
+	hookOptions, err := r.buildHookFromSource(source)
+	if err != nil {
+		return err
+	}
+
+	gitClient, err := r.gitClient(source, hookOptions)
+	if err != nil {
+		return err
+	}
+
+	err = gitClient.Delete(hookOptions)
+	if githookclient.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	r.Recorder.Eventf(source, corev1.EventTypeNormal, reasonWebhookDeleted,
+		"Deleted webhook %s on %s/%s", hookOptions.ID, hookOptions.Owner, hookOptions.Project)
+	return nil
+}
+
+func (r *GitHookReconciler) removeFinalizer(source *v1alpha1.GitHook) {
+	set := sets.NewString(source.Finalizers...)
+	set.Delete(finalizerName)
+	source.Finalizers = set.List()
+}
+
+// consumeAnnotation 在处理一次性请求注解前先从 GitHook 上移除，注解值已变化或 GitHook 已被修改时
+// 返回错误，由下一次调和处理。移除后更新 source 的 resourceVersion，后续的 Update 不会因此冲突
+func (r *GitHookReconciler) consumeAnnotation(source *v1alpha1.GitHook, key, value string) error {
+	annotation := "/metadata/annotations/" + strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+	patch, err := json.Marshal([]map[string]string{
+		{"op": "test", "path": "/metadata/resourceVersion", "value": source.ResourceVersion},
+		{"op": "test", "path": annotation, "value": value},
+		{"op": "remove", "path": annotation},
+	})
+	if err != nil {
+		return err
+	}
+
+	patched := source.DeepCopy()
+	if err := r.Patch(context.Background(), patched, client.ConstantPatch(types.JSONPatchType, patch)); err != nil {
+		return fmt.Errorf("failed to remove %s annotation: %s", key, err)
+	}
+
+	delete(source.Annotations, key)
+	source.ResourceVersion = patched.ResourceVersion
 	return nil
 }
 
@@ -461,8 +837,15 @@ func (r *GitHookReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
+	if err := mgr.GetFieldIndexer().IndexField(&v1alpha1.GitHook{}, secretRefIndexKey, indexSecretRefs); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1alpha1.GitHook{}).
 		Owns(&servinv1alpha1.Service{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.mapSecretToGitHooks),
+		}).
 		Complete(r)
 }
@@ -1,27 +1,44 @@
 package controllers
 
 import (
-	"strings"
+	"fmt"
+	"net/url"
 
 	servinv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
 	"github.com/zhd173/githook/api/v1alpha1"
+	githookclient "github.com/zhd173/githook/pkg/client"
 )
 
+// getWebhookURL receiver 地址的 scheme 统一取自 Knative 发布的 URL，sslVerify 只控制 provider 的 hook SSL 校验
 func getWebhookURL(source *v1alpha1.GitHook, ksvc *servinv1alpha1.Service) string {
-	if ksvc.Status.DeprecatedDomain != "" {
-		if source.Spec.SSLVerify {
-			return "https://" + ksvc.Status.DeprecatedDomain
-		}
-		return "http://" + ksvc.Status.DeprecatedDomain
+	if ksvc.Status.URL != nil && ksvc.Status.URL.Host != "" {
+		return withMarker(ksvc.Status.URL.String(), source)
 	}
 
-	webhookURL := ksvc.Status.URL.String()
+	// 旧版本 Knative 只发布 domain，按其默认的 http scheme 拼接
+	return withMarker("http://"+ksvc.Status.DeprecatedDomain, source)
+}
+
+// webhookMarker 标识 GitHook 注册的 webhook，receiver 地址变化后仍可认领已有 hook
+func webhookMarker(source *v1alpha1.GitHook) string {
+	return fmt.Sprintf("%s/%s", source.Namespace, source.Name)
+}
 
-	if source.Spec.SSLVerify {
-		webhookURL = strings.Replace(webhookURL, "http://", "https://", 1)
-	} else {
-		webhookURL = strings.Replace(webhookURL, "https://", "http://", 1)
+func withMarker(webhookURL string, source *v1alpha1.GitHook) string {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return webhookURL
 	}
 
-	return webhookURL
+	query := u.Query()
+	query.Set(githookclient.MarkerParam, webhookMarker(source))
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// syncRequested 是否需要强制重新注册 git webhook，sync 注解在注册成功后才清除，失败时随调和重试
+func syncRequested(source *v1alpha1.GitHook) bool {
+	_, ok := source.Annotations[v1alpha1.SyncAnnotation]
+	return ok
 }
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/knative/pkg/apis"
+	servinv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/zhd173/githook/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetWebhookURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       *apis.URL
+		domain    string
+		sslVerify bool
+		expected  string
+	}{
+		{"url", &apis.URL{Scheme: "https", Host: "hook.default.example.com"}, "hook.default.example.com", false,
+			"https://hook.default.example.com?githook=default%2Fhook"},
+		{"url ignores sslVerify", &apis.URL{Scheme: "http", Host: "hook.default.example.com"}, "hook.default.example.com", true,
+			"http://hook.default.example.com?githook=default%2Fhook"},
+		{"domain only", nil, "hook.default.example.com", true,
+			"http://hook.default.example.com?githook=default%2Fhook"},
+	}
+
+	for _, test := range tests {
+		source := &v1alpha1.GitHook{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hook"}}
+		source.Spec.SSLVerify = test.sslVerify
+		ksvc := &servinv1alpha1.Service{}
+		ksvc.Status.URL = test.url
+		ksvc.Status.DeprecatedDomain = test.domain
+
+		if got := getWebhookURL(source, ksvc); got != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.name, test.expected, got)
+		}
+	}
+}
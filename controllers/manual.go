@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	tektonv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/zhd173/githook/api/v1alpha1"
+	"github.com/zhd173/githook/pkg/githook"
+	"github.com/zhd173/githook/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// manualRun 手动运行请求，即 run 注解的值
+type manualRun struct {
+	Ref     string `json:"ref"`
+	Trigger string `json:"trigger,omitempty"`
+}
+
+// runManual 处理 run 注解，失败不重试，需要重新添加注解。注解在运行前单独移除，
+// 之后 GitHook 的更新冲突重新调和时不会再次运行
+func (r *GitHookReconciler) runManual(source *v1alpha1.GitHook, hookOptions *model.HookOptions) {
+	value, ok := source.Annotations[v1alpha1.RunAnnotation]
+	if !ok {
+		return
+	}
+	if err := r.consumeAnnotation(source, v1alpha1.RunAnnotation, value); err != nil {
+		r.sourceLogger(source).Error(err, "Failed to run manually", "run", value)
+		return
+	}
+
+	pipelineRun, err := r.createManualRun(source, hookOptions, value)
+	if err != nil {
+		r.sourceLogger(source).Error(err, "Failed to run manually", "run", value)
+		r.Recorder.Eventf(source, corev1.EventTypeWarning, reasonManualRunFailed, "Failed to run %s: %s", value, err)
+		return
+	}
+
+	if source.Spec.DryRun {
+		rendered, _ := json.Marshal(pipelineRun)
+		r.Recorder.Eventf(source, corev1.EventTypeNormal, reasonManualRunRendered, "Rendered PipelineRun for %s, not created in dry run: %s", value, rendered)
+		return
+	}
+	r.Recorder.Eventf(source, corev1.EventTypeNormal, reasonManualRunCreated, "Created PipelineRun %s for %s", pipelineRun.Name, value)
+}
+
+func (r *GitHookReconciler) createManualRun(source *v1alpha1.GitHook, hookOptions *model.HookOptions, value string) (*tektonv1alpha1.PipelineRun, error) {
+	if r.TektonClient == nil {
+		return nil, fmt.Errorf("manual runs are not enabled")
+	}
+
+	run := &manualRun{}
+	if err := json.Unmarshal([]byte(value), run); err != nil {
+		return nil, fmt.Errorf("invalid %s annotation: %s", v1alpha1.RunAnnotation, err)
+	}
+	if run.Ref == "" {
+		return nil, fmt.Errorf("invalid %s annotation: ref is required", v1alpha1.RunAnnotation)
+	}
+
+	trigger, err := r.manualTrigger(source, run.Trigger)
+	if err != nil {
+		return nil, err
+	}
+
+	gitClient, err := r.gitClient(source, hookOptions)
+	if err != nil {
+		return nil, err
+	}
+	sha, err := gitClient.ResolveRef(hookOptions, run.Ref)
+	if err != nil {
+		return nil, err
+	}
+
+	event := manualEvent(source, hookOptions.Repository, run.Ref, sha)
+	return githook.RunManual(r.TektonClient, source.Namespace, source.Name, trigger, receiverParams(source.Spec.Params), event, source.Spec.DryRun)
+}
+
+// manualTrigger 手动运行的触发器，模板在 controller 中直接从 ConfigMap 读取
+func (r *GitHookReconciler) manualTrigger(source *v1alpha1.GitHook, name string) (githook.TriggerConfig, error) {
+	for _, spec := range triggerSpecs(source) {
+		if spec.Name != name {
+			continue
+		}
+
+		config, err := triggerConfig(spec)
+		if err != nil || spec.RunTemplateRef == nil {
+			return config, err
+		}
+
+		configMap := &corev1.ConfigMap{}
+		err = r.Get(context.TODO(), client.ObjectKey{Namespace: source.Namespace, Name: spec.RunTemplateRef.Name}, configMap)
+		if err != nil {
+			return config, fmt.Errorf("failed to get run template %s: %s", spec.RunTemplateRef.Name, err)
+		}
+		runSpec, err := yaml.YAMLToJSON([]byte(configMap.Data[runTemplateKey(spec.RunTemplateRef)]))
+		if err != nil {
+			return config, fmt.Errorf("invalid run template %s: %s", spec.RunTemplateRef.Name, err)
+		}
+		config.RunTemplate = ""
+		config.RunSpec = runSpec
+		return config, nil
+	}
+
+	if name == "" {
+		return githook.TriggerConfig{}, fmt.Errorf("the GitHook has no runSpec, a trigger must be named")
+	}
+	return githook.TriggerConfig{}, fmt.Errorf("unknown trigger %s", name)
+}
+
+// manualEvent 手动运行的事件，tag 需以 refs/tags/ 开头的完整引用指定
+func manualEvent(source *v1alpha1.GitHook, repo *model.Repository, ref, sha string) *model.GitEvent {
+	event := &model.GitEvent{
+		Provider: source.Spec.GitProvider,
+		Type:     githook.EventManual,
+		Repository: model.GitRepository{
+			FullName: repo.FullName(),
+			Name:     repo.Name,
+			Owner:    repo.Namespace,
+			CloneURL: repo.CloneURL(),
+			URL:      repo.BaseURL() + "/" + repo.FullName(),
+		},
+		After: sha,
+	}
+
+	switch {
+	case strings.HasPrefix(ref, "refs/"):
+		event.Ref = ref
+		event.Tag = strings.TrimPrefix(ref, "refs/tags/")
+		if event.Tag == ref {
+			event.Tag = ""
+		}
+	case len(ref) >= 7 && strings.HasPrefix(sha, ref):
+		// 直接指定的 commit 没有对应的引用
+	default:
+		event.Ref = model.BranchRef(ref)
+	}
+
+	return event
+}
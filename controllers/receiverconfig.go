@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/zhd173/githook/api/v1alpha1"
+	"github.com/zhd173/githook/pkg/githook"
+	"github.com/zhd173/githook/pkg/tekton"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// receiverConfigKey receiver 配置在 ConfigMap 中的键
+	receiverConfigKey = "config.json"
+	// receiverConfigDir receiver 配置的挂载目录
+	receiverConfigDir = "/etc/githook/config"
+	// runTemplateDir 模板 ConfigMap 的挂载目录，每个 ConfigMap 挂载到以其名称命名的子目录
+	runTemplateDir = "/etc/githook/templates"
+
+	defaultRunTemplateKey = "runSpec"
+)
+
+func receiverConfigName(source *v1alpha1.GitHook) string {
+	return fmt.Sprintf("%s-githook-receiver", source.Name)
+}
+
+// receiverConfig 生成 receiver 的配置，以 ConfigMap 挂载到 receiver，
+// 修改触发器与参数只更新 ConfigMap，不会生成新的 ksvc revision
+func receiverConfig(source *v1alpha1.GitHook) (*githook.ReceiverConfig, error) {
+	triggers, err := receiverTriggers(source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &githook.ReceiverConfig{
+		When:     source.Spec.When,
+		Params:   receiverParams(source.Spec.Params),
+		Triggers: triggers,
+		ChatOps:  receiverChatOps(source),
+
+		PullRequest: receiverPullRequest(source),
+		DryRun:      source.Spec.DryRun,
+		RateLimit:   receiverRateLimit(source.Spec.RateLimit),
+
+		CommitStatus: source.Spec.CommitStatus,
+	}, nil
+}
+
+func receiverRateLimit(rateLimit *v1alpha1.RateLimitSpec) *githook.RateLimitConfig {
+	if rateLimit == nil || (rateLimit.DebounceSeconds == 0 && rateLimit.MaxPerMinute == 0) {
+		return nil
+	}
+	return &githook.RateLimitConfig{
+		DebounceSeconds: int(rateLimit.DebounceSeconds),
+		MaxPerMinute:    int(rateLimit.MaxPerMinute),
+	}
+}
+
+func receiverParams(params []v1alpha1.ParamSpec) []tekton.Param {
+	var receiverParams []tekton.Param
+	for _, param := range params {
+		receiverParams = append(receiverParams, tekton.Param{
+			Name:     param.Name,
+			JSONPath: param.JSONPath,
+			Value:    param.Value,
+			Default:  param.Default,
+		})
+	}
+	return receiverParams
+}
+
+// reconcileReceiverConfig 将 receiver 配置写入 GitHook 拥有的 ConfigMap
+func (r *GitHookReconciler) reconcileReceiverConfig(source *v1alpha1.GitHook) error {
+	config, err := receiverConfig(source)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{}
+	err = r.Get(context.TODO(), client.ObjectKey{Namespace: source.Namespace, Name: receiverConfigName(source)}, configMap)
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			return err
+		}
+
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      receiverConfigName(source),
+				Namespace: source.Namespace,
+			},
+			Data: map[string]string{
+				receiverConfigKey: string(data),
+			},
+		}
+		if err := ctrl.SetControllerReference(source, configMap, r.Scheme); err != nil {
+			return err
+		}
+		return r.Create(context.TODO(), configMap)
+	}
+
+	if configMap.Data[receiverConfigKey] == string(data) {
+		return nil
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[receiverConfigKey] = string(data)
+	return r.Update(context.TODO(), configMap)
+}
+
+// checkRunTemplates 确认引用的模板存在，避免 receiver 因挂载失败无法启动
+func (r *GitHookReconciler) checkRunTemplates(source *v1alpha1.GitHook) error {
+	for _, ref := range runTemplateRefs(source) {
+		configMap := &corev1.ConfigMap{}
+		err := r.Get(context.TODO(), client.ObjectKey{Namespace: source.Namespace, Name: ref.Name}, configMap)
+		if err != nil {
+			return fmt.Errorf("failed to get run template %s: %s", ref.Name, err)
+		}
+		if _, ok := configMap.Data[runTemplateKey(ref)]; !ok {
+			return fmt.Errorf("run template %s has no key %s", ref.Name, runTemplateKey(ref))
+		}
+	}
+	return nil
+}
+
+// receiverVolumes receiver 配置与模板 ConfigMap 的挂载
+func receiverVolumes(source *v1alpha1.GitHook) ([]corev1.Volume, []corev1.VolumeMount) {
+	volumes := []corev1.Volume{{
+		Name: "config",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: receiverConfigName(source)},
+			},
+		},
+	}}
+	mounts := []corev1.VolumeMount{{
+		Name:      "config",
+		MountPath: receiverConfigDir,
+		ReadOnly:  true,
+	}}
+
+	names := map[string]bool{}
+	for _, ref := range runTemplateRefs(source) {
+		names[ref.Name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	for i, name := range sorted {
+		volumeName := fmt.Sprintf("run-template-%d", i)
+		volumes = append(volumes, corev1.Volume{
+			Name: volumeName,
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      volumeName,
+			MountPath: path.Join(runTemplateDir, name),
+			ReadOnly:  true,
+		})
+	}
+
+	return volumes, mounts
+}
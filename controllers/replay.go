@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	servinv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
+	"github.com/zhd173/githook/api/v1alpha1"
+	"github.com/zhd173/githook/pkg/githook"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// replayTimeout 重放请求的超时，receiver 在返回前创建 PipelineRun
+const replayTimeout = 30 * time.Second
+
+var replayClient = &http.Client{Timeout: replayTimeout}
+
+// replayDelivery 处理 replay 注解，请求 receiver 重放保存的 delivery，失败不重试，需要重新添加注解。
+// 注解在请求前单独移除，请求在后台进行，不阻塞调和，结果以 Event 报告
+func (r *GitHookReconciler) replayDelivery(source *v1alpha1.GitHook, ksvc *servinv1alpha1.Service, secretToken string) {
+	id, ok := source.Annotations[v1alpha1.ReplayAnnotation]
+	if !ok {
+		return
+	}
+	if err := r.consumeAnnotation(source, v1alpha1.ReplayAnnotation, id); err != nil {
+		r.sourceLogger(source).Error(err, "Failed to replay delivery", "delivery", id)
+		return
+	}
+
+	go r.reportReplay(source.DeepCopy(), ksvc.DeepCopy(), secretToken, id)
+}
+
+// reportReplay 请求 receiver 重放 delivery，并在 GitHook 上记录结果
+func (r *GitHookReconciler) reportReplay(source *v1alpha1.GitHook, ksvc *servinv1alpha1.Service, secretToken, id string) {
+	record, err := r.requestReplay(ksvc, secretToken, id)
+	if err != nil {
+		r.sourceLogger(source).Error(err, "Failed to replay delivery", "delivery", id)
+		r.Recorder.Eventf(source, corev1.EventTypeWarning, reasonDeliveryReplayFailed, "Failed to replay delivery %s: %s", id, err)
+		return
+	}
+
+	message := fmt.Sprintf("Replayed %s delivery %s: %s", record.Event, id, record.Decision)
+	if len(record.Runs) > 0 {
+		message += fmt.Sprintf(", created PipelineRun %s", strings.Join(record.Runs, ", "))
+	}
+	if record.DryRun {
+		message += fmt.Sprintf(", rendered %d PipelineRun in dry run", len(record.Rendered))
+	}
+	r.Recorder.Event(source, corev1.EventTypeNormal, reasonDeliveryReplayed, message)
+}
+
+func (r *GitHookReconciler) requestReplay(ksvc *servinv1alpha1.Service, secretToken, id string) (*githook.DeliveryRecord, error) {
+	if r.DeliveryLimit <= 0 {
+		return nil, fmt.Errorf("deliveries are not stored")
+	}
+	if ksvc.Status.Address == nil {
+		return nil, fmt.Errorf("the receiver has no address")
+	}
+
+	address := ksvc.Status.Address.GetURL()
+	req, err := githook.NewReplayRequest(address.String(), secretToken, id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := replayClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// 处理失败时 receiver 仍返回 delivery 记录
+	record := &githook.DeliveryRecord{}
+	if err := json.Unmarshal(body, record); err != nil {
+		return nil, fmt.Errorf("receiver answered %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	if record.Error != "" {
+		return nil, fmt.Errorf("%s", record.Error)
+	}
+	return record, nil
+}
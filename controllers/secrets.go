@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/zhd173/githook/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	secretRefIndexKey = ".spec.secretRefs"
+
+	// secretTokenGenerationAnnotation 记录 receiver 使用的 secret token 轮换次数，token 变化时触发 ksvc 新 revision
+	secretTokenGenerationAnnotation = "githook.tools/secret-token-generation"
+
+	tokenSecretCurrentKey    = "current"
+	tokenSecretPreviousKey   = "previous"
+	tokenSecretGenerationKey = "generation"
+	// tokenSecretPushedKey 最近一次成功同步到 git webhook 的 token 摘要，与当前 token 不一致时需要更新 webhook
+	tokenSecretPushedKey = "pushed"
+	// tokenSecretPushedAtKey 当前 token 同步到 git webhook 的时间，旧 token 的 overlap 从此时开始计算
+	tokenSecretPushedAtKey = "pushedAt"
+
+	// DefaultSecretRotationOverlap is how long the previous secret token is
+	// still accepted by the receiver after a rotation
+	DefaultSecretRotationOverlap = 10 * time.Minute
+)
+
+// tokenRotation describes the secret token state the receiver has to serve
+type tokenRotation struct {
+	// pending is true until the current secret token has been pushed to the
+	// git webhook, across reconciles
+	pending bool
+	// hash of the current secret token, recorded once it has been pushed
+	hash string
+	// generation counts the rotations, it rolls the receiver when it changes
+	generation string
+	// keepPrevious is true while the receiver accepts the previous token
+	keepPrevious bool
+	// previousExpiry is the end of the overlap once the current token has
+	// been pushed, zero while it is pending
+	previousExpiry time.Time
+}
+
+func tokenSecretName(source *v1alpha1.GitHook) string {
+	return fmt.Sprintf("%s-githook-token", source.Name)
+}
+
+// secretRefs returns the names of the Secrets referenced by the GitHook
+func secretRefs(source *v1alpha1.GitHook) []string {
+	names := []string{}
+	refs := []*corev1.SecretKeySelector{
+		source.Spec.AccessToken.SecretKeyRef,
+		source.Spec.SecretToken.SecretKeyRef,
+	}
+	if source.Spec.GithubApp != nil {
+		refs = append(refs, source.Spec.GithubApp.PrivateKey.SecretKeyRef)
+	}
+	if transport := source.Spec.Transport; transport != nil && transport.CABundle != nil {
+		refs = append(refs, transport.CABundle.SecretKeyRef)
+	}
+
+	for _, ref := range refs {
+		if ref != nil && ref.Name != "" {
+			names = append(names, ref.Name)
+		}
+	}
+	return names
+}
+
+// mapSecretToGitHooks enqueues every GitHook referencing the changed Secret
+func (r *GitHookReconciler) mapSecretToGitHooks(obj handler.MapObject) []reconcile.Request {
+	list := &v1alpha1.GitHookList{}
+	err := r.List(context.Background(), list, client.InNamespace(obj.Meta.GetNamespace()), client.MatchingField(secretRefIndexKey, obj.Meta.GetName()))
+	if err != nil {
+		r.Log.Error(err, "unable to list githooks referencing secret", "secret", obj.Meta.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, source := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: source.Namespace,
+			Name:      source.Name,
+		}})
+	}
+	return requests
+}
+
+// reconcileSecretToken 将 secret token 拷贝到 GitHook 拥有的 Secret 中，
+// token 变化时保留旧 token，供 receiver 在 overlap 时间窗口内继续校验旧签名
+func (r *GitHookReconciler) reconcileSecretToken(source *v1alpha1.GitHook, token string) (*tokenRotation, error) {
+	log := r.sourceLogger(source)
+
+	secret := &corev1.Secret{}
+	err := r.Get(context.TODO(), client.ObjectKey{Namespace: source.Namespace, Name: tokenSecretName(source)}, secret)
+	if err != nil {
+		if !apierrs.IsNotFound(err) {
+			return nil, err
+		}
+
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      tokenSecretName(source),
+				Namespace: source.Namespace,
+			},
+			Data: map[string][]byte{},
+		}
+		rotation, _ := rotateToken(secret.Data, token, time.Now(), r.secretRotationOverlap())
+		if err := ctrl.SetControllerReference(source, secret, r.Scheme); err != nil {
+			return nil, err
+		}
+		if err := r.Create(context.TODO(), secret); err != nil {
+			return nil, err
+		}
+		return rotation, nil
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	rotation, rotated := rotateToken(secret.Data, token, time.Now(), r.secretRotationOverlap())
+	if rotated {
+		log.Info("secret token rotated", "generation", rotation.generation)
+		if err := r.Update(context.TODO(), secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return rotation, nil
+}
+
+// rotateToken 根据 token Secret 的内容计算 receiver 需要接受的 token，token 变化时修改 data 并返回 true。
+// 旧 token 在新 token 同步到 git webhook 之前一直有效，同步后再保留 overlap 时间
+func rotateToken(data map[string][]byte, token string, now time.Time, overlap time.Duration) (*tokenRotation, bool) {
+	rotated := false
+	if current := string(data[tokenSecretCurrentKey]); current != token {
+		// 上一次轮换尚未同步时 git 服务仍使用 previous 签名，只替换 current
+		if string(data[tokenSecretPushedKey]) == hashToken(current) {
+			data[tokenSecretPreviousKey] = data[tokenSecretCurrentKey]
+			delete(data, tokenSecretPushedAtKey)
+		}
+		data[tokenSecretCurrentKey] = []byte(token)
+		generation, _ := strconv.Atoi(string(data[tokenSecretGenerationKey]))
+		data[tokenSecretGenerationKey] = []byte(strconv.Itoa(generation + 1))
+		rotated = true
+	}
+
+	rotation := &tokenRotation{
+		hash:       hashToken(token),
+		generation: string(data[tokenSecretGenerationKey]),
+	}
+	// 之前的调和在更新 webhook 前失败时，token 仍待同步
+	rotation.pending = string(data[tokenSecretPushedKey]) != rotation.hash
+
+	if len(data[tokenSecretPreviousKey]) == 0 {
+		return rotation, rotated
+	}
+	if rotation.pending {
+		rotation.keepPrevious = true
+		return rotation, rotated
+	}
+	if pushedAt, err := time.Parse(time.RFC3339, string(data[tokenSecretPushedAtKey])); err == nil {
+		if expiry := pushedAt.Add(overlap); now.Before(expiry) {
+			rotation.keepPrevious = true
+			rotation.previousExpiry = expiry
+		}
+	}
+	return rotation, rotated
+}
+
+// markTokenPushed 在 webhook 成功更新后记录已同步的 token 与同步时间，旧 token 的 overlap 从此时开始
+func (r *GitHookReconciler) markTokenPushed(source *v1alpha1.GitHook, rotation *tokenRotation) error {
+	if !rotation.pending {
+		return nil
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: source.Namespace, Name: tokenSecretName(source)}, secret); err != nil {
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	now := time.Now().UTC()
+	secret.Data[tokenSecretPushedKey] = []byte(rotation.hash)
+	secret.Data[tokenSecretPushedAtKey] = []byte(now.Format(time.RFC3339))
+	if err := r.Update(context.TODO(), secret); err != nil {
+		return err
+	}
+
+	rotation.pending = false
+	if rotation.keepPrevious {
+		rotation.previousExpiry = now.Add(r.secretRotationOverlap())
+	}
+	return nil
+}
+
+// requeueBefore 在旧 token 的 overlap 结束时重新调和，及时从 receiver 中移除旧 token
+func requeueBefore(result ctrl.Result, rotation *tokenRotation) ctrl.Result {
+	if rotation == nil || rotation.previousExpiry.IsZero() {
+		return result
+	}
+
+	untilExpiry := time.Until(rotation.previousExpiry) + time.Second
+	if result.RequeueAfter == 0 || untilExpiry < result.RequeueAfter {
+		result.RequeueAfter = untilExpiry
+	}
+	return result
+}
+
+func (r *GitHookReconciler) secretRotationOverlap() time.Duration {
+	if r.SecretRotationOverlap > 0 {
+		return r.SecretRotationOverlap
+	}
+	return DefaultSecretRotationOverlap
+}
+
+// receiverSecretEnv 生成 receiver 校验签名所需的环境变量
+func receiverSecretEnv(source *v1alpha1.GitHook, rotation *tokenRotation) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{
+			Name: "SECRET_TOKEN",
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: source.Spec.SecretToken.SecretKeyRef,
+			},
+		},
+	}
+
+	if rotation == nil || !rotation.keepPrevious {
+		return env
+	}
+
+	optional := true
+	env = append(env, corev1.EnvVar{
+		Name: "PREVIOUS_SECRET_TOKEN",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: tokenSecretName(source)},
+				Key:                  tokenSecretPreviousKey,
+				Optional:             &optional,
+			},
+		},
+	})
+	// 新 token 同步前旧 token 没有过期时间
+	if rotation.previousExpiry.IsZero() {
+		return env
+	}
+	return append(env, corev1.EnvVar{
+		Name:  "PREVIOUS_SECRET_TOKEN_EXPIRES",
+		Value: rotation.previousExpiry.Format(time.RFC3339),
+	})
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func indexSecretRefs(rawObj runtime.Object) []string {
+	return secretRefs(rawObj.(*v1alpha1.GitHook))
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestRotateToken(t *testing.T) {
+	now := time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC)
+	overlap := 10 * time.Minute
+	pushedAt := func(d time.Duration) []byte { return []byte(now.Add(d).Format(time.RFC3339)) }
+
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		token   string
+		rotated bool
+		// expected state
+		previous     string
+		pending      bool
+		keepPrevious bool
+		expiry       time.Time
+		generation   string
+	}{{
+		name:       "first token",
+		data:       map[string][]byte{},
+		token:      "a",
+		rotated:    true,
+		pending:    true,
+		generation: "1",
+	}, {
+		name:       "unchanged token pushed long ago",
+		data:       map[string][]byte{"current": []byte("b"), "previous": []byte("a"), "pushed": []byte(hashToken("b")), "pushedAt": pushedAt(-time.Hour), "generation": []byte("2")},
+		token:      "b",
+		previous:   "a",
+		generation: "2",
+	}, {
+		name:         "rotation of a pushed token",
+		data:         map[string][]byte{"current": []byte("a"), "pushed": []byte(hashToken("a")), "pushedAt": pushedAt(-time.Hour), "generation": []byte("1")},
+		token:        "b",
+		rotated:      true,
+		previous:     "a",
+		pending:      true,
+		keepPrevious: true,
+		generation:   "2",
+	}, {
+		name:         "rotation still pending past the overlap",
+		data:         map[string][]byte{"current": []byte("b"), "previous": []byte("a"), "pushed": []byte(hashToken("a")), "generation": []byte("2")},
+		token:        "b",
+		previous:     "a",
+		pending:      true,
+		keepPrevious: true,
+		generation:   "2",
+	}, {
+		name:         "second rotation before the first was pushed",
+		data:         map[string][]byte{"current": []byte("b"), "previous": []byte("a"), "pushed": []byte(hashToken("a")), "generation": []byte("2")},
+		token:        "c",
+		rotated:      true,
+		previous:     "a",
+		pending:      true,
+		keepPrevious: true,
+		generation:   "3",
+	}, {
+		name:         "pushed within the overlap",
+		data:         map[string][]byte{"current": []byte("b"), "previous": []byte("a"), "pushed": []byte(hashToken("b")), "pushedAt": pushedAt(-time.Minute), "generation": []byte("2")},
+		token:        "b",
+		previous:     "a",
+		keepPrevious: true,
+		expiry:       now.Add(overlap - time.Minute),
+		generation:   "2",
+	}}
+
+	for _, test := range tests {
+		rotation, rotated := rotateToken(test.data, test.token, now, overlap)
+		if rotated != test.rotated {
+			t.Errorf("%s: expected rotated %t, got %t", test.name, test.rotated, rotated)
+		}
+		if current := string(test.data["current"]); current != test.token {
+			t.Errorf("%s: expected current token %s, got %s", test.name, test.token, current)
+		}
+		if previous := string(test.data["previous"]); previous != test.previous {
+			t.Errorf("%s: expected previous token %q, got %q", test.name, test.previous, previous)
+		}
+		if rotation.pending != test.pending || rotation.keepPrevious != test.keepPrevious || !rotation.previousExpiry.Equal(test.expiry) || rotation.generation != test.generation {
+			t.Errorf("%s: unexpected rotation %+v", test.name, rotation)
+		}
+	}
+}
+
+func TestRequeueBefore(t *testing.T) {
+	expiry := time.Now().Add(time.Minute)
+	tests := []struct {
+		name     string
+		result   ctrl.Result
+		rotation *tokenRotation
+		min, max time.Duration
+	}{
+		{"no rotation", ctrl.Result{RequeueAfter: time.Hour}, nil, time.Hour, time.Hour},
+		{"pending rotation", ctrl.Result{RequeueAfter: time.Hour}, &tokenRotation{pending: true, keepPrevious: true}, time.Hour, time.Hour},
+		{"overlap before resync", ctrl.Result{RequeueAfter: time.Hour}, &tokenRotation{keepPrevious: true, previousExpiry: expiry}, 50 * time.Second, 61 * time.Second},
+		{"resync before overlap", ctrl.Result{RequeueAfter: time.Second}, &tokenRotation{keepPrevious: true, previousExpiry: expiry}, time.Second, time.Second},
+		{"no resync", ctrl.Result{}, &tokenRotation{keepPrevious: true, previousExpiry: expiry}, 50 * time.Second, 61 * time.Second},
+	}
+
+	for _, test := range tests {
+		requeueAfter := requeueBefore(test.result, test.rotation).RequeueAfter
+		if requeueAfter < test.min || requeueAfter > test.max {
+			t.Errorf("%s: expected requeue after between %s and %s, got %s", test.name, test.min, test.max, requeueAfter)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/zhd173/githook/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resolveSink 解析事件转发地址，未指定 Sink 时返回空字符串
+func (r *GitHookReconciler) resolveSink(source *v1alpha1.GitHook) (string, error) {
+	sink := source.Spec.Sink
+	if sink == nil {
+		return "", nil
+	}
+
+	if sink.Ref == nil {
+		if sink.URI == "" {
+			return "", fmt.Errorf("sink requires either ref or uri")
+		}
+		uri, err := url.Parse(sink.URI)
+		if err != nil || !uri.IsAbs() {
+			return "", fmt.Errorf("sink uri %q is not an absolute url", sink.URI)
+		}
+		return uri.String(), nil
+	}
+
+	address, err := r.sinkRefAddress(source, sink)
+	if err != nil {
+		return "", err
+	}
+
+	if sink.URI == "" {
+		return address.String(), nil
+	}
+
+	uri, err := url.Parse(sink.URI)
+	if err != nil {
+		return "", fmt.Errorf("invalid sink uri %q: %s", sink.URI, err)
+	}
+	return address.ResolveReference(uri).String(), nil
+}
+
+// sinkRefAddress 读取 Sink 引用资源的地址：Kubernetes Service 使用集群内域名，
+// 其它资源使用 Addressable 的 status.address
+func (r *GitHookReconciler) sinkRefAddress(source *v1alpha1.GitHook, sink *v1alpha1.SinkSpec) (*url.URL, error) {
+	ref := sink.Ref
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = source.Namespace
+	}
+
+	if ref.APIVersion == "v1" && ref.Kind == "Service" {
+		return &url.URL{Scheme: "http", Host: fmt.Sprintf("%s.%s.svc.cluster.local", ref.Name, namespace), Path: "/"}, nil
+	}
+
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(ref.APIVersion)
+	obj.SetKind(ref.Kind)
+	if err := r.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: ref.Name}, obj); err != nil {
+		return nil, fmt.Errorf("failed to get sink %s %s/%s: %s", ref.Kind, namespace, ref.Name, err)
+	}
+
+	address, _, _ := unstructured.NestedString(obj.Object, "status", "address", "url")
+	if address == "" {
+		// 早期的 Addressable 只提供 hostname
+		if hostname, _, _ := unstructured.NestedString(obj.Object, "status", "address", "hostname"); hostname != "" {
+			address = "http://" + hostname
+		}
+	}
+	if address == "" {
+		return nil, fmt.Errorf("sink %s %s/%s is not addressable yet", ref.Kind, namespace, ref.Name)
+	}
+
+	return url.Parse(address)
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"path"
+
+	"github.com/zhd173/githook/api/v1alpha1"
+	"github.com/zhd173/githook/pkg/githook"
+)
+
+// triggerSpecs GitHook 的触发器，GitHook 自身的 runSpec 为不带名称的触发器
+func triggerSpecs(source *v1alpha1.GitHook) []v1alpha1.TriggerSpec {
+	var triggers []v1alpha1.TriggerSpec
+	if hasRunSpec(source) || source.Spec.RunTemplateRef != nil {
+		triggers = append(triggers, v1alpha1.TriggerSpec{
+			EventTypes:     source.Spec.EventTypes,
+			RunSpec:        source.Spec.RunSpec,
+			RunTemplateRef: source.Spec.RunTemplateRef,
+		})
+	}
+	return append(triggers, source.Spec.Triggers...)
+}
+
+// receiverTriggers 传给 receiver 的触发器
+func receiverTriggers(source *v1alpha1.GitHook) ([]githook.TriggerConfig, error) {
+	triggers := triggerSpecs(source)
+
+	configs := make([]githook.TriggerConfig, 0, len(triggers))
+	for _, trigger := range triggers {
+		config, err := triggerConfig(trigger)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
+func triggerConfig(trigger v1alpha1.TriggerSpec) (githook.TriggerConfig, error) {
+	config := githook.TriggerConfig{
+		Name:   trigger.Name,
+		When:   trigger.When,
+		Params: receiverParams(trigger.Params),
+	}
+	for _, event := range trigger.EventTypes {
+		config.EventTypes = append(config.EventTypes, string(event))
+	}
+
+	// 引用模板时 receiver 从挂载的 ConfigMap 中读取 runSpec
+	if trigger.RunTemplateRef != nil {
+		config.RunTemplate = runTemplatePath(trigger.RunTemplateRef)
+		return config, nil
+	}
+
+	runSpec, err := json.Marshal(trigger.RunSpec)
+	if err != nil {
+		return config, err
+	}
+	config.RunSpec = runSpec
+	return config, nil
+}
+
+// runTemplateRefs GitHook 与各触发器引用的模板
+func runTemplateRefs(source *v1alpha1.GitHook) []*v1alpha1.RunTemplateRef {
+	var refs []*v1alpha1.RunTemplateRef
+	if source.Spec.RunTemplateRef != nil {
+		refs = append(refs, source.Spec.RunTemplateRef)
+	}
+	for _, trigger := range source.Spec.Triggers {
+		if trigger.RunTemplateRef != nil {
+			refs = append(refs, trigger.RunTemplateRef)
+		}
+	}
+	return refs
+}
+
+func runTemplateKey(ref *v1alpha1.RunTemplateRef) string {
+	if ref.Key != "" {
+		return ref.Key
+	}
+	return defaultRunTemplateKey
+}
+
+// runTemplatePath 模板在 receiver 中的挂载路径
+func runTemplatePath(ref *v1alpha1.RunTemplateRef) string {
+	return path.Join(runTemplateDir, ref.Name, runTemplateKey(ref))
+}
+
+// webhookEvents 注册到 git webhook 的事件类型，为 GitHook 与各触发器事件类型的并集
+func webhookEvents(source *v1alpha1.GitHook) []string {
+	var events []string
+	seen := map[string]bool{}
+
+	for _, event := range source.Spec.EventTypes {
+		if !seen[string(event)] {
+			seen[string(event)] = true
+			events = append(events, string(event))
+		}
+	}
+	for _, trigger := range source.Spec.Triggers {
+		for _, event := range trigger.EventTypes {
+			if !seen[string(event)] {
+				seen[string(event)] = true
+				events = append(events, string(event))
+			}
+		}
+	}
+
+	// 斜杠命令与 /ok-to-test 需要接收评论事件
+	commands := source.Spec.ChatOps != nil || (source.Spec.PullRequest != nil && source.Spec.PullRequest.UntrustedPolicy == v1alpha1.UntrustedRequireOkToTest)
+	if event, ok := chatopsEvents[source.Spec.GitProvider]; ok && commands && !seen[event] {
+		events = append(events, event)
+	}
+
+	return events
+}
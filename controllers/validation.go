@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	tektonv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/zhd173/githook/api/v1alpha1"
+	githookclient "github.com/zhd173/githook/pkg/client"
+	"github.com/zhd173/githook/pkg/filter"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ValidatingWebhookPath GitHook 准入校验 webhook 的路径
+const ValidatingWebhookPath = "/validate-githook"
+
+// +kubebuilder:webhook:path=/validate-githook,mutating=false,failurePolicy=fail,groups=tools.github.com/zhd173,resources=githooks,verbs=create;update,versions=v1alpha1,name=vgithook.githook.tools
+
+// GitHookValidator 在创建、更新 GitHook 时校验 spec，拒绝无法编译的 when 表达式与重名的触发器
+type GitHookValidator struct {
+	decoder *admission.Decoder
+}
+
+var _ admission.DecoderInjector = &GitHookValidator{}
+
+// InjectDecoder 由 webhook server 注入 decoder
+func (v *GitHookValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// Handle 校验 GitHook
+func (v *GitHookValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	source := &v1alpha1.GitHook{}
+	if err := v.decoder.Decode(req, source); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if err := validateGitHook(source); err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	return admission.Allowed("")
+}
+
+// validateGitHook 校验 GitHook spec，准入校验未启用时由 reconcile 报告错误
+func validateGitHook(source *v1alpha1.GitHook) error {
+	if source.Spec.Sink == nil && !hasRunSpec(source) && source.Spec.RunTemplateRef == nil && len(source.Spec.Triggers) == 0 {
+		return fmt.Errorf("either runSpec, runTemplateRef, triggers or sink must be specified")
+	}
+
+	if hasRunSpec(source) && source.Spec.RunTemplateRef != nil {
+		return fmt.Errorf("runSpec and runTemplateRef are mutually exclusive")
+	}
+
+	if len(webhookEvents(source)) == 0 {
+		return fmt.Errorf("eventTypes must be specified for the GitHook or its triggers")
+	}
+
+	if source.Spec.GitProvider == string(v1alpha1.Gitlab) {
+		if err := githookclient.ValidateGitlabEvents(webhookEvents(source)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := filter.Compile(source.Spec.When); err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for _, trigger := range source.Spec.Triggers {
+		if names[trigger.Name] {
+			return fmt.Errorf("duplicate trigger %s", trigger.Name)
+		}
+		names[trigger.Name] = true
+
+		runSpec := !apiequality.Semantic.DeepEqual(trigger.RunSpec, tektonv1alpha1.PipelineRunSpec{})
+		if runSpec == (trigger.RunTemplateRef != nil) {
+			return fmt.Errorf("trigger %s: exactly one of runSpec and runTemplateRef must be specified", trigger.Name)
+		}
+
+		if _, err := filter.Compile(trigger.When); err != nil {
+			return fmt.Errorf("trigger %s: %s", trigger.Name, err)
+		}
+	}
+
+	if _, ok := chatopsEvents[source.Spec.GitProvider]; !ok && holdsUntrusted(source) {
+		return fmt.Errorf("pullRequest untrustedPolicy %s is not supported by git provider %s", source.Spec.PullRequest.UntrustedPolicy, source.Spec.GitProvider)
+	}
+
+	if source.Spec.CommitStatus && source.Spec.GitProvider != string(v1alpha1.Github) {
+		return fmt.Errorf("commitStatus is not supported by git provider %s", source.Spec.GitProvider)
+	}
+
+	if rateLimit := source.Spec.RateLimit; rateLimit != nil && (rateLimit.DebounceSeconds < 0 || rateLimit.MaxPerMinute < 0) {
+		return fmt.Errorf("rateLimit debounceSeconds and maxPerMinute must not be negative")
+	}
+
+	if source.Spec.ChatOps != nil {
+		if _, ok := chatopsEvents[source.Spec.GitProvider]; !ok {
+			return fmt.Errorf("chatops is not supported by git provider %s", source.Spec.GitProvider)
+		}
+		if source.Spec.GitProvider == string(v1alpha1.Github) {
+			for _, team := range source.Spec.ChatOps.AllowedTeams {
+				if len(strings.Split(team, "/")) != 2 {
+					return fmt.Errorf("chatops team %s must be named org/team", team)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/zhd173/githook/api/v1alpha1"
+)
+
+func TestValidateGitHookEvents(t *testing.T) {
+	tests := []struct {
+		spec  string
+		valid bool
+	}{
+		{`{"gitProvider": "gitlab", "eventTypes": ["push", "tag_push", "merge_request"]}`, true},
+		{`{"gitProvider": "gitlab", "eventTypes": ["push", "fork"]}`, false},
+		{`{"gitProvider": "gitlab", "eventTypes": ["create"]}`, false},
+		{`{"gitProvider": "gitlab", "eventTypes": ["delete"]}`, false},
+		{`{"gitProvider": "github", "eventTypes": ["create", "delete", "fork"]}`, true},
+	}
+
+	for _, test := range tests {
+		source := &v1alpha1.GitHook{}
+		if err := json.Unmarshal([]byte(`{"spec": `+test.spec+`}`), source); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		source.Spec.Sink = &v1alpha1.SinkSpec{URI: "http://sink.example.com"}
+
+		err := validateGitHook(source)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("%s: expected valid %t, got %v", test.spec, test.valid, err)
+		}
+	}
+}
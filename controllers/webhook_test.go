@@ -0,0 +1,191 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zhd173/githook/api/v1alpha1"
+	"github.com/zhd173/githook/pkg/githook"
+	"github.com/zhd173/githook/pkg/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// fakeGitClient answers the webhook calls of the reconciler and records them
+type fakeGitClient struct {
+	exists  bool
+	changed bool
+	found   []string
+
+	calls   []string
+	deleted []string
+}
+
+func (client *fakeGitClient) Validate(options *model.HookOptions) (bool, bool, error) {
+	client.calls = append(client.calls, "validate")
+	return client.exists, client.changed, nil
+}
+
+func (client *fakeGitClient) Find(options *model.HookOptions) ([]string, error) {
+	client.calls = append(client.calls, "find")
+	return client.found, nil
+}
+
+func (client *fakeGitClient) Create(options *model.HookOptions) (string, error) {
+	client.calls = append(client.calls, "create")
+	return "new", nil
+}
+
+func (client *fakeGitClient) Update(options *model.HookOptions) (string, error) {
+	client.calls = append(client.calls, "update "+options.ID)
+	return options.ID, nil
+}
+
+func (client *fakeGitClient) Delete(options *model.HookOptions) error {
+	client.calls = append(client.calls, "delete "+options.ID)
+	client.deleted = append(client.deleted, options.ID)
+	return nil
+}
+
+func (client *fakeGitClient) ResolveRef(options *model.HookOptions, ref string) (string, error) {
+	return ref, nil
+}
+
+func newWebhookReconciler(gitClient *fakeGitClient) (*GitHookReconciler, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(10)
+	return &GitHookReconciler{
+		Log:      ctrl.Log.WithName("test"),
+		Recorder: recorder,
+		GitClients: func(source *v1alpha1.GitHook, options *model.HookOptions) (*githook.Client, error) {
+			return githook.New(gitClient, options.BaseURL, options.AccessToken)
+		},
+	}, recorder
+}
+
+func newWebhookSource() *v1alpha1.GitHook {
+	return &v1alpha1.GitHook{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hook"},
+		Spec:       v1alpha1.GitHookSpec{GitProvider: string(v1alpha1.Github)},
+	}
+}
+
+// events returns the Events recorded so far
+func events(recorder *record.FakeRecorder) []string {
+	recorded := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			recorded = append(recorded, event)
+		default:
+			return recorded
+		}
+	}
+}
+
+func TestReconcileWebhookRepairsHook(t *testing.T) {
+	tests := []struct {
+		name      string
+		client    *fakeGitClient
+		statusID  string
+		hookID    string
+		calls     string
+		eventFrom string
+	}{{
+		name:      "missing hook is recreated",
+		client:    &fakeGitClient{},
+		statusID:  "old",
+		hookID:    "new",
+		calls:     "validate,find,create",
+		eventFrom: "Warning WebhookRecreated Webhook old was missing",
+	}, {
+		name:      "drifted hook is updated",
+		client:    &fakeGitClient{exists: true, changed: true},
+		statusID:  "old",
+		hookID:    "old",
+		calls:     "validate,update old",
+		eventFrom: "Warning WebhookDriftCorrected Webhook old",
+	}, {
+		name:      "new hook is created",
+		client:    &fakeGitClient{},
+		hookID:    "new",
+		calls:     "validate,find,create",
+		eventFrom: "Normal WebhookCreated Created webhook new",
+	}, {
+		name:     "matching hook is left alone",
+		client:   &fakeGitClient{exists: true},
+		statusID: "old",
+		hookID:   "old",
+		calls:    "validate",
+	}}
+
+	for _, test := range tests {
+		r, recorder := newWebhookReconciler(test.client)
+		hookID, err := r.reconcileWebhook(newWebhookSource(), &model.HookOptions{ID: test.statusID, Owner: "owner", Project: "repo"}, false, false)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err)
+		}
+		if hookID != test.hookID {
+			t.Errorf("%s: expected hook %s, got %s", test.name, test.hookID, hookID)
+		}
+		if calls := strings.Join(test.client.calls, ","); calls != test.calls {
+			t.Errorf("%s: expected calls %s, got %s", test.name, test.calls, calls)
+		}
+
+		recorded := events(recorder)
+		if test.eventFrom == "" {
+			if len(recorded) != 0 {
+				t.Errorf("%s: expected no Event, got %v", test.name, recorded)
+			}
+			continue
+		}
+		if len(recorded) != 1 || !strings.HasPrefix(recorded[0], test.eventFrom) {
+			t.Errorf("%s: expected an Event %q, got %v", test.name, test.eventFrom, recorded)
+		}
+	}
+}
+
+func TestReconcileWebhookAdoptsExistingHook(t *testing.T) {
+	gitClient := &fakeGitClient{found: []string{"first", "second", "third"}}
+	r, recorder := newWebhookReconciler(gitClient)
+
+	hookID, err := r.reconcileWebhook(newWebhookSource(), &model.HookOptions{Owner: "owner", Project: "repo"}, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if hookID != "first" {
+		t.Errorf("expected the first hook to be adopted, got %s", hookID)
+	}
+	if calls := strings.Join(gitClient.calls, ","); calls != "validate,find,delete second,delete third,update first" {
+		t.Errorf("expected the duplicates to be deleted and the adopted hook updated, got %s", calls)
+	}
+
+	recorded := events(recorder)
+	expected := []string{
+		"Normal WebhookDuplicateRemoved Removed duplicate webhook second",
+		"Normal WebhookDuplicateRemoved Removed duplicate webhook third",
+		"Normal WebhookAdopted Adopted existing webhook first",
+	}
+	if len(recorded) != len(expected) {
+		t.Fatalf("expected Events %v, got %v", expected, recorded)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(recorded[i], prefix) {
+			t.Errorf("expected Event %q, got %q", prefix, recorded[i])
+		}
+	}
+}
+
+func TestReconcileWebhookCreatesHookWithoutMatch(t *testing.T) {
+	gitClient := &fakeGitClient{}
+	r, _ := newWebhookReconciler(gitClient)
+
+	hookID, err := r.reconcileWebhook(newWebhookSource(), &model.HookOptions{Owner: "owner", Project: "repo"}, false, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if hookID != "new" || len(gitClient.deleted) != 0 {
+		t.Errorf("expected a new hook and nothing deleted, got %s and %v", hookID, gitClient.deleted)
+	}
+}
@@ -7,6 +7,7 @@ require (
 	github.com/go-logr/logr v0.1.0
 	github.com/go-yaml/yaml v2.1.0+incompatible // indirect
 	github.com/gogits/go-gogs-client v0.0.0-20190616193657-5a05380e4bc2
+	github.com/google/cel-go v0.4.1
 	github.com/google/go-cmp v0.3.0 // indirect
 	github.com/google/go-containerregistry v0.0.0-20190617215043-876b8855d23c // indirect
 	github.com/google/go-github/v26 v26.0.9
@@ -17,12 +18,14 @@ require (
 	github.com/mattbaird/jsonpatch v0.0.0-20171005235357-81af80346b1a // indirect
 	github.com/onsi/ginkgo v1.8.0
 	github.com/onsi/gomega v1.5.0
+	github.com/prometheus/client_golang v0.9.0
 	github.com/prometheus/common v0.0.0-20181020173914-7e9e6cabbd39 // indirect
 	github.com/prometheus/procfs v0.0.0-20181005140218-185b4288413d // indirect
 	github.com/tektoncd/pipeline v0.4.0
 	github.com/xanzy/go-gitlab v0.18.0
-	golang.org/x/net v0.0.0-20190501004415-9ce7a6920f09
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
 	golang.org/x/oauth2 v0.0.0-20190604053449-0f29369cfe45
+	google.golang.org/genproto v0.0.0-20190819201941-24fa4b261c55
 	gopkg.in/go-playground/webhooks.v5 v5.11.0
 	k8s.io/api v0.0.0-20190409021203-6e4e0e4f393b
 	k8s.io/apimachinery v0.0.0-20190404173353-6a84e37a896d
@@ -30,4 +33,5 @@ require (
 	knative.dev/pkg v0.0.0-20191110170412-a805b647f3f2 // indirect
 	sigs.k8s.io/controller-runtime v0.2.0-alpha.1
 	sigs.k8s.io/controller-tools v0.2.0-beta.2 // indirect
+	sigs.k8s.io/yaml v1.1.0
 )
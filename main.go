@@ -18,14 +18,21 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
+	servingv1alpha1 "github.com/knative/serving/pkg/apis/serving/v1alpha1"
 	toolsv1alpha1 "github.com/zhd173/githook/api/v1alpha1"
 	"github.com/zhd173/githook/controllers"
+	"github.com/zhd173/githook/pkg/githook"
+	"github.com/zhd173/githook/pkg/metrics"
+	"github.com/zhd173/githook/pkg/tekton"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -38,15 +45,31 @@ func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
 	_ = toolsv1alpha1.AddToScheme(scheme)
+	_ = servingv1alpha1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var secretRotationOverlap time.Duration
+	var resyncInterval time.Duration
+	var receiveAdapterImage string
+	var enableWebhooks bool
+	var deliveryLimit int
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&secretRotationOverlap, "secret-rotation-overlap", controllers.DefaultSecretRotationOverlap,
+		"How long receivers keep accepting the previous secret token after it has been rotated.")
+	flag.DurationVar(&resyncInterval, "resync-interval", controllers.DefaultResyncInterval,
+		"How often GitHooks are resynced to detect and repair drifted or deleted provider webhooks.")
+	flag.StringVar(&receiveAdapterImage, "receive-adapter-image", os.Getenv("RECEIVE_ADAPTER_IMAGE"),
+		"The image of the receive adapter serving the webhooks, defaults to $RECEIVE_ADAPTER_IMAGE.")
+	flag.IntVar(&deliveryLimit, "delivery-limit", githook.DefaultDeliveryLimit,
+		"The number of recent deliveries each receiver stores in ConfigMaps for inspection and replay, none when zero.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Serve the GitHook validating admission webhook, which needs the serving certificate in /tmp/k8s-webhook-server/serving-certs.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.Logger(true))
@@ -62,13 +85,32 @@ func main() {
 		os.Exit(1)
 	}
 
+	metrics.RegisterController(ctrlmetrics.Registry)
+
+	tektonClient, err := tekton.New()
+	if err != nil {
+		setupLog.Error(err, "unable to create tekton client")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.GitHookReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("GitHook"),
+		Client:       mgr.GetClient(),
+		Log:          ctrl.Log.WithName("controllers").WithName("GitHook"),
+		Scheme:       mgr.GetScheme(),
+		WebhookImage: receiveAdapterImage,
+
+		SecretRotationOverlap: secretRotationOverlap,
+		ResyncInterval:        resyncInterval,
+		Recorder:              mgr.GetEventRecorderFor("githook-controller"),
+		TektonClient:          tektonClient,
+		DeliveryLimit:         deliveryLimit,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "GitHook")
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		mgr.GetWebhookServer().Register(controllers.ValidatingWebhookPath, &webhook.Admission{Handler: &controllers.GitHookValidator{}})
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrorType classifies the failures of provider api calls
+type ErrorType string
+
+// Provider api error types
+const (
+	// ErrorNotFound the project or webhook does not exist, or is hidden from the token
+	ErrorNotFound ErrorType = "NotFound"
+	// ErrorUnauthorized the credentials are invalid or lack the required permissions
+	ErrorUnauthorized ErrorType = "Unauthorized"
+	// ErrorRateLimited the api rate limit is exhausted
+	ErrorRateLimited ErrorType = "RateLimited"
+	// ErrorTransient the call failed on the network or the provider side and may succeed later
+	ErrorTransient ErrorType = "Transient"
+)
+
+// Error is returned by the provider http client for the calls it gave up on
+type Error struct {
+	Type       ErrorType
+	Method     string
+	URL        string
+	StatusCode int
+	// RetryAfter is how long to wait before calling the api again, zero when unknown
+	RetryAfter time.Duration
+	// Err is the underlying transport error, nil when the provider responded
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Unwrap returns the underlying transport error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrorTypeOf returns the type of a provider api error
+func ErrorTypeOf(err error) (ErrorType, bool) {
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return providerErr.Type, true
+	}
+	return "", false
+}
+
+// RetryAfter returns how long to wait before retrying a failed provider api call
+func RetryAfter(err error) time.Duration {
+	var providerErr *Error
+	if errors.As(err, &providerErr) {
+		return providerErr.RetryAfter
+	}
+	return 0
+}
+
+// IsNotFound reports whether err is caused by a missing project or webhook
+func IsNotFound(err error) bool {
+	errorType, _ := ErrorTypeOf(err)
+	return errorType == ErrorNotFound
+}
+
+// IsUnauthorized reports whether err is caused by invalid or insufficient credentials
+func IsUnauthorized(err error) bool {
+	errorType, _ := ErrorTypeOf(err)
+	return errorType == ErrorUnauthorized
+}
+
+// IsRateLimited reports whether err is caused by an exhausted api rate limit
+func IsRateLimited(err error) bool {
+	errorType, _ := ErrorTypeOf(err)
+	return errorType == ErrorRateLimited
+}
+
+// IsTransient reports whether err may go away when retried later
+func IsTransient(err error) bool {
+	errorType, _ := ErrorTypeOf(err)
+	return errorType == ErrorTransient
+}
@@ -3,7 +3,10 @@ package client
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/google/go-github/v26/github"
 	"github.com/zhd173/githook/pkg/model"
@@ -16,20 +19,58 @@ type GithubClient struct {
 	githubClient     *github.Client
 }
 
-// NewGithubClient creates new github git client
-func NewGithubClient(accessToken string) *GithubClient {
-	ctx := context.Background()
+// NewGithubClient creates new github git client. baseURL is the web root of
+// the github server, hosts other than github.com are treated as GitHub
+// Enterprise Server and served from the /api/v3 endpoints.
+func NewGithubClient(baseURL, accessToken string, httpClient *http.Client) (*GithubClient, error) {
 	ts := oauth2.StaticTokenSource(
 		&oauth2.Token{AccessToken: accessToken},
 	)
+
+	return newGithubClient(baseURL, ts, httpClient)
+}
+
+func newGithubClient(baseURL string, ts oauth2.TokenSource, httpClient *http.Client) (*GithubClient, error) {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, defaultHTTPClient("github", httpClient))
 	tc := oauth2.NewClient(ctx, ts)
 
-	githubClient := github.NewClient(tc)
+	githubClient, err := newGithubAPIClient(baseURL, tc)
+	if err != nil {
+		return nil, err
+	}
 
 	return &GithubClient{
 		authenticatedCtx: ctx,
 		githubClient:     githubClient,
+	}, nil
+}
+
+func newGithubAPIClient(baseURL string, httpClient *http.Client) (*github.Client, error) {
+	if !IsGithubEnterprise(baseURL) {
+		return github.NewClient(httpClient), nil
+	}
+
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	githubClient, err := github.NewEnterpriseClient(baseURL+"/api/v3/", baseURL+"/api/uploads/", httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("invalid github enterprise url %s: %s", baseURL, err)
+	}
+
+	return githubClient, nil
+}
+
+// IsGithubEnterprise reports whether baseURL points to a GitHub Enterprise Server
+func IsGithubEnterprise(baseURL string) bool {
+	if baseURL == "" {
+		return false
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return false
 	}
+
+	return !model.IsPublicGithubHost(u.Hostname())
 }
 
 // Validate checks if hook has been changed
@@ -52,6 +93,10 @@ func (client *GithubClient) Validate(options *model.HookOptions) (exists bool, c
 		return true, true, nil
 	}
 
+	if hook.Config["content_type"] != "json" || !hook.GetActive() || hook.Config["insecure_ssl"] != insecureSSL(options) {
+		return true, true, nil
+	}
+
 	if len(hook.Events) != len(options.Events) {
 		return true, true, nil
 	}
@@ -79,27 +124,70 @@ func (client *GithubClient) getHook(options *model.HookOptions) (*github.Hook, e
 	}
 	hook, _, err := client.githubClient.Repositories.GetHook(client.authenticatedCtx, options.Owner, options.Project, int64(ID))
 
+	// hook 已在 github 上被删除，视为不存在以便重新创建
+	if IsNotFound(err) {
+		return nil, nil
+	}
+
 	if err != nil {
-		return nil, fmt.Errorf("Failed to list webhook to the Project:" + options.Project + " due to " + err.Error())
+		return nil, fmt.Errorf("Failed to list webhook to the Project:%s due to %w", options.Project, err)
 	}
 
 	return hook, nil
 }
 
-// Create creates webhook
-func (client *GithubClient) Create(options *model.HookOptions) (string, error) {
-	hookOptions := &github.Hook{
+func newGithubHook(options *model.HookOptions) *github.Hook {
+	return &github.Hook{
 		Config: map[string]interface{}{
 			"content_type": "json",
 			"url":          options.URL,
 			"secret":       options.SecretToken,
+			"insecure_ssl": insecureSSL(options),
 		},
 		Events: options.Events,
+		Active: github.Bool(true),
+	}
+}
+
+// insecureSSL maps the ssl verification option to github's insecure_ssl setting
+func insecureSSL(options *model.HookOptions) string {
+	if options.SSLVerify {
+		return "0"
 	}
+	return "1"
+}
+
+// Find returns the IDs of the webhooks registered for the GitHook
+func (client *GithubClient) Find(options *model.HookOptions) ([]string, error) {
+	ids := []string{}
+	opt := &github.ListOptions{PerPage: 100}
+
+	for {
+		hooks, resp, err := client.githubClient.Repositories.ListHooks(client.authenticatedCtx, options.Owner, options.Project, opt)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list webhook to the Project:%s due to %w", options.Project, err)
+		}
+
+		for _, hook := range hooks {
+			if hookURL, ok := hook.Config["url"].(string); ok && matchesHook(hookURL, options) {
+				ids = append(ids, strconv.FormatInt(hook.GetID(), 10))
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return ids, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
+// Create creates webhook
+func (client *GithubClient) Create(options *model.HookOptions) (string, error) {
+	hookOptions := newGithubHook(options)
 
 	hook, _, err := client.githubClient.Repositories.CreateHook(client.authenticatedCtx, options.Owner, options.Project, hookOptions)
 	if err != nil {
-		return "", fmt.Errorf("Failed to add webhook to the Project:" + options.Project + " due to " + err.Error())
+		return "", fmt.Errorf("Failed to add webhook to the Project:%s due to %w", options.Project, err)
 	}
 
 	if err != nil {
@@ -117,14 +205,7 @@ func (client *GithubClient) Update(options *model.HookOptions) (string, error) {
 		return "", fmt.Errorf("webhook id is required to be updated")
 	}
 
-	hookOptions := &github.Hook{
-		Config: map[string]interface{}{
-			"content_type": "json",
-			"url":          options.URL,
-			"secret":       options.SecretToken,
-		},
-		Events: options.Events,
-	}
+	hookOptions := newGithubHook(options)
 
 	hookID, err := strconv.Atoi(options.ID)
 
@@ -135,7 +216,7 @@ func (client *GithubClient) Update(options *model.HookOptions) (string, error) {
 	hook, _, err := githubClient.Repositories.EditHook(client.authenticatedCtx, options.Owner, options.Project, int64(hookID), hookOptions)
 
 	if err != nil {
-		return "", fmt.Errorf("Failed to update webhook to the Project:" + options.Project + " due to " + err.Error())
+		return "", fmt.Errorf("Failed to update webhook to the Project:%s due to %w", options.Project, err)
 	}
 
 	return strconv.Itoa(int(*hook.ID)), err
@@ -151,9 +232,115 @@ func (client *GithubClient) Delete(options *model.HookOptions) error {
 
 		_, err = client.githubClient.Repositories.DeleteHook(client.authenticatedCtx, options.Owner, options.Project, int64(hookID))
 		if err != nil {
-			return fmt.Errorf("failed to delete hook owner '%s' project '%s' : %s", options.Owner, options.Project, err)
+			return fmt.Errorf("failed to delete hook owner '%s' project '%s' : %w", options.Owner, options.Project, err)
 		}
 	}
 
 	return nil
 }
+
+// CreateStatus reports the status of a commit
+func (client *GithubClient) CreateStatus(options *model.HookOptions, status *model.CommitStatus) error {
+	repoStatus := &github.RepoStatus{
+		State:   github.String(status.State),
+		Context: github.String(status.Context),
+	}
+	if status.TargetURL != "" {
+		repoStatus.TargetURL = github.String(status.TargetURL)
+	}
+	if status.Description != "" {
+		repoStatus.Description = github.String(status.Description)
+	}
+
+	_, _, err := client.githubClient.Repositories.CreateStatus(client.authenticatedCtx, options.Owner, options.Project, status.SHA, repoStatus)
+	if err != nil {
+		return fmt.Errorf("Failed to create commit status to the Project:%s due to %w", options.Project, err)
+	}
+
+	return nil
+}
+
+// GetPullRequest returns the pull request of a number
+func (client *GithubClient) GetPullRequest(options *model.HookOptions, number int) (*model.GitPullRequest, error) {
+	pr, _, err := client.githubClient.PullRequests.Get(client.authenticatedCtx, options.Owner, options.Project, number)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get pull request %d of the Project:%s due to %w", number, options.Project, err)
+	}
+
+	return &model.GitPullRequest{
+		Number:       pr.GetNumber(),
+		Title:        pr.GetTitle(),
+		State:        pr.GetState(),
+		URL:          pr.GetHTMLURL(),
+		HeadRef:      pr.GetHead().GetRef(),
+		HeadSHA:      pr.GetHead().GetSHA(),
+		HeadCloneURL: pr.GetHead().GetRepo().GetCloneURL(),
+		BaseRef:      pr.GetBase().GetRef(),
+		BaseSHA:      pr.GetBase().GetSHA(),
+		Author:       model.GitUser{Login: pr.GetUser().GetLogin()},
+		Merged:       pr.GetMerged(),
+	}, nil
+}
+
+// GetPermission returns the permission of a user on the repository
+func (client *GithubClient) GetPermission(options *model.HookOptions, user string) (string, error) {
+	level, _, err := client.githubClient.Repositories.GetPermissionLevel(client.authenticatedCtx, options.Owner, options.Project, user)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get permission of %s on the Project:%s due to %w", user, options.Project, err)
+	}
+
+	switch level.GetPermission() {
+	case "admin":
+		return model.PermissionAdmin, nil
+	case "write":
+		return model.PermissionWrite, nil
+	case "read":
+		return model.PermissionRead, nil
+	default:
+		return model.PermissionNone, nil
+	}
+}
+
+// IsTeamMember reports whether a user is an active member of a team named org/team
+func (client *GithubClient) IsTeamMember(options *model.HookOptions, team, user string) (bool, error) {
+	parts := strings.SplitN(team, "/", 2)
+	if len(parts) != 2 {
+		return false, fmt.Errorf("invalid team %s, expected org/team", team)
+	}
+
+	githubTeam, _, err := client.githubClient.Teams.GetTeamBySlug(client.authenticatedCtx, parts[0], parts[1])
+	if err != nil {
+		return false, fmt.Errorf("Failed to get team %s due to %w", team, err)
+	}
+
+	membership, _, err := client.githubClient.Teams.GetTeamMembership(client.authenticatedCtx, githubTeam.GetID(), user)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed to get membership of %s in team %s due to %w", user, team, err)
+	}
+
+	return membership.GetState() == "active", nil
+}
+
+// CreateComment comments on a pull request
+func (client *GithubClient) CreateComment(options *model.HookOptions, number int, body string) error {
+	comment := &github.IssueComment{Body: github.String(body)}
+	_, _, err := client.githubClient.Issues.CreateComment(client.authenticatedCtx, options.Owner, options.Project, number, comment)
+	if err != nil {
+		return fmt.Errorf("Failed to comment on pull request %d of the Project:%s due to %w", number, options.Project, err)
+	}
+
+	return nil
+}
+
+// ResolveRef returns the commit sha of a branch, tag or sha
+func (client *GithubClient) ResolveRef(options *model.HookOptions, ref string) (string, error) {
+	sha, _, err := client.githubClient.Repositories.GetCommitSHA1(client.authenticatedCtx, options.Owner, options.Project, ref, "")
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve ref %s of the Project:%s due to %w", ref, options.Project, err)
+	}
+
+	return sha, nil
+}
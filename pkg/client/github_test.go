@@ -0,0 +1,152 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zhd173/githook/pkg/model"
+)
+
+// newGithubEnterpriseServer stands in for the hook endpoints of a GitHub
+// Enterprise Server api, which lives below /api/v3
+func newGithubEnterpriseServer(t *testing.T, hooks map[int64]map[string]interface{}) *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v3/repos/owner/repo/hooks", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected authorization header %q", r.Header.Get("Authorization"))
+		}
+
+		switch r.Method {
+		case "GET":
+			list := []map[string]interface{}{}
+			for _, hook := range hooks {
+				list = append(list, hook)
+			}
+			json.NewEncoder(w).Encode(list)
+		case "POST":
+			hook := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&hook)
+			id := int64(len(hooks) + 1)
+			hook["id"] = id
+			hooks[id] = hook
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(hook)
+		}
+	})
+
+	mux.HandleFunc("/api/v3/repos/owner/repo/hooks/", func(w http.ResponseWriter, r *http.Request) {
+		var id int64
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/api/v3/repos/owner/repo/hooks/"), "%d", &id)
+		hook, ok := hooks[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(hook)
+	})
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func newTestHookOptions() *model.HookOptions {
+	return &model.HookOptions{
+		AccessToken: "token",
+		SecretToken: "secret",
+		Owner:       "owner",
+		Project:     "repo",
+		URL:         "http://receiver.example.com/?githook=default%2Fhook",
+		Marker:      "default/hook",
+		Events:      []string{"push", "pull_request"},
+	}
+}
+
+func TestGithubEnterpriseCreateAndValidate(t *testing.T) {
+	hooks := map[int64]map[string]interface{}{}
+	server := newGithubEnterpriseServer(t, hooks)
+	defer server.Close()
+
+	if !IsGithubEnterprise(server.URL) {
+		t.Fatalf("expected %s to be treated as github enterprise", server.URL)
+	}
+
+	client, err := NewGithubClient(server.URL, "token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := newTestHookOptions()
+	hookID, err := client.Create(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hookID != "1" {
+		t.Fatalf("expected hook id 1, got %s", hookID)
+	}
+
+	options.ID = hookID
+	exists, changed, err := client.Validate(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists || changed {
+		t.Errorf("expected unchanged existing hook, got exists=%v changed=%v", exists, changed)
+	}
+
+	options.Events = []string{"push"}
+	if _, changed, _ := client.Validate(options); !changed {
+		t.Error("expected event drift to be detected")
+	}
+}
+
+func TestGithubEnterpriseMissingHook(t *testing.T) {
+	server := newGithubEnterpriseServer(t, map[int64]map[string]interface{}{})
+	defer server.Close()
+
+	client, err := NewGithubClient(server.URL, "token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := newTestHookOptions()
+	options.ID = "42"
+	exists, _, err := client.Validate(options)
+	if err != nil {
+		t.Fatalf("expected a deleted hook to be reported as missing, got %s", err)
+	}
+	if exists {
+		t.Error("expected deleted hook not to exist")
+	}
+}
+
+func TestGithubEnterpriseFind(t *testing.T) {
+	hooks := map[int64]map[string]interface{}{
+		1: {"id": 1, "config": map[string]interface{}{"url": "http://old-receiver.example.com/?githook=default%2Fhook"}},
+		2: {"id": 2, "config": map[string]interface{}{"url": "http://other.example.com/"}},
+	}
+	server := newGithubEnterpriseServer(t, hooks)
+	defer server.Close()
+
+	client, err := NewGithubClient(server.URL, "token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := client.Find(newTestHookOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "1" {
+		t.Errorf("expected hook 1 to be found by marker, got %v", ids)
+	}
+}
@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v26/github"
+	"github.com/zhd173/githook/pkg/model"
+	"golang.org/x/oauth2"
+)
+
+// githubAppTokenLeeway renews installation tokens before they expire
+const githubAppTokenLeeway = time.Minute
+
+// installationTokens caches the installation tokens minted by this process,
+// they are shared by every client of the same app installation
+var installationTokens = &tokenCache{
+	tokens:        map[string]*oauth2.Token{},
+	installations: map[string]int64{},
+	minting:       map[string]*sync.Mutex{},
+}
+
+// tokenCache guards its maps with mu only, the tokens are minted under the
+// lock of their installation so that the api calls of an installation do not
+// hold up the others
+type tokenCache struct {
+	mu            sync.Mutex
+	tokens        map[string]*oauth2.Token
+	installations map[string]int64
+	minting       map[string]*sync.Mutex
+}
+
+// token returns the cached token of an installation when it is valid after now
+func (cache *tokenCache) token(key string, now time.Time) (*oauth2.Token, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	token, ok := cache.tokens[key]
+	if !ok || !token.Expiry.After(now.Add(githubAppTokenLeeway)) {
+		return nil, false
+	}
+	return token, true
+}
+
+func (cache *tokenCache) setToken(key string, token *oauth2.Token) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.tokens[key] = token
+}
+
+func (cache *tokenCache) installation(key string) (int64, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	installationID, ok := cache.installations[key]
+	return installationID, ok
+}
+
+func (cache *tokenCache) setInstallation(key string, installationID int64) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.installations[key] = installationID
+}
+
+func (cache *tokenCache) deleteInstallation(key string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	delete(cache.installations, key)
+}
+
+// mintingLock returns the lock the token of an installation is minted under
+func (cache *tokenCache) mintingLock(key string) *sync.Mutex {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	lock, ok := cache.minting[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		cache.minting[key] = lock
+	}
+	return lock
+}
+
+// githubAppTokenSource mints installation tokens for a GitHub App
+type githubAppTokenSource struct {
+	baseURL        string
+	appID          int64
+	installationID int64
+	owner          string
+	repo           string
+	key            *rsa.PrivateKey
+	httpClient     *http.Client
+	now            func() time.Time
+}
+
+// NewGithubAppClient creates new github git client authenticated as an
+// installation of a GitHub App. The installation is looked up from the
+// owner/repo repository when app.InstallationID is not set.
+func NewGithubAppClient(baseURL string, app *model.GithubAppOptions, owner, repo string, httpClient *http.Client) (*GithubClient, error) {
+	key, err := parseGithubAppKey(app.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ts := &githubAppTokenSource{
+		baseURL:        baseURL,
+		appID:          app.AppID,
+		installationID: app.InstallationID,
+		owner:          owner,
+		repo:           repo,
+		key:            key,
+		httpClient:     defaultHTTPClient("github", httpClient),
+		now:            time.Now,
+	}
+
+	return newGithubClient(baseURL, ts, httpClient)
+}
+
+// Token returns a cached installation token, minting a new one when it is about to expire
+func (ts *githubAppTokenSource) Token() (*oauth2.Token, error) {
+	installationID, err := ts.installation()
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s/%d/%d", ts.baseURL, ts.appID, installationID)
+	if token, ok := installationTokens.token(key, ts.now()); ok {
+		return token, nil
+	}
+
+	minting := installationTokens.mintingLock(key)
+	minting.Lock()
+	defer minting.Unlock()
+
+	// 等待期间其他调用可能已经生成了 token
+	if token, ok := installationTokens.token(key, ts.now()); ok {
+		return token, nil
+	}
+
+	appClient, err := ts.appClient()
+	if err != nil {
+		return nil, err
+	}
+
+	installationToken, _, err := appClient.Apps.CreateInstallationToken(ts.context(), installationID)
+	if err != nil {
+		// 自动发现的安装可能已被卸载或重装，清除缓存以便下次重新查找
+		if ts.installationID == 0 && (IsNotFound(err) || IsUnauthorized(err)) {
+			installationTokens.deleteInstallation(ts.installationKey())
+		}
+		return nil, fmt.Errorf("failed to create installation token of github app %d: %w", ts.appID, err)
+	}
+
+	token := &oauth2.Token{
+		AccessToken: installationToken.GetToken(),
+		Expiry:      installationToken.GetExpiresAt(),
+	}
+	installationTokens.setToken(key, token)
+
+	return token, nil
+}
+
+// installation returns the installation id of the app on the repository
+func (ts *githubAppTokenSource) installation() (int64, error) {
+	if ts.installationID != 0 {
+		return ts.installationID, nil
+	}
+
+	key := ts.installationKey()
+	if installationID, ok := installationTokens.installation(key); ok {
+		return installationID, nil
+	}
+
+	appClient, err := ts.appClient()
+	if err != nil {
+		return 0, err
+	}
+
+	installation, _, err := appClient.Apps.FindRepositoryInstallation(ts.context(), ts.owner, ts.repo)
+	if IsNotFound(err) {
+		return 0, fmt.Errorf("github app %d is not installed on repository %s/%s: %w", ts.appID, ts.owner, ts.repo, err)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to find installation of github app %d: %w", ts.appID, err)
+	}
+
+	installationTokens.setInstallation(key, installation.GetID())
+
+	return installation.GetID(), nil
+}
+
+// installationKey identifies the discovered installation of the app on the repository
+func (ts *githubAppTokenSource) installationKey() string {
+	return fmt.Sprintf("%s/%d/%s/%s", ts.baseURL, ts.appID, ts.owner, ts.repo)
+}
+
+// appClient creates a client authenticated as the app itself
+func (ts *githubAppTokenSource) appClient() (*github.Client, error) {
+	jwt, err := githubAppJWT(ts.appID, ts.key, ts.now())
+	if err != nil {
+		return nil, err
+	}
+
+	tc := oauth2.NewClient(ts.context(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: jwt}))
+
+	return newGithubAPIClient(ts.baseURL, tc)
+}
+
+func (ts *githubAppTokenSource) context() context.Context {
+	return context.WithValue(context.Background(), oauth2.HTTPClient, ts.httpClient)
+}
+
+// githubAppJWT signs the RS256 JWT identifying the app, github accepts at most 10 minutes of validity
+func githubAppJWT(appID int64, key *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(struct {
+		IssuedAt  int64 `json:"iat"`
+		ExpiresAt int64 `json:"exp"`
+		Issuer    int64 `json:"iss"`
+	}{
+		// allow for clock drift against github
+		IssuedAt:  now.Add(-time.Minute).Unix(),
+		ExpiresAt: now.Add(9 * time.Minute).Unix(),
+		Issuer:    appID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(unsigned))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign github app jwt: %s", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseGithubAppKey parses the PKCS1 or PKCS8 PEM private key of the app
+func parseGithubAppKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM private key found for github app")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid github app private key: %s", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("github app private key is not a RSA key")
+	}
+
+	return key, nil
+}
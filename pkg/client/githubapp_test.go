@@ -0,0 +1,163 @@
+package client
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhd173/githook/pkg/model"
+)
+
+// newGithubAppServer stands in for the app and status endpoints of a GitHub
+// Enterprise Server api, counting the installation tokens it mints
+func newGithubAppServer(t *testing.T, key *rsa.PrivateKey, minted *int, statuses *[]map[string]interface{}) *httptest.Server {
+	mux := http.NewServeMux()
+
+	verifyJWT := func(r *http.Request) {
+		jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(jwt, ".")
+		if len(parts) != 3 {
+			t.Fatalf("unexpected app authorization %q", jwt)
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hashed[:], signature); err != nil {
+			t.Errorf("invalid app jwt signature: %s", err)
+		}
+		claims := map[string]int64{}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(payload, &claims)
+		if claims["iss"] != 7 {
+			t.Errorf("unexpected jwt issuer %d", claims["iss"])
+		}
+	}
+
+	mux.HandleFunc("/api/v3/repos/owner/repo/installation", func(w http.ResponseWriter, r *http.Request) {
+		verifyJWT(r)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 42})
+	})
+	mux.HandleFunc("/api/v3/app/installations/42/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		verifyJWT(r)
+		*minted++
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      "installation-token",
+			"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+	})
+	mux.HandleFunc("/api/v3/repos/owner/repo/statuses/abc123", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer installation-token" {
+			t.Errorf("unexpected authorization header %q", r.Header.Get("Authorization"))
+		}
+		status := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&status)
+		*statuses = append(*statuses, status)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(status)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func TestGithubAppClientReportsStatus(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	minted := 0
+	statuses := []map[string]interface{}{}
+	server := newGithubAppServer(t, key, &minted, &statuses)
+	defer server.Close()
+
+	app := &model.GithubAppOptions{AppID: 7, PrivateKey: privateKey}
+	options := &model.HookOptions{Owner: "owner", Project: "repo"}
+	status := &model.CommitStatus{SHA: "abc123", State: model.CommitStatusPending, Context: "githook"}
+
+	// both clients share the cached installation token
+	for i := 0; i < 2; i++ {
+		client, err := NewGithubAppClient(server.URL, app, "owner", "repo", nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := client.CreateStatus(options, status); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if minted != 1 {
+		t.Errorf("expected a single installation token to be minted, got %d", minted)
+	}
+	if len(statuses) != 2 || statuses[0]["state"] != "pending" || statuses[0]["context"] != "githook" {
+		t.Errorf("unexpected statuses %v", statuses)
+	}
+}
+
+func TestParseGithubAppKeyRejectsInvalidKey(t *testing.T) {
+	if _, err := parseGithubAppKey([]byte("not a key")); err == nil {
+		t.Error("expected error for invalid private key")
+	}
+}
+
+func TestGithubAppClientRediscoversRemovedInstallation(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	// the app is reinstalled on the repository after the first lookup
+	installationID := 41
+	lookups := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/owner/repo/installation", func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": installationID})
+		installationID = 42
+	})
+	mux.HandleFunc("/api/v3/app/installations/41/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/api/v3/app/installations/42/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      "installation-token",
+			"expires_at": time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+	})
+	mux.HandleFunc("/api/v3/repos/owner/repo/statuses/abc123", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	app := &model.GithubAppOptions{AppID: 7, PrivateKey: privateKey}
+	options := &model.HookOptions{Owner: "owner", Project: "repo"}
+	status := &model.CommitStatus{SHA: "abc123", State: model.CommitStatusPending, Context: "githook"}
+
+	client, err := NewGithubAppClient(server.URL, app, "owner", "repo", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := client.CreateStatus(options, status); err == nil {
+		t.Fatalf("expected the removed installation to fail")
+	}
+	if err := client.CreateStatus(options, status); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if lookups != 2 {
+		t.Errorf("expected the installation to be looked up again, got %d lookups", lookups)
+	}
+}
@@ -2,7 +2,10 @@ package client
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	gitlabclient "github.com/xanzy/go-gitlab"
 	"github.com/zhd173/githook/pkg/model"
@@ -15,94 +18,175 @@ const (
 	// PushEvents represents push event
 	PushEvents Event = "push"
 
+	// TagPushEvents represents tag_push event
+	TagPushEvents Event = "tag_push"
+
+	// MergeRequestEvents represents merge_request event
+	MergeRequestEvents Event = "merge_request"
+
+	// NoteEvents represents note event, comments on commits, merge requests and issues
+	NoteEvents Event = "note"
+
 	// IssuesEvents represents issues event
 	IssuesEvents Event = "issues"
 
-	// CommentEvents represents issue_comment event
-	CommentEvents Event = "issue_comment"
+	// ReleaseEvents represents release event
+	ReleaseEvents Event = "release"
+
+	// PipelineEvents represents pipeline event
+	PipelineEvents Event = "pipeline"
 
-	// MergeRequestEvents represents push pull_request
-	MergeRequestEvents Event = "pull_request"
+	// JobEvents represents job event
+	JobEvents Event = "job"
+
+	// WikiPageEvents represents wiki_page event
+	WikiPageEvents Event = "wiki_page"
 )
 
+// eventAliases maps the github style event names to their gitlab equivalent
+var eventAliases = map[Event]Event{
+	"pull_request":  MergeRequestEvents,
+	"issue_comment": NoteEvents,
+}
+
 // GitlabClient provides gitlab git client functionalities
 type GitlabClient struct {
 	gitlabClient *gitlabclient.Client
 }
 
-func hookToEventList(hook *gitlabclient.ProjectHook) []Event {
-	events := make([]Event, 0)
+// projectHook extends the go-gitlab project hook with the release events flag
+// the client library does not know about
+type projectHook struct {
+	gitlabclient.ProjectHook
+	ReleasesEvents bool `json:"releases_events"`
+}
 
-	if hook.PushEvents || hook.TagPushEvents {
-		events = append(events, PushEvents)
-	}
+// projectHookOptions is used both to add and to edit a hook. Every event flag
+// is always sent so that events removed from the GitHook are unset as well.
+type projectHookOptions struct {
+	gitlabclient.EditProjectHookOptions
+	ReleasesEvents *bool `url:"releases_events,omitempty" json:"releases_events,omitempty"`
+}
 
-	if hook.IssuesEvents {
-		events = append(events, IssuesEvents)
-	}
+// normalizeEvents resolves aliases and rejects events gitlab cannot deliver
+func normalizeEvents(events []string) ([]Event, error) {
+	seen := make(map[Event]bool)
+	normalized := make([]Event, 0, len(events))
+
+	for _, name := range events {
+		event := Event(name)
+		if alias, ok := eventAliases[event]; ok {
+			event = alias
+		}
 
-	if hook.MergeRequestsEvents {
-		events = append(events, MergeRequestEvents)
+		switch event {
+		case PushEvents, TagPushEvents, MergeRequestEvents, NoteEvents, IssuesEvents,
+			ReleaseEvents, PipelineEvents, JobEvents, WikiPageEvents:
+		default:
+			return nil, fmt.Errorf("event %s is not supported by gitlab", name)
+		}
+
+		if !seen[event] {
+			seen[event] = true
+			normalized = append(normalized, event)
+		}
 	}
 
-	if hook.NoteEvents {
-		events = append(events, CommentEvents)
+	return normalized, nil
+}
+
+// ValidateGitlabEvents rejects the events gitlab cannot deliver, before a
+// hook is registered with them
+func ValidateGitlabEvents(events []string) error {
+	_, err := normalizeEvents(events)
+	return err
+}
+
+func hookToEventList(hook *projectHook) []Event {
+	events := make([]Event, 0)
+
+	for event, enabled := range map[Event]bool{
+		PushEvents:         hook.PushEvents,
+		TagPushEvents:      hook.TagPushEvents,
+		MergeRequestEvents: hook.MergeRequestsEvents,
+		NoteEvents:         hook.NoteEvents,
+		IssuesEvents:       hook.IssuesEvents,
+		ReleaseEvents:      hook.ReleasesEvents,
+		PipelineEvents:     hook.PipelineEvents,
+		JobEvents:          hook.JobEvents,
+		WikiPageEvents:     hook.WikiPageEvents,
+	} {
+		if enabled {
+			events = append(events, event)
+		}
 	}
 
 	return events
 }
 
-func eventListToAddHook(events []string, hook *gitlabclient.AddProjectHookOptions) {
-	trueValue := true
-
+func eventListToHookOptions(events []Event, hook *projectHookOptions) {
+	enabled := make(map[Event]bool)
 	for _, event := range events {
-		switch Event(event) {
-		case PushEvents:
-			hook.PushEvents = &trueValue
-		case IssuesEvents:
-			hook.IssuesEvents = &trueValue
-		case MergeRequestEvents:
-			hook.MergeRequestsEvents = &trueValue
-		case CommentEvents:
-			hook.NoteEvents = &trueValue
-		}
+		enabled[event] = true
+	}
+
+	flag := func(event Event) *bool {
+		value := enabled[event]
+		return &value
 	}
 
+	hook.PushEvents = flag(PushEvents)
+	hook.TagPushEvents = flag(TagPushEvents)
+	hook.MergeRequestsEvents = flag(MergeRequestEvents)
+	hook.NoteEvents = flag(NoteEvents)
+	hook.IssuesEvents = flag(IssuesEvents)
+	hook.ReleasesEvents = flag(ReleaseEvents)
+	hook.PipelineEvents = flag(PipelineEvents)
+	hook.JobEvents = flag(JobEvents)
+	hook.WikiPageEvents = flag(WikiPageEvents)
 }
 
 func pid(options *model.HookOptions) string {
+	if options.Repository != nil {
+		return options.Repository.FullName()
+	}
 	return fmt.Sprintf("%s/%s", options.Owner, options.Project)
 }
 
-func eventListToEditHook(events []string, hook *gitlabclient.EditProjectHookOptions) {
-	trueValue := true
+func projectPath(options *model.HookOptions) string {
+	return fmt.Sprintf("projects/%s", strings.Replace(url.PathEscape(pid(options)), ".", "%2E", -1))
+}
 
-	for _, event := range events {
-		switch Event(event) {
-		case PushEvents:
-			hook.PushEvents = &trueValue
-		case IssuesEvents:
-			hook.IssuesEvents = &trueValue
-		case MergeRequestEvents:
-			hook.MergeRequestsEvents = &trueValue
-		case CommentEvents:
-			hook.NoteEvents = &trueValue
-		}
+func hooksPath(options *model.HookOptions) string {
+	return projectPath(options) + "/hooks"
+}
+
+func newProjectHookOptions(options *model.HookOptions) (*projectHookOptions, error) {
+	events, err := normalizeEvents(options.Events)
+	if err != nil {
+		return nil, err
 	}
 
+	hookOptions := &projectHookOptions{}
+	hookOptions.URL = &options.URL
+	hookOptions.Token = &options.SecretToken
+	hookOptions.EnableSSLVerification = &options.SSLVerify
+	eventListToHookOptions(events, hookOptions)
+
+	return hookOptions, nil
 }
 
 // NewGitlabClient creates new gitlab git client
-func NewGitlabClient(baseURL, accessToken string) *GitlabClient {
-	gitlabClient := gitlabclient.NewClient(nil, accessToken)
+func NewGitlabClient(baseURL, accessToken string, httpClient *http.Client) (*GitlabClient, error) {
+	gitlabClient := gitlabclient.NewClient(defaultHTTPClient("gitlab", httpClient), accessToken)
 	err := gitlabClient.SetBaseURL(baseURL)
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("invalid gitlab url %s: %s", baseURL, err)
 	}
 
 	return &GitlabClient{
 		gitlabClient,
-	}
+	}, nil
 }
 
 // Validate checks if hook has been changed
@@ -121,22 +205,27 @@ func (client *GitlabClient) Validate(options *model.HookOptions) (exists bool, c
 		return false, false, nil
 	}
 
-	if hook.URL != options.URL {
+	if hook.URL != options.URL || hook.EnableSSLVerification != options.SSLVerify {
 		return true, true, nil
 	}
 
+	desiredEvents, err := normalizeEvents(options.Events)
+	if err != nil {
+		return false, false, err
+	}
+
 	events := hookToEventList(hook)
-	if len(events) != len(options.Events) {
+	if len(events) != len(desiredEvents) {
 		return true, true, nil
 	}
 
-	eventSet := make(map[string]bool)
+	eventSet := make(map[Event]bool)
 
 	for _, event := range events {
-		eventSet[string(event)] = true
+		eventSet[event] = true
 	}
 
-	for _, event := range options.Events {
+	for _, event := range desiredEvents {
 		if !eventSet[event] {
 			return true, true, nil
 		}
@@ -145,34 +234,63 @@ func (client *GitlabClient) Validate(options *model.HookOptions) (exists bool, c
 	return true, false, nil
 }
 
-func (client *GitlabClient) getHook(options *model.HookOptions) (*gitlabclient.ProjectHook, error) {
+func (client *GitlabClient) getHook(options *model.HookOptions) (*projectHook, error) {
 	ID, err := strconv.Atoi(options.ID)
 
 	if err != nil {
 		return nil, err
 	}
 
-	hook, _, err := client.gitlabClient.Projects.GetProjectHook(pid(options), ID)
+	hook := &projectHook{}
+	_, err = client.do("GET", fmt.Sprintf("%s/%d", hooksPath(options), ID), nil, hook)
+
+	// hook 已在 gitlab 上被删除，视为不存在以便重新创建
+	if IsNotFound(err) {
+		return nil, nil
+	}
 
 	if err != nil {
-		return nil, fmt.Errorf("Failed to list webhook to the Project:" + options.Project + " due to " + err.Error())
+		return nil, fmt.Errorf("Failed to list webhook to the Project:%s due to %w", options.Project, err)
 	}
 
 	return hook, nil
 }
 
+// Find returns the IDs of the webhooks registered for the GitHook
+func (client *GitlabClient) Find(options *model.HookOptions) ([]string, error) {
+	ids := []string{}
+	opt := &gitlabclient.ListProjectHooksOptions{PerPage: 100}
+
+	for {
+		hooks, resp, err := client.gitlabClient.Projects.ListProjectHooks(pid(options), opt)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to list webhook to the Project:%s due to %w", options.Project, err)
+		}
+
+		for _, hook := range hooks {
+			if matchesHook(hook.URL, options) {
+				ids = append(ids, strconv.Itoa(hook.ID))
+			}
+		}
+
+		if resp.NextPage == 0 {
+			return ids, nil
+		}
+		opt.Page = resp.NextPage
+	}
+}
+
 // Create creates webhook
 func (client *GitlabClient) Create(options *model.HookOptions) (string, error) {
-	hookOptions := &gitlabclient.AddProjectHookOptions{
-		URL:   &options.URL,
-		Token: &options.SecretToken,
+	hookOptions, err := newProjectHookOptions(options)
+	if err != nil {
+		return "", err
 	}
 
-	eventListToAddHook(options.Events, hookOptions)
-
-	hook, _, err := client.gitlabClient.Projects.AddProjectHook(pid(options), hookOptions)
+	hook := &projectHook{}
+	_, err = client.do("POST", hooksPath(options), hookOptions, hook)
 	if err != nil {
-		return "", fmt.Errorf("Failed to add webhook to the Project:" + options.Project + " due to " + err.Error())
+		return "", fmt.Errorf("Failed to add webhook to the Project:%s due to %w", options.Project, err)
 	}
 
 	if err != nil {
@@ -184,34 +302,42 @@ func (client *GitlabClient) Create(options *model.HookOptions) (string, error) {
 
 // Update updates webhook
 func (client *GitlabClient) Update(options *model.HookOptions) (string, error) {
-	gitlabClient := client.gitlabClient
-
 	if options.ID == "" {
 		return "", fmt.Errorf("webhook id is required to be updated")
 	}
 
-	hookOptions := &gitlabclient.EditProjectHookOptions{
-		URL:   &options.URL,
-		Token: &options.SecretToken,
+	hookOptions, err := newProjectHookOptions(options)
+	if err != nil {
+		return "", err
 	}
 
-	eventListToEditHook(options.Events, hookOptions)
-
 	hookID, err := strconv.Atoi(options.ID)
 
 	if err != nil {
 		return "", fmt.Errorf("cannot convert hook ID %v", hookID)
 	}
 
-	hook, _, err := gitlabClient.Projects.EditProjectHook(pid(options), hookID, hookOptions)
+	hook := &projectHook{}
+	_, err = client.do("PUT", fmt.Sprintf("%s/%d", hooksPath(options), hookID), hookOptions, hook)
 
 	if err != nil {
-		return "", fmt.Errorf("Failed to update webhook to the Project:" + options.Project + " due to " + err.Error())
+		return "", fmt.Errorf("Failed to update webhook to the Project:%s due to %w", options.Project, err)
 	}
 
 	return strconv.Itoa(hook.ID), err
 }
 
+// do sends a hook request to the gitlab api, bypassing the go-gitlab hook
+// helpers which cannot handle the release events flag
+func (client *GitlabClient) do(method, path string, opt interface{}, hook *projectHook) (*gitlabclient.Response, error) {
+	req, err := client.gitlabClient.NewRequest(method, path, opt, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.gitlabClient.Do(req, hook)
+}
+
 // Delete webhook
 func (client *GitlabClient) Delete(options *model.HookOptions) error {
 	if options.ID != "" {
@@ -222,9 +348,119 @@ func (client *GitlabClient) Delete(options *model.HookOptions) error {
 
 		_, err = client.gitlabClient.Projects.DeleteProjectHook(pid(options), hookID)
 		if err != nil {
-			return fmt.Errorf("failed to delete hook owner '%s' project '%s' : %s", options.Owner, options.Project, err)
+			return fmt.Errorf("failed to delete hook owner '%s' project '%s' : %w", options.Owner, options.Project, err)
 		}
 	}
 
 	return nil
 }
+
+// GetPullRequest returns the merge request of an iid
+func (client *GitlabClient) GetPullRequest(options *model.HookOptions, number int) (*model.GitPullRequest, error) {
+	mr, _, err := client.gitlabClient.MergeRequests.GetMergeRequest(pid(options), number, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get merge request %d of the Project:%s due to %w", number, options.Project, err)
+	}
+
+	return &model.GitPullRequest{
+		Number:  mr.IID,
+		Title:   mr.Title,
+		State:   mr.State,
+		URL:     mr.WebURL,
+		HeadRef: mr.SourceBranch,
+		HeadSHA: mr.SHA,
+		BaseRef: mr.TargetBranch,
+		Author:  model.GitUser{Login: mr.Author.Username, Name: mr.Author.Name},
+		Merged:  mr.State == "merged",
+	}, nil
+}
+
+// GetPermission returns the permission of a user on the project, including
+// the access inherited from its groups
+func (client *GitlabClient) GetPermission(options *model.HookOptions, user string) (string, error) {
+	userID, err := client.userID(user)
+	if err != nil || userID == 0 {
+		return model.PermissionNone, err
+	}
+
+	member, err := client.member(fmt.Sprintf("%s/members/all/%d", projectPath(options), userID))
+	if err != nil || member == nil {
+		return model.PermissionNone, err
+	}
+
+	switch {
+	case member.AccessLevel >= gitlabclient.MaintainerPermissions:
+		return model.PermissionAdmin, nil
+	case member.AccessLevel >= gitlabclient.DeveloperPermissions:
+		return model.PermissionWrite, nil
+	case member.AccessLevel >= gitlabclient.GuestPermissions:
+		return model.PermissionRead, nil
+	default:
+		return model.PermissionNone, nil
+	}
+}
+
+// IsTeamMember reports whether a user is a member of a group, named by its path
+func (client *GitlabClient) IsTeamMember(options *model.HookOptions, team, user string) (bool, error) {
+	userID, err := client.userID(user)
+	if err != nil || userID == 0 {
+		return false, err
+	}
+
+	member, err := client.member(fmt.Sprintf("groups/%s/members/all/%d", strings.Replace(url.PathEscape(team), ".", "%2E", -1), userID))
+	return member != nil, err
+}
+
+// CreateComment comments on a merge request
+func (client *GitlabClient) CreateComment(options *model.HookOptions, number int, body string) error {
+	_, _, err := client.gitlabClient.Notes.CreateMergeRequestNote(pid(options), number, &gitlabclient.CreateMergeRequestNoteOptions{Body: &body})
+	if err != nil {
+		return fmt.Errorf("Failed to comment on merge request %d of the Project:%s due to %w", number, options.Project, err)
+	}
+
+	return nil
+}
+
+// userID looks up the id of a username, 0 when the user does not exist
+func (client *GitlabClient) userID(username string) (int, error) {
+	users, _, err := client.gitlabClient.Users.ListUsers(&gitlabclient.ListUsersOptions{Username: &username})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to get user %s due to %w", username, err)
+	}
+	if len(users) == 0 {
+		return 0, nil
+	}
+
+	return users[0].ID, nil
+}
+
+// member gets a project or group member, nil when the user is not a member
+func (client *GitlabClient) member(path string) (*gitlabclient.ProjectMember, error) {
+	req, err := client.gitlabClient.NewRequest("GET", path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	member := &gitlabclient.ProjectMember{}
+	_, err = client.gitlabClient.Do(req, member)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get member %s due to %w", path, err)
+	}
+
+	return member, nil
+}
+
+// ResolveRef returns the commit sha of a branch, tag or sha
+func (client *GitlabClient) ResolveRef(options *model.HookOptions, ref string) (string, error) {
+	name := strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+
+	commit, _, err := client.gitlabClient.Commits.GetCommit(pid(options), name)
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve ref %s of the Project:%s due to %w", ref, options.Project, err)
+	}
+
+	return commit.ID, nil
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestGitlabEventsRoundTrip(t *testing.T) {
+	events := []string{"push", "tag_push", "merge_request", "note", "issues", "release", "pipeline", "job", "wiki_page"}
+
+	normalized, err := normalizeEvents(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hookOptions := &projectHookOptions{}
+	eventListToHookOptions(normalized, hookOptions)
+
+	hook := &projectHook{}
+	hook.PushEvents = *hookOptions.PushEvents
+	hook.TagPushEvents = *hookOptions.TagPushEvents
+	hook.MergeRequestsEvents = *hookOptions.MergeRequestsEvents
+	hook.NoteEvents = *hookOptions.NoteEvents
+	hook.IssuesEvents = *hookOptions.IssuesEvents
+	hook.ReleasesEvents = *hookOptions.ReleasesEvents
+	hook.PipelineEvents = *hookOptions.PipelineEvents
+	hook.JobEvents = *hookOptions.JobEvents
+	hook.WikiPageEvents = *hookOptions.WikiPageEvents
+
+	got := []string{}
+	for _, event := range hookToEventList(hook) {
+		got = append(got, string(event))
+	}
+	sort.Strings(got)
+	sort.Strings(events)
+
+	if len(got) != len(events) {
+		t.Fatalf("expected events %v, got %v", events, got)
+	}
+	for i := range events {
+		if got[i] != events[i] {
+			t.Fatalf("expected events %v, got %v", events, got)
+		}
+	}
+}
+
+func TestGitlabEventsUnsetFlags(t *testing.T) {
+	hookOptions := &projectHookOptions{}
+	eventListToHookOptions([]Event{PushEvents}, hookOptions)
+
+	if !*hookOptions.PushEvents {
+		t.Error("expected push events to be set")
+	}
+	if hookOptions.TagPushEvents == nil || *hookOptions.TagPushEvents {
+		t.Error("expected tag push events to be explicitly unset")
+	}
+}
+
+func TestGitlabEventAliases(t *testing.T) {
+	normalized, err := normalizeEvents([]string{"pull_request", "issue_comment", "merge_request"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(normalized) != 2 || normalized[0] != MergeRequestEvents || normalized[1] != NoteEvents {
+		t.Errorf("unexpected normalized events %v", normalized)
+	}
+
+	if _, err := normalizeEvents([]string{"fork"}); err == nil {
+		t.Error("expected fork to be rejected")
+	}
+}
+
+func TestValidateGitlabEvents(t *testing.T) {
+	if err := ValidateGitlabEvents([]string{"push", "tag_push", "pull_request"}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	for _, event := range []string{"create", "delete", "fork"} {
+		if err := ValidateGitlabEvents([]string{"push", event}); err == nil {
+			t.Errorf("expected %s to be rejected", event)
+		}
+	}
+}
+
+func TestNewGitlabClientRejectsInvalidURL(t *testing.T) {
+	if client, err := NewGitlabClient("://gitlab.example.com", "token", nil); err == nil || client != nil {
+		t.Errorf("expected an error for an invalid url, got %v", client)
+	}
+}
@@ -2,20 +2,27 @@ package client
 
 import (
 	"fmt"
+	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
 
 	gogs "github.com/gogits/go-gogs-client"
 	"github.com/zhd173/githook/pkg/model"
 )
 
+// shaPattern matches full commit shas, which gogs cannot look up as refs
+var shaPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
 // GogsClient provides gogs git client functionalities
 type GogsClient struct {
 	gogsClient *gogs.Client
 }
 
 // NewGogsClient creates new gogs git client
-func NewGogsClient(baseURL, accessToken string) *GogsClient {
+func NewGogsClient(baseURL, accessToken string, httpClient *http.Client) *GogsClient {
 	gogsClient := gogs.NewClient(baseURL, accessToken)
+	gogsClient.SetHTTPClient(defaultHTTPClient("gogs", httpClient))
 
 	return &GogsClient{
 		gogsClient,
@@ -42,6 +49,10 @@ func (client *GogsClient) Validate(options *model.HookOptions) (exists bool, cha
 		return true, true, nil
 	}
 
+	if hook.Config["content_type"] != "json" || !hook.Active {
+		return true, true, nil
+	}
+
 	if len(hook.Events) != len(options.Events) {
 		return true, true, nil
 	}
@@ -65,7 +76,7 @@ func (client *GogsClient) getHook(options *model.HookOptions) (*gogs.Hook, error
 	hooks, err := client.gogsClient.ListRepoHooks(options.Owner, options.Project)
 
 	if err != nil {
-		return nil, fmt.Errorf("Failed to list webhook to the Project:" + options.Project + " due to " + err.Error())
+		return nil, fmt.Errorf("Failed to list webhook to the Project:%s due to %w", options.Project, err)
 	}
 
 	for _, hook := range hooks {
@@ -77,6 +88,24 @@ func (client *GogsClient) getHook(options *model.HookOptions) (*gogs.Hook, error
 	return nil, nil
 }
 
+// Find returns the IDs of the webhooks registered for the GitHook
+func (client *GogsClient) Find(options *model.HookOptions) ([]string, error) {
+	hooks, err := client.gogsClient.ListRepoHooks(options.Owner, options.Project)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list webhook to the Project:%s due to %w", options.Project, err)
+	}
+
+	ids := []string{}
+	for _, hook := range hooks {
+		if matchesHook(hook.Config["url"], options) {
+			ids = append(ids, strconv.Itoa(int(hook.ID)))
+		}
+	}
+
+	return ids, nil
+}
+
 // Create creates webhook
 func (client *GogsClient) Create(options *model.HookOptions) (string, error) {
 	hookOptions := gogs.CreateHookOption{
@@ -92,7 +121,7 @@ func (client *GogsClient) Create(options *model.HookOptions) (string, error) {
 
 	hook, err := client.gogsClient.CreateRepoHook(options.Owner, options.Project, hookOptions)
 	if err != nil {
-		return "", fmt.Errorf("Failed to add webhook to the Project:" + options.Project + " due to " + err.Error())
+		return "", fmt.Errorf("Failed to add webhook to the Project:%s due to %w", options.Project, err)
 	}
 
 	if err != nil {
@@ -131,7 +160,7 @@ func (client *GogsClient) Update(options *model.HookOptions) (string, error) {
 	err = gogsClient.EditRepoHook(options.Owner, options.Project, int64(hookID), hookOptions)
 
 	if err != nil {
-		return "", fmt.Errorf("Failed to update webhook to the Project:" + options.Project + " due to " + err.Error())
+		return "", fmt.Errorf("Failed to update webhook to the Project:%s due to %w", options.Project, err)
 	}
 
 	return strconv.Itoa(hookID), err
@@ -147,9 +176,26 @@ func (client *GogsClient) Delete(options *model.HookOptions) error {
 
 		err = client.gogsClient.DeleteRepoHook(options.Owner, options.Project, int64(hookID))
 		if err != nil {
-			return fmt.Errorf("failed to delete hook owner '%s' project '%s' : %s", options.Owner, options.Project, err)
+			return fmt.Errorf("failed to delete hook owner '%s' project '%s' : %w", options.Owner, options.Project, err)
 		}
 	}
 
 	return nil
 }
+
+// ResolveRef returns the commit sha of a branch or sha, gogs does not resolve tags
+func (client *GogsClient) ResolveRef(options *model.HookOptions, ref string) (string, error) {
+	if shaPattern.MatchString(ref) {
+		return ref, nil
+	}
+
+	branch, err := client.gogsClient.GetRepoBranch(options.Owner, options.Project, strings.TrimPrefix(ref, "refs/heads/"))
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve ref %s of the Project:%s due to %w", ref, options.Project, err)
+	}
+	if branch.Commit == nil {
+		return "", fmt.Errorf("branch %s of the Project:%s has no commit", ref, options.Project)
+	}
+
+	return branch.Commit.ID, nil
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"net/url"
+
+	"github.com/zhd173/githook/pkg/model"
+)
+
+// MarkerParam is the query parameter of the receiver URL identifying the
+// GitHook a webhook was registered for
+const MarkerParam = "githook"
+
+// matchesHook reports whether a webhook registered with hookURL belongs to
+// the GitHook described by options, either by URL or by marker
+func matchesHook(hookURL string, options *model.HookOptions) bool {
+	if hookURL == options.URL {
+		return true
+	}
+
+	if options.Marker == "" {
+		return false
+	}
+
+	u, err := url.Parse(hookURL)
+	if err != nil {
+		return false
+	}
+
+	return u.Query().Get(MarkerParam) == options.Marker
+}
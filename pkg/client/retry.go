@@ -0,0 +1,292 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhd173/githook/pkg/metrics"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// maxRetries bounds the retries of a single api call
+	maxRetries = 3
+	// retryBackoff is the wait before the first retry, doubled for every further retry
+	retryBackoff = 500 * time.Millisecond
+	// maxRetryWait bounds how long a call waits for a rate limit to reset before giving up
+	maxRetryWait = 30 * time.Second
+)
+
+// rateLimits remembers the credentials whose rate limit is exhausted, shared by
+// every client of the process so that later reconciles do not hit the limit
+// again. The limits are kept per host and credential, see rateLimitKey.
+var rateLimits = &rateLimitState{resets: map[string]time.Time{}}
+
+type rateLimitState struct {
+	mu     sync.Mutex
+	resets map[string]time.Time
+}
+
+func (s *rateLimitState) get(key string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resets[key]
+}
+
+func (s *rateLimitState) set(key string, reset time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resets[key] = reset
+}
+
+// rateLimitKey identifies the rate limit of a request, providers limit each
+// token separately so the credentials are part of the key, by their hash
+func rateLimitKey(req *http.Request) string {
+	credentials := sha256.Sum256([]byte(req.Header.Get("Authorization") + "\n" + req.Header.Get("Private-Token")))
+	return fmt.Sprintf("%s/%x", req.URL.Host, credentials)
+}
+
+// retryTransport retries idempotent and rate limited calls, honoring the
+// rate limit headers of github and gitlab, and turns the failures it gives up
+// on into a typed *Error
+type retryTransport struct {
+	base http.RoundTripper
+	// provider labels the api request metrics
+	provider string
+	now      func() time.Time
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+func newRetryTransport(base http.RoundTripper) *retryTransport {
+	return &retryTransport{
+		base:  base,
+		now:   time.Now,
+		sleep: sleepContext,
+	}
+}
+
+// RoundTrip sends the request, retrying it when allowed
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// 等待已耗尽的 rate limit 重置，等待时间过长时直接返回
+	if reset := rateLimits.get(rateLimitKey(req)); reset.After(t.now()) {
+		wait := reset.Sub(t.now())
+		if wait > maxRetryWait {
+			return nil, newError(req, ErrorRateLimited, http.StatusTooManyRequests, wait, nil)
+		}
+		if err := t.sleep(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		start := t.now()
+		resp, err := t.base.RoundTrip(attemptReq)
+		t.observe(req, resp, start)
+
+		var failure *Error
+		if err != nil {
+			// 证书错误重试无法恢复，直接返回
+			if certificateError(err) {
+				return nil, err
+			}
+			failure = newError(req, ErrorTransient, 0, 0, err)
+		} else {
+			t.recordRateLimit(req, resp)
+			failure = t.classify(req, resp)
+		}
+
+		if failure == nil {
+			return resp, nil
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		wait := failure.RetryAfter
+		if wait == 0 {
+			wait = retryBackoff << uint(attempt)
+		}
+
+		if attempt >= maxRetries || wait > maxRetryWait || !retryable(req, failure) {
+			return nil, failure
+		}
+
+		if err := t.sleep(req.Context(), wait); err != nil {
+			return nil, failure
+		}
+
+		attemptReq, err = rewindRequest(req)
+		if err != nil {
+			return nil, failure
+		}
+	}
+}
+
+// observe records a request sent to the provider with the status code of its response
+func (t *retryTransport) observe(req *http.Request, resp *http.Response, start time.Time) {
+	code := 0
+	if resp != nil {
+		code = resp.StatusCode
+	}
+	metrics.ObserveProviderRequest(t.provider, providerOperation(req), code, t.now().Sub(start))
+}
+
+// providerResources are the api resources the operation metric label is named
+// after, looked up from the end of the request path so that names of projects
+// and refs do not end up in the label
+var providerResources = sets.NewString(
+	"access_tokens", "branches", "comments", "commits", "hooks", "installation",
+	"members", "merge_requests", "notes", "permission", "pulls", "statuses", "users",
+)
+
+// providerOperation names the api operation of a request, e.g. get_hooks
+func providerOperation(req *http.Request) string {
+	resource := "other"
+	segments := strings.Split(req.URL.Path, "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if providerResources.Has(segments[i]) {
+			resource = segments[i]
+			break
+		}
+	}
+	return strings.ToLower(req.Method) + "_" + resource
+}
+
+// classify returns the typed error of a failed response, nil when the response
+// is passed on to the provider library
+func (t *retryTransport) classify(req *http.Request, resp *http.Response) *Error {
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return newError(req, ErrorRateLimited, resp.StatusCode, t.retryAfter(resp), nil)
+	case resp.StatusCode == http.StatusForbidden && rateLimited(resp):
+		// github 在 rate limit 耗尽时返回 403
+		return newError(req, ErrorRateLimited, resp.StatusCode, t.retryAfter(resp), nil)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return newError(req, ErrorUnauthorized, resp.StatusCode, 0, nil)
+	case resp.StatusCode == http.StatusNotFound:
+		return newError(req, ErrorNotFound, resp.StatusCode, 0, nil)
+	case resp.StatusCode >= http.StatusInternalServerError && resp.StatusCode != http.StatusNotImplemented:
+		return newError(req, ErrorTransient, resp.StatusCode, t.retryAfter(resp), nil)
+	}
+	return nil
+}
+
+// recordRateLimit remembers the reset time of an exhausted rate limit
+func (t *retryTransport) recordRateLimit(req *http.Request, resp *http.Response) {
+	if !rateLimited(resp) {
+		return
+	}
+	if wait := t.retryAfter(resp); wait > 0 {
+		rateLimits.set(rateLimitKey(req), t.now().Add(wait))
+	}
+}
+
+// retryAfter reads the wait from the Retry-After header, or from the reset
+// time of an exhausted github X-RateLimit-* or gitlab RateLimit-* limit
+func (t *retryTransport) retryAfter(resp *http.Response) time.Duration {
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(value); err == nil && date.After(t.now()) {
+			return date.Sub(t.now())
+		}
+	}
+
+	for _, prefix := range []string{"X-RateLimit-", "RateLimit-"} {
+		if resp.Header.Get(prefix+"Remaining") != "0" {
+			continue
+		}
+		reset, err := strconv.ParseInt(resp.Header.Get(prefix+"Reset"), 10, 64)
+		if err != nil {
+			continue
+		}
+		if resetAt := time.Unix(reset, 0); resetAt.After(t.now()) {
+			return resetAt.Sub(t.now())
+		}
+	}
+
+	return 0
+}
+
+// rateLimited reports whether the response says the rate limit is exhausted
+func rateLimited(resp *http.Response) bool {
+	return resp.Header.Get("X-RateLimit-Remaining") == "0" || resp.Header.Get("RateLimit-Remaining") == "0"
+}
+
+// retryable reports whether a failed call can be sent again. Rate limited calls
+// were rejected before being processed, other failures are only retried for
+// idempotent methods.
+func retryable(req *http.Request, failure *Error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	switch failure.Type {
+	case ErrorRateLimited:
+		return true
+	case ErrorTransient:
+		switch req.Method {
+		case "GET", "HEAD", "OPTIONS", "PUT", "DELETE":
+			return true
+		}
+	}
+	return false
+}
+
+// rewindRequest returns a copy of req with a fresh body for the next attempt
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	retryReq := req.WithContext(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retryReq.Body = body
+	}
+	return retryReq, nil
+}
+
+// certificateError reports whether err is caused by the tls certificate of the provider
+func certificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname)
+}
+
+func newError(req *http.Request, errorType ErrorType, statusCode int, retryAfter time.Duration, err error) *Error {
+	return &Error{
+		Type:       errorType,
+		Method:     req.Method,
+		URL:        req.URL.Scheme + "://" + req.URL.Host + req.URL.Path,
+		StatusCode: statusCode,
+		RetryAfter: retryAfter,
+		Err:        err,
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
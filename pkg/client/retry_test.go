@@ -0,0 +1,221 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/zhd173/githook/pkg/metrics"
+)
+
+// newTestHTTPClient returns a provider http client recording its waits instead of sleeping
+func newTestHTTPClient(t *testing.T, waits *[]time.Duration) *http.Client {
+	httpClient, err := NewHTTPClient(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpClient.Transport.(*retryTransport).sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return nil
+	}
+	return httpClient
+}
+
+func TestRetryTransportRetriesIdempotentCalls(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	waits := []time.Duration{}
+	resp, err := newTestHTTPClient(t, &waits).Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+
+	if calls != 3 || len(waits) != 2 || waits[1] != 2*waits[0] {
+		t.Errorf("expected two retries with backoff, got %d calls waiting %v", calls, waits)
+	}
+}
+
+func TestRetryTransportDoesNotRetryPost(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	waits := []time.Duration{}
+	_, err := newTestHTTPClient(t, &waits).Post(server.URL, "application/json", strings.NewReader("{}"))
+
+	if !IsTransient(err) {
+		t.Errorf("expected transient error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected a single call, got %d", calls)
+	}
+}
+
+func TestRetryTransportClassifiesErrors(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Unix()
+
+	for name, test := range map[string]struct {
+		status    int
+		headers   map[string]string
+		errorType ErrorType
+	}{
+		"not found":    {status: http.StatusNotFound, errorType: ErrorNotFound},
+		"unauthorized": {status: http.StatusUnauthorized, errorType: ErrorUnauthorized},
+		"forbidden":    {status: http.StatusForbidden, errorType: ErrorUnauthorized},
+		"github rate limit": {
+			status:    http.StatusForbidden,
+			headers:   map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(reset, 10)},
+			errorType: ErrorRateLimited,
+		},
+		"gitlab rate limit": {
+			status:    http.StatusTooManyRequests,
+			headers:   map[string]string{"RateLimit-Remaining": "0", "RateLimit-Reset": strconv.FormatInt(reset, 10)},
+			errorType: ErrorRateLimited,
+		},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for key, value := range test.headers {
+				w.Header().Set(key, value)
+			}
+			w.WriteHeader(test.status)
+		}))
+
+		waits := []time.Duration{}
+		_, err := newTestHTTPClient(t, &waits).Get(server.URL)
+		server.Close()
+
+		if errorType, _ := ErrorTypeOf(err); errorType != test.errorType {
+			t.Errorf("%s: expected %s, got %v", name, test.errorType, err)
+		}
+		if test.errorType == ErrorRateLimited && RetryAfter(err) < 59*time.Minute {
+			t.Errorf("%s: expected to retry after the rate limit reset, got %s", name, RetryAfter(err))
+		}
+		if len(waits) != 0 {
+			t.Errorf("%s: unexpected retries %v", name, waits)
+		}
+	}
+}
+
+func TestRetryTransportWaitsForRetryAfter(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Header().Set("Retry-After", "2")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	waits := []time.Duration{}
+	resp, err := newTestHTTPClient(t, &waits).Post(server.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated || len(waits) != 1 || waits[0] != 2*time.Second {
+		t.Errorf("expected a single retry after 2s, got status %d waiting %v", resp.StatusCode, waits)
+	}
+}
+
+func TestRetryTransportKeepsRateLimitsPerToken(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Unix()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "token exhausted" {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	get := func(token string) error {
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		req.Header.Set("Authorization", "token "+token)
+		waits := []time.Duration{}
+		resp, err := newTestHTTPClient(t, &waits).Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := get("exhausted"); !IsRateLimited(err) {
+		t.Fatalf("expected rate limited error, got %v", err)
+	}
+	if err := get("other"); err != nil {
+		t.Errorf("expected the other token on the same host to be allowed, got %v", err)
+	}
+	if err := get("exhausted"); !IsRateLimited(err) {
+		t.Errorf("expected the exhausted token to stay rate limited, got %v", err)
+	}
+}
+
+func TestRetryTransportRecordsEachRequest(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	waits := []time.Duration{}
+	httpClient := defaultHTTPClient("retry-test", newTestHTTPClient(t, &waits))
+	resp, err := httpClient.Get(server.URL + "/api/v4/projects/group%2Fhooks/hooks/12")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	resp.Body.Close()
+
+	if count := testutil.ToFloat64(metrics.ProviderRequests.WithLabelValues("retry-test", "get_hooks", "502")); count != 2 {
+		t.Errorf("expected 2 failed requests, got %v", count)
+	}
+	if count := testutil.ToFloat64(metrics.ProviderRequests.WithLabelValues("retry-test", "get_hooks", "200")); count != 1 {
+		t.Errorf("expected 1 successful request, got %v", count)
+	}
+}
+
+func TestProviderOperation(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/api/v3/repos/owner/repo/hooks":                      "post_hooks",
+		"/repos/owner/repo/statuses/abc123":                   "post_statuses",
+		"/repos/owner/repo/commits/feature/login":             "post_commits",
+		"/repos/owner/repo/collaborators/alice/permission":    "post_permission",
+		"/api/v4/projects/1/merge_requests/2/notes":           "post_notes",
+		"/api/v1/repos/owner/repo":                            "post_other",
+		"/app/installations/42/access_tokens":                 "post_access_tokens",
+		"/api/v4/projects/group%2Fproject/repository/commits": "post_commits",
+	} {
+		req, _ := http.NewRequest("POST", "https://git.example.com"+path, nil)
+		if operation := providerOperation(req); operation != expected {
+			t.Errorf("%s: expected %s, got %s", path, expected, operation)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhd173/githook/pkg/model"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// transports caches the transports by their options, so that the clients
+// created for each reconcile share the idle connections of their options
+var transports = &transportCache{transports: map[string]*http.Transport{}}
+
+type transportCache struct {
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// NewHTTPClient creates the http client used to call the provider api. Calls
+// are retried and failures returned as *Error, see retryTransport. A nil
+// transport config returns a client using the default transport settings.
+// Clients created with the same options share their transport.
+func NewHTTPClient(options *model.TransportOptions) (*http.Client, error) {
+	transport, err := transports.get(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Client{Transport: newRetryTransport(transport)}, nil
+}
+
+// get returns the transport of the options, creating it when missing
+func (cache *transportCache) get(options *model.TransportOptions) (*http.Transport, error) {
+	key := transportKey(options)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if transport, ok := cache.transports[key]; ok {
+		return transport, nil
+	}
+
+	transport, err := newTransport(options)
+	if err != nil {
+		return nil, err
+	}
+	cache.transports[key] = transport
+
+	return transport, nil
+}
+
+// transportKey identifies the transport options, the ca bundle by its hash
+func transportKey(options *model.TransportOptions) string {
+	if options == nil {
+		return ""
+	}
+
+	caBundle := sha256.Sum256(options.CABundle)
+	return fmt.Sprintf("%x/%t/%s/%s", caBundle, options.InsecureSkipVerify, options.ProxyURL, strings.Join(options.NoProxy, ","))
+}
+
+func newTransport(options *model.TransportOptions) (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if options != nil {
+		tlsConfig, err := newTLSConfig(options)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+
+		if options.ProxyURL != "" {
+			proxyFunc, err := newProxyFunc(options)
+			if err != nil {
+				return nil, err
+			}
+			transport.Proxy = proxyFunc
+		}
+	}
+
+	return transport, nil
+}
+
+// defaultHTTPClient returns httpClient, or a client with the default transport
+// settings when nil, with the api request metrics labelled with provider
+func defaultHTTPClient(provider string, httpClient *http.Client) *http.Client {
+	if httpClient == nil {
+		httpClient, _ = NewHTTPClient(nil)
+	}
+
+	transport, ok := httpClient.Transport.(*retryTransport)
+	if !ok || transport.provider == provider {
+		return httpClient
+	}
+
+	labelled := *transport
+	labelled.provider = provider
+	client := *httpClient
+	client.Transport = &labelled
+
+	return &client
+}
+
+func newTLSConfig(options *model.TransportOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+
+	if len(options.CABundle) == 0 {
+		return tlsConfig, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(options.CABundle) {
+		return nil, fmt.Errorf("no valid PEM certificate found in ca bundle")
+	}
+	tlsConfig.RootCAs = pool
+
+	return tlsConfig, nil
+}
+
+func newProxyFunc(options *model.TransportOptions) (func(*http.Request) (*url.URL, error), error) {
+	if _, err := url.Parse(options.ProxyURL); err != nil {
+		return nil, fmt.Errorf("invalid proxy url %s: %s", options.ProxyURL, err)
+	}
+
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  options.ProxyURL,
+		HTTPSProxy: options.ProxyURL,
+		NoProxy:    strings.Join(options.NoProxy, ","),
+	}).ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, nil
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zhd173/githook/pkg/model"
+)
+
+func TestNewHTTPClientTrustsCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	httpClient, err := NewHTTPClient(&model.TransportOptions{CABundle: caBundle})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := httpClient.Get(server.URL); err != nil {
+		t.Errorf("request with ca bundle failed: %s", err)
+	}
+
+	httpClient, _ = NewHTTPClient(nil)
+	if _, err := httpClient.Get(server.URL); err == nil {
+		t.Error("expected request without ca bundle to fail")
+	}
+}
+
+func TestNewHTTPClientRejectsInvalidCABundle(t *testing.T) {
+	if _, err := NewHTTPClient(&model.TransportOptions{CABundle: []byte("not a certificate")}); err == nil {
+		t.Error("expected error for invalid ca bundle")
+	}
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	httpClient, err := NewHTTPClient(&model.TransportOptions{
+		ProxyURL: "http://proxy.example.com:3128",
+		NoProxy:  []string{"git.internal.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	proxy := httpClient.Transport.(*retryTransport).base.(*http.Transport).Proxy
+
+	for target, expected := range map[string]string{
+		"https://gitlab.com/api/v4/projects":               "http://proxy.example.com:3128",
+		"https://git.internal.example.com/api/v4/projects": "",
+	} {
+		req, _ := http.NewRequest("GET", target, nil)
+		proxyURL, err := proxy(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		actual := ""
+		if proxyURL != nil {
+			actual = proxyURL.String()
+		}
+		if actual != expected {
+			t.Errorf("proxy for %s: expected %q, got %q", target, expected, actual)
+		}
+	}
+}
+
+func TestNewHTTPClientSharesTransportOfOptions(t *testing.T) {
+	transportOf := func(options *model.TransportOptions) http.RoundTripper {
+		httpClient, err := NewHTTPClient(options)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return httpClient.Transport.(*retryTransport).base
+	}
+
+	proxied := &model.TransportOptions{ProxyURL: "http://proxy.example.com:3128"}
+	if transportOf(proxied) != transportOf(&model.TransportOptions{ProxyURL: "http://proxy.example.com:3128"}) {
+		t.Error("expected the clients of the same options to share their transport")
+	}
+	if transportOf(proxied) == transportOf(&model.TransportOptions{ProxyURL: "http://proxy.example.com:3128", InsecureSkipVerify: true}) {
+		t.Error("expected the clients of different options to have their own transport")
+	}
+	if transportOf(nil) != transportOf(nil) {
+		t.Error("expected the clients of the default options to share their transport")
+	}
+}
+
+func TestDefaultHTTPClientLabelsProvider(t *testing.T) {
+	httpClient, _ := NewHTTPClient(nil)
+
+	labelled := defaultHTTPClient("gitlab", httpClient)
+	if provider := labelled.Transport.(*retryTransport).provider; provider != "gitlab" {
+		t.Errorf("expected gitlab provider, got %q", provider)
+	}
+	if provider := httpClient.Transport.(*retryTransport).provider; provider != "" {
+		t.Errorf("expected the shared client to be left unlabelled, got %q", provider)
+	}
+}
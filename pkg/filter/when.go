@@ -0,0 +1,162 @@
+package filter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/checker/decls"
+	"github.com/zhd173/githook/pkg/model"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+)
+
+// When holds the compiled CEL expressions an event has to satisfy to trigger.
+// The expressions see the normalized git event as event, with its changed
+// files under event.changedFiles, the raw payload of the git provider as body,
+// and the delivery headers by lower case name as header.
+type When struct {
+	expressions []string
+	programs    []cel.Program
+}
+
+// Compile parses and type checks the expressions, the error names the first
+// invalid expression
+func Compile(expressions []string) (*When, error) {
+	env, err := cel.NewEnv(cel.Declarations(
+		decls.NewIdent("event", decls.NewMapType(decls.String, decls.Dyn), nil),
+		decls.NewIdent("body", decls.Dyn, nil),
+		decls.NewIdent("header", decls.NewMapType(decls.String, decls.String), nil),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	when := &When{}
+	for _, expression := range expressions {
+		ast, issues := env.Compile(expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("invalid when expression %q: %s", expression, issues.Err())
+		}
+
+		if !isBool(ast.ResultType()) {
+			return nil, fmt.Errorf("invalid when expression %q: evaluates to %s instead of bool", expression, checker.FormatCheckedType(ast.ResultType()))
+		}
+
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("invalid when expression %q: %s", expression, err)
+		}
+
+		when.expressions = append(when.expressions, expression)
+		when.programs = append(when.programs, program)
+	}
+
+	return when, nil
+}
+
+// Match evaluates the expressions against an event. When the event does not
+// match it returns the first expression evaluating to false, along with the
+// evaluation error if the expression failed to evaluate.
+func (when *When) Match(event *model.GitEvent, header http.Header) (bool, string, error) {
+	if when == nil || len(when.programs) == 0 {
+		return true, "", nil
+	}
+
+	vars, err := variables(event, header)
+	if err != nil {
+		return false, "", err
+	}
+
+	for i, program := range when.programs {
+		out, _, err := program.Eval(vars)
+		if err != nil {
+			return false, when.expressions[i], err
+		}
+
+		if matched, ok := out.Value().(bool); !ok || !matched {
+			return false, when.expressions[i], nil
+		}
+	}
+
+	return true, "", nil
+}
+
+func isBool(t *exprpb.Type) bool {
+	return t.GetPrimitive() == exprpb.Type_BOOL || t.GetDyn() != nil
+}
+
+func variables(event *model.GitEvent, header http.Header) (map[string]interface{}, error) {
+	eventVar := map[string]interface{}{}
+	if err := convert(event, &eventVar); err != nil {
+		return nil, fmt.Errorf("failed to convert %s event: %s", event.Type, err)
+	}
+	// omitted strings are set empty so that expressions such as event.tag != ""
+	// evaluate for all events, while has() still tells the objects apart
+	for _, key := range []string{"deliveryId", "action", "ref", "before", "after", "tag", "label"} {
+		if _, ok := eventVar[key]; !ok {
+			eventVar[key] = ""
+		}
+	}
+	if _, ok := eventVar["commits"]; !ok {
+		eventVar["commits"] = []interface{}{}
+	}
+	eventVar["changedFiles"] = toList(event.ChangedFiles())
+
+	var body interface{} = map[string]interface{}{}
+	if len(event.Payload) > 0 {
+		if err := json.Unmarshal(event.Payload, &body); err != nil {
+			return nil, fmt.Errorf("failed to decode %s payload: %s", event.Type, err)
+		}
+	}
+
+	headerVar := map[string]string{}
+	for name := range header {
+		headerVar[strings.ToLower(name)] = header.Get(name)
+	}
+
+	return map[string]interface{}{
+		"event":  integers(eventVar),
+		"body":   integers(body),
+		"header": headerVar,
+	}, nil
+}
+
+func convert(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func toList(values []string) []interface{} {
+	list := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		list = append(list, value)
+	}
+	return list
+}
+
+// integers turns the whole JSON numbers into integers, so that expressions
+// such as event.pullRequest.number == 7 compare ints rather than doubles
+func integers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = integers(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = integers(item)
+		}
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+			return int64(value)
+		}
+	}
+	return value
+}
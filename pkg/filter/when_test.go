@@ -0,0 +1,85 @@
+package filter
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/zhd173/githook/pkg/model"
+)
+
+func TestCompileRejectsInvalidExpressions(t *testing.T) {
+	for _, expression := range []string{
+		`event.type ==`,
+		`event.type + 1`,
+		`unknown == "push"`,
+		`"push"`,
+	} {
+		_, err := Compile([]string{`event.type == "push"`, expression})
+		if err == nil {
+			t.Errorf("expected error for %q", expression)
+			continue
+		}
+		if !strings.Contains(err.Error(), fmt.Sprintf("%q", expression)) {
+			t.Errorf("expected error to name %q, got %s", expression, err)
+		}
+	}
+}
+
+func TestWhenMatch(t *testing.T) {
+	event := &model.GitEvent{
+		Type:   "push",
+		Ref:    "refs/heads/main",
+		Sender: model.GitUser{Login: "jane"},
+		Commits: []model.GitCommit{
+			{Modified: []string{"deploy/app.yaml"}},
+		},
+		Payload: []byte(`{"pull_request": {"number": 7, "labels": [{"name": "run-e2e"}]}}`),
+	}
+	header := http.Header{}
+	header.Set("X-GitHub-Event", "push")
+
+	tests := []struct {
+		expression string
+		matched    bool
+	}{
+		{`event.type == "push" && event.ref == "refs/heads/main"`, true},
+		{`!event.sender.login.endsWith("[bot]")`, true},
+		{`event.changedFiles.exists(f, f.startsWith("deploy/"))`, true},
+		{`body.pull_request.labels.exists(l, l.name == "run-e2e")`, true},
+		{`body.pull_request.number == 7`, true},
+		{`header["x-github-event"] == "push"`, true},
+		{`event.ref == "refs/heads/develop"`, false},
+	}
+
+	for _, test := range tests {
+		when, err := Compile([]string{test.expression})
+		if err != nil {
+			t.Fatalf("unexpected error compiling %q: %s", test.expression, err)
+		}
+
+		matched, failed, err := when.Match(event, header)
+		if err != nil {
+			t.Errorf("unexpected error evaluating %q: %s", test.expression, err)
+		}
+		if matched != test.matched {
+			t.Errorf("expected %q to be %t", test.expression, test.matched)
+		}
+		if !matched && failed != test.expression {
+			t.Errorf("expected failing expression %q, got %q", test.expression, failed)
+		}
+	}
+}
+
+func TestWhenMatchReportsEvaluationError(t *testing.T) {
+	when, err := Compile([]string{`event.pullRequest.title.contains("WIP")`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	matched, failed, err := when.Match(&model.GitEvent{Type: "push"}, nil)
+	if matched || err == nil || failed == "" {
+		t.Errorf("expected evaluation error, got %t %q %v", matched, failed, err)
+	}
+}
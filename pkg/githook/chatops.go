@@ -0,0 +1,321 @@
+package githook
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhd173/githook/pkg/metrics"
+	"github.com/zhd173/githook/pkg/model"
+	"github.com/zhd173/githook/pkg/tekton"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Slash commands of pull request comments
+const (
+	commandRetest = "retest"
+	commandTest   = "test"
+	commandCancel = "cancel"
+	// commandOkToTest approves an untrusted pull request, see PullRequestConfig
+	commandOkToTest = okToTestLabel
+)
+
+// pullRequestEventTypes are the event types the commands run the triggers
+// with, by git provider
+var pullRequestEventTypes = map[string]string{
+	"github": "pull_request",
+	"gitlab": "merge_request",
+}
+
+// ChatOpsClient provides the git provider api used by the slash commands
+type ChatOpsClient interface {
+	GetPullRequest(options *model.HookOptions, number int) (*model.GitPullRequest, error)
+	GetPermission(options *model.HookOptions, user string) (string, error)
+	IsTeamMember(options *model.HookOptions, team, user string) (bool, error)
+	CreateComment(options *model.HookOptions, number int, body string) error
+}
+
+// ChatOpsConfig selects the users allowed to run the slash commands, users
+// with write permission on the repository when empty
+type ChatOpsConfig struct {
+	AllowedUsers []string `json:"allowedUsers,omitempty"`
+	AllowedTeams []string `json:"allowedTeams,omitempty"`
+	Permission   string   `json:"permission,omitempty"`
+}
+
+// ChatOps calls the git provider for the slash commands of pull request
+// comments: /retest runs the triggers of pull request events again on the
+// head commit, /test <trigger> runs the named trigger, and /cancel cancels
+// the running PipelineRuns of the pull request
+type ChatOps struct {
+	Client  ChatOpsClient
+	Options *model.HookOptions
+	// Statuses reports the commit statuses, nil when the git provider has no
+	// commit status api
+	Statuses CommitStatusClient
+}
+
+// command is a slash command of a comment
+type command struct {
+	name string
+	arg  string
+}
+
+// parseCommand returns the first slash command found at the start of a line
+// of a comment, nil without command
+func parseCommand(body string) *command {
+	for _, line := range strings.Split(body, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+			continue
+		}
+
+		switch name := strings.TrimPrefix(fields[0], "/"); name {
+		case commandRetest, commandCancel, commandOkToTest:
+			return &command{name: name}
+		case commandTest:
+			parsed := &command{name: name}
+			if len(fields) > 1 {
+				parsed.arg = fields[1]
+			}
+			return parsed
+		}
+	}
+	return nil
+}
+
+// allowed reports whether a user may run the slash commands
+func (chatops *ChatOps) allowed(config *ChatOpsConfig, user string) (bool, error) {
+	if user == "" {
+		return false, nil
+	}
+
+	for _, allowed := range config.AllowedUsers {
+		if strings.EqualFold(allowed, user) {
+			return true, nil
+		}
+	}
+
+	for _, team := range config.AllowedTeams {
+		member, err := chatops.Client.IsTeamMember(chatops.Options, team, user)
+		if err != nil {
+			return false, err
+		}
+		if member {
+			return true, nil
+		}
+	}
+
+	required := config.Permission
+	if required == "" {
+		if len(config.AllowedUsers) > 0 || len(config.AllowedTeams) > 0 {
+			return false, nil
+		}
+		required = model.PermissionWrite
+	}
+
+	permission, err := chatops.Client.GetPermission(chatops.Options, user)
+	if err != nil {
+		return false, err
+	}
+
+	return model.PermissionAllows(permission, required), nil
+}
+
+// commandOf returns the slash command of a new pull request comment, nil
+// for other events or when the command is disabled
+func (ra *ReceiveAdapter) commandOf(config *compiledConfig, event *model.GitEvent) *command {
+	if ra.ChatOps == nil {
+		return nil
+	}
+	if event.Comment == nil || event.Issue == nil || !event.Issue.PullRequest || event.Action != "created" {
+		return nil
+	}
+
+	cmd := parseCommand(event.Comment.Body)
+	switch {
+	case cmd == nil:
+		return nil
+	case cmd.name == commandOkToTest:
+		if !config.pullRequest.requiresApproval() {
+			return nil
+		}
+	case config.chatops == nil:
+		return nil
+	}
+	return cmd
+}
+
+// runCommand runs the slash command of a comment and replies with its outcome
+func (ra *ReceiveAdapter) runCommand(config *compiledConfig, cmd *command, event *model.GitEvent, header http.Header, received time.Time, record *DeliveryRecord) (bool, error) {
+	user := event.Sender.Login
+	number := event.Issue.Number
+	ra.observeDelivery(event.Type, metrics.DecisionAccepted)
+	record.Decision = metrics.DecisionAccepted
+
+	// 只有协作者可以批准不受信任的 PR
+	allowedConfig := config.chatops
+	if cmd.name == commandOkToTest {
+		allowedConfig = &ChatOpsConfig{Permission: model.PermissionWrite}
+	}
+
+	allowed, err := ra.ChatOps.allowed(allowedConfig, user)
+	if err != nil {
+		ra.event(corev1.EventTypeWarning, reasonCommandFailed, "Failed to check permission of %s for /%s on #%d: %s", user, cmd.name, number, err)
+		return false, err
+	}
+	if !allowed {
+		log.Printf("Rejecting /%s of %s on #%d", cmd.name, user, number)
+		ra.event(corev1.EventTypeNormal, reasonCommandRejected, "Rejected /%s of %s on #%d", cmd.name, user, number)
+		ra.reply(number, fmt.Sprintf("@%s is not allowed to run `/%s`.", user, cmd.name))
+		return true, nil
+	}
+
+	switch cmd.name {
+	case commandCancel:
+		return ra.cancel(number)
+	case commandTest:
+		if cmd.arg == "" {
+			ra.reply(number, "Usage: `/test <trigger>`.")
+			return true, nil
+		}
+	}
+
+	prEvent, err := ra.pullRequestEvent(cmd, event)
+	if err != nil {
+		ra.event(corev1.EventTypeWarning, reasonCommandFailed, "Failed to get pull request #%d for /%s: %s", number, cmd.name, err)
+		return false, err
+	}
+
+	// /ok-to-test 即批准，其他命令仍受不受信任 PR 的策略限制
+	if cmd.name != commandOkToTest {
+		admitted, reason, err := ra.admitPullRequest(config, prEvent)
+		if err != nil {
+			ra.event(corev1.EventTypeWarning, reasonCommandFailed, "Failed to check the author of #%d for /%s: %s", number, cmd.name, err)
+			return false, err
+		}
+		if !admitted {
+			ra.event(corev1.EventTypeNormal, reasonPullRequestUntrusted, "Held /%s on #%d: %s", cmd.name, number, reason)
+			ra.reply(number, fmt.Sprintf("`/%s` was not run: %s.", cmd.name, reason))
+			record.Decision = decisionHeld
+			return true, nil
+		}
+	}
+
+	// /retest 与 pull request 事件一样经过 when 表达式，/test 指定的触发器直接运行
+	if cmd.name != commandTest {
+		matched, expression, err := config.when.Match(prEvent, header)
+		if !matched {
+			ra.reportSkipped(prEvent, "", expression, err)
+			ra.reply(number, fmt.Sprintf("`/%s` was skipped by the when expressions of the GitHook.", cmd.name))
+			return true, nil
+		}
+	}
+
+	var created []string
+	var firstErr error
+	for _, trigger := range config.triggers {
+		if cmd.name == commandTest && trigger.Name != cmd.arg {
+			continue
+		}
+		if cmd.name != commandTest {
+			if !trigger.handles(prEvent) {
+				continue
+			}
+			matched, expression, err := trigger.When.Match(prEvent, header)
+			if !matched {
+				ra.reportSkipped(prEvent, trigger.Name, expression, err)
+				continue
+			}
+		}
+
+		pipelineRun, err := ra.runTrigger(trigger, config, prEvent, received)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		record.addRun(pipelineRun, config.dryRun)
+		created = append(created, runName(pipelineRun))
+	}
+
+	switch {
+	case len(created) > 0 && config.dryRun:
+		ra.reply(number, fmt.Sprintf("Rendered PipelineRun `%s` for `/%s` without creating it, the GitHook is in dry run.", strings.Join(created, "`, `"), cmd.name))
+	case len(created) > 0:
+		ra.reply(number, fmt.Sprintf("Created PipelineRun `%s` for `/%s`.", strings.Join(created, "`, `"), cmd.name))
+	case firstErr != nil:
+		ra.reply(number, fmt.Sprintf("Failed to run `/%s`: %s", cmd.name, firstErr))
+	case cmd.name == commandTest:
+		ra.reply(number, fmt.Sprintf("Unknown trigger `%s`.", cmd.arg))
+	default:
+		ra.reply(number, fmt.Sprintf("No trigger matched `/%s`.", cmd.name))
+	}
+
+	return firstErr == nil || len(created) > 0, firstErr
+}
+
+// cancel cancels the running PipelineRuns of a pull request
+func (ra *ReceiveAdapter) cancel(number int) (bool, error) {
+	cancelled, err := ra.TektonClient.CancelPipelineRuns(ra.Namespace, map[string]string{
+		tekton.LabelGitHook:     ra.Name,
+		tekton.LabelPullRequest: strconv.Itoa(number),
+	})
+	for _, name := range cancelled {
+		ra.event(corev1.EventTypeNormal, reasonPipelineRunCancelled, "Cancelled PipelineRun %s of #%d", name, number)
+	}
+	if err != nil {
+		ra.event(corev1.EventTypeWarning, reasonCommandFailed, "Failed to cancel the PipelineRuns of #%d: %s", number, err)
+		ra.reply(number, fmt.Sprintf("Failed to cancel the running PipelineRuns: %s", err))
+		return len(cancelled) > 0, err
+	}
+
+	if len(cancelled) == 0 {
+		ra.reply(number, "No running PipelineRun to cancel.")
+		return true, nil
+	}
+	ra.reply(number, fmt.Sprintf("Cancelled PipelineRun `%s`.", strings.Join(cancelled, "`, `")))
+	return true, nil
+}
+
+// pullRequestEvent turns a comment into the pull request event of its head
+// commit, with the command as action
+func (ra *ReceiveAdapter) pullRequestEvent(cmd *command, event *model.GitEvent) (*model.GitEvent, error) {
+	pr := event.PullRequest
+	if pr == nil || pr.HeadSHA == "" {
+		var err error
+		pr, err = ra.ChatOps.Client.GetPullRequest(ra.ChatOps.Options, event.Issue.Number)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	eventType, ok := pullRequestEventTypes[ra.Provider]
+	if !ok {
+		eventType = "pull_request"
+	}
+
+	prEvent := *event
+	prEvent.Type = eventType
+	prEvent.Action = cmd.name
+	prEvent.Ref = model.BranchRef(pr.HeadRef)
+	prEvent.Before = ""
+	prEvent.After = pr.HeadSHA
+	prEvent.Tag = ""
+	prEvent.Commits = nil
+	prEvent.PullRequest = pr
+
+	return &prEvent, nil
+}
+
+// reply comments on a pull request, failures are only reported
+func (ra *ReceiveAdapter) reply(number int, body string) {
+	if err := ra.ChatOps.Client.CreateComment(ra.ChatOps.Options, number, body); err != nil {
+		log.Printf("failed to comment on #%d: %s", number, err)
+		ra.event(corev1.EventTypeWarning, reasonCommandFailed, "Failed to comment on #%d: %s", number, err)
+	}
+}
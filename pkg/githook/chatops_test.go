@@ -0,0 +1,229 @@
+package githook
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tektonv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/zhd173/githook/pkg/model"
+	"github.com/zhd173/githook/pkg/tekton"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeChatOpsClient answers the provider api calls of the slash commands
+type fakeChatOpsClient struct {
+	pullRequest *model.GitPullRequest
+	permissions map[string]string
+	teams       map[string][]string
+	comments    []string
+}
+
+func (client *fakeChatOpsClient) GetPullRequest(options *model.HookOptions, number int) (*model.GitPullRequest, error) {
+	return client.pullRequest, nil
+}
+
+func (client *fakeChatOpsClient) GetPermission(options *model.HookOptions, user string) (string, error) {
+	if permission, ok := client.permissions[user]; ok {
+		return permission, nil
+	}
+	return model.PermissionNone, nil
+}
+
+func (client *fakeChatOpsClient) IsTeamMember(options *model.HookOptions, team, user string) (bool, error) {
+	for _, member := range client.teams[team] {
+		if member == user {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (client *fakeChatOpsClient) CreateComment(options *model.HookOptions, number int, body string) error {
+	client.comments = append(client.comments, body)
+	return nil
+}
+
+// fakePipelineRunClient records the PipelineRuns created, rendered and cancelled
+type fakePipelineRunClient struct {
+	created   []tekton.PipelineOptions
+	rendered  []tekton.PipelineOptions
+	revisions []string
+	selectors []map[string]string
+	running   []string
+}
+
+func (client *fakePipelineRunClient) CreatePipelineRun(options tekton.PipelineOptions, event *model.GitEvent) (*tektonv1alpha1.PipelineRun, error) {
+	client.created = append(client.created, options)
+	client.revisions = append(client.revisions, event.Revision())
+	return &tektonv1alpha1.PipelineRun{ObjectMeta: metav1.ObjectMeta{Name: options.Prefix + "-1"}}, nil
+}
+
+func (client *fakePipelineRunClient) RenderPipelineRun(options tekton.PipelineOptions, event *model.GitEvent) (*tektonv1alpha1.PipelineRun, error) {
+	client.rendered = append(client.rendered, options)
+	return &tektonv1alpha1.PipelineRun{ObjectMeta: metav1.ObjectMeta{GenerateName: options.Prefix + "-", Labels: options.Labels}}, nil
+}
+
+func (client *fakePipelineRunClient) CancelPipelineRuns(namespace string, selector map[string]string) ([]string, error) {
+	client.selectors = append(client.selectors, selector)
+	return client.running, nil
+}
+
+func newCommentEvent(body string) *model.GitEvent {
+	return &model.GitEvent{
+		Provider:   "github",
+		Type:       "issue_comment",
+		Action:     "created",
+		Repository: model.GitRepository{FullName: "owner/repo", CloneURL: "https://github.com/owner/repo.git"},
+		Sender:     model.GitUser{Login: "alice"},
+		Issue:      &model.GitIssue{Number: 7, PullRequest: true},
+		Comment:    &model.GitComment{ID: 1, Body: body},
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		body     string
+		expected *command
+	}{
+		{"/retest", &command{name: "retest"}},
+		{"flaky test\n/test  integration please", &command{name: "test", arg: "integration"}},
+		{"/cancel", &command{name: "cancel"}},
+		{"please /retest", nil},
+		{"/approve", nil},
+	}
+
+	for _, test := range tests {
+		if parsed := parseCommand(test.body); !reflect.DeepEqual(parsed, test.expected) {
+			t.Errorf("expected %v for %q, got %v", test.expected, test.body, parsed)
+		}
+	}
+}
+
+func TestChatOpsAllowed(t *testing.T) {
+	chatops := &ChatOps{Client: &fakeChatOpsClient{
+		permissions: map[string]string{"alice": model.PermissionWrite, "bob": model.PermissionRead},
+		teams:       map[string][]string{"org/ci": {"carol"}},
+	}}
+
+	tests := []struct {
+		config   *ChatOpsConfig
+		user     string
+		expected bool
+	}{
+		{&ChatOpsConfig{}, "alice", true},
+		{&ChatOpsConfig{}, "bob", false},
+		{&ChatOpsConfig{Permission: model.PermissionRead}, "bob", true},
+		{&ChatOpsConfig{AllowedUsers: []string{"Bob"}}, "bob", true},
+		{&ChatOpsConfig{AllowedUsers: []string{"bob"}}, "alice", false},
+		{&ChatOpsConfig{AllowedTeams: []string{"org/ci"}}, "carol", true},
+		{&ChatOpsConfig{AllowedTeams: []string{"org/ci"}, Permission: model.PermissionAdmin}, "alice", false},
+	}
+
+	for _, test := range tests {
+		allowed, err := chatops.allowed(test.config, test.user)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if allowed != test.expected {
+			t.Errorf("expected allowed %t for %s with %+v", test.expected, test.user, test.config)
+		}
+	}
+}
+
+func TestRetestRunsPullRequestTriggersOnHeadCommit(t *testing.T) {
+	build, err := NewTrigger(TriggerConfig{Name: "build", EventTypes: []string{"pull_request"}, RunSpec: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	release, err := NewTrigger(TriggerConfig{Name: "release", EventTypes: []string{"push"}, RunSpec: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tektonClient := &fakePipelineRunClient{}
+	chatopsClient := &fakeChatOpsClient{
+		pullRequest: &model.GitPullRequest{Number: 7, HeadRef: "feature", HeadSHA: "abc123"},
+		permissions: map[string]string{"alice": model.PermissionWrite},
+	}
+	ra := &ReceiveAdapter{
+		TektonClient:  tektonClient,
+		Provider:      "github",
+		Namespace:     "default",
+		Name:          "hook",
+		Triggers:      []*Trigger{build, release},
+		ChatOpsConfig: &ChatOpsConfig{},
+		ChatOps:       &ChatOps{Client: chatopsClient, Options: &model.HookOptions{}},
+	}
+
+	handled, err := ra.handleEvent(newCommentEvent("/retest"), http.Header{}, time.Now())
+	if err != nil || !handled {
+		t.Fatalf("expected the command to be handled, got %t: %v", handled, err)
+	}
+
+	if len(tektonClient.created) != 1 {
+		t.Fatalf("expected one PipelineRun, got %d", len(tektonClient.created))
+	}
+	expected := map[string]string{tekton.LabelGitHook: "hook", tekton.LabelTrigger: "build", tekton.LabelPullRequest: "7"}
+	if labels := tektonClient.created[0].Labels; !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected labels %v, got %v", expected, labels)
+	}
+	if revision := tektonClient.revisions[0]; revision != "abc123" {
+		t.Errorf("expected the head commit abc123, got %s", revision)
+	}
+
+	if len(chatopsClient.comments) != 1 || chatopsClient.comments[0] != "Created PipelineRun `hook-build-1` for `/retest`." {
+		t.Errorf("unexpected replies %v", chatopsClient.comments)
+	}
+}
+
+func TestCommandRejectsUnauthorizedUser(t *testing.T) {
+	tektonClient := &fakePipelineRunClient{}
+	chatopsClient := &fakeChatOpsClient{}
+	ra := &ReceiveAdapter{
+		TektonClient:  tektonClient,
+		Provider:      "github",
+		Name:          "hook",
+		ChatOpsConfig: &ChatOpsConfig{},
+		ChatOps:       &ChatOps{Client: chatopsClient, Options: &model.HookOptions{}},
+	}
+
+	handled, err := ra.handleEvent(newCommentEvent("/test build"), http.Header{}, time.Now())
+	if err != nil || !handled {
+		t.Fatalf("expected the command to be handled, got %t: %v", handled, err)
+	}
+
+	if len(tektonClient.created) != 0 {
+		t.Errorf("expected no PipelineRun, got %d", len(tektonClient.created))
+	}
+	if len(chatopsClient.comments) != 1 || !strings.Contains(chatopsClient.comments[0], "not allowed") {
+		t.Errorf("unexpected replies %v", chatopsClient.comments)
+	}
+}
+
+func TestCancelSelectsPipelineRunsOfPullRequest(t *testing.T) {
+	tektonClient := &fakePipelineRunClient{running: []string{"hook-build-1"}}
+	chatopsClient := &fakeChatOpsClient{permissions: map[string]string{"alice": model.PermissionAdmin}}
+	ra := &ReceiveAdapter{
+		TektonClient:  tektonClient,
+		Provider:      "github",
+		Namespace:     "default",
+		Name:          "hook",
+		ChatOpsConfig: &ChatOpsConfig{},
+		ChatOps:       &ChatOps{Client: chatopsClient, Options: &model.HookOptions{}},
+	}
+
+	if _, err := ra.handleEvent(newCommentEvent("/cancel"), http.Header{}, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := []map[string]string{{tekton.LabelGitHook: "hook", tekton.LabelPullRequest: "7"}}
+	if !reflect.DeepEqual(tektonClient.selectors, expected) {
+		t.Errorf("expected selectors %v, got %v", expected, tektonClient.selectors)
+	}
+	if len(chatopsClient.comments) != 1 || chatopsClient.comments[0] != "Cancelled PipelineRun `hook-build-1`." {
+		t.Errorf("unexpected replies %v", chatopsClient.comments)
+	}
+}
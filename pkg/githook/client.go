@@ -14,9 +14,11 @@ type ProjectHookClient interface {
 // GitClient provides git client functionalities
 type GitClient interface {
 	Validate(options *model.HookOptions) (exists bool, changed bool, err error)
+	Find(options *model.HookOptions) ([]string, error)
 	Create(options *model.HookOptions) (string, error)
 	Update(options *model.HookOptions) (string, error)
 	Delete(options *model.HookOptions) error
+	ResolveRef(options *model.HookOptions, ref string) (string, error)
 }
 
 // Client provides webhook client
@@ -46,7 +48,17 @@ func (client Client) Validate(options *model.HookOptions) (exists bool, changed
 	return client.GitClient.Validate(options)
 }
 
+// Find returns the IDs of the webhooks registered for the GitHook
+func (client Client) Find(options *model.HookOptions) ([]string, error) {
+	return client.GitClient.Find(options)
+}
+
 // Delete webhook
 func (client Client) Delete(options *model.HookOptions) error {
 	return client.GitClient.Delete(options)
 }
+
+// ResolveRef returns the commit sha of a branch, tag or sha
+func (client Client) ResolveRef(options *model.HookOptions, ref string) (string, error) {
+	return client.GitClient.ResolveRef(options, ref)
+}
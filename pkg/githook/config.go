@@ -0,0 +1,123 @@
+package githook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+
+	"github.com/zhd173/githook/pkg/filter"
+	"github.com/zhd173/githook/pkg/tekton"
+)
+
+// ReceiverConfig is the configuration of the receiver, written by the
+// controller to a ConfigMap mounted in the receiver
+type ReceiverConfig struct {
+	When     []string        `json:"when,omitempty"`
+	Params   []tekton.Param  `json:"params,omitempty"`
+	Triggers []TriggerConfig `json:"triggers,omitempty"`
+	ChatOps  *ChatOpsConfig  `json:"chatops,omitempty"`
+	// PullRequest is the policy of the pull requests of untrusted authors
+	PullRequest *PullRequestConfig `json:"pullRequest,omitempty"`
+	// DryRun renders the PipelineRuns without creating them
+	DryRun bool `json:"dryRun,omitempty"`
+	// CommitStatus reports a pending status on the commit of the events the
+	// PipelineRuns are created for
+	CommitStatus bool `json:"commitStatus,omitempty"`
+	// RateLimit debounces and limits the events running the triggers
+	RateLimit *RateLimitConfig `json:"rateLimit,omitempty"`
+}
+
+// compiledConfig is a receiver configuration ready to handle events
+type compiledConfig struct {
+	when     *filter.When
+	params   []tekton.Param
+	triggers []*Trigger
+	chatops  *ChatOpsConfig
+
+	pullRequest *PullRequestConfig
+	dryRun      bool
+	rateLimit   *RateLimitConfig
+
+	commitStatus bool
+}
+
+func compileConfig(config *ReceiverConfig) (*compiledConfig, error) {
+	when, err := filter.Compile(config.When)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled := &compiledConfig{when: when, params: config.Params, chatops: config.ChatOps, pullRequest: config.PullRequest, dryRun: config.DryRun, rateLimit: config.RateLimit, commitStatus: config.CommitStatus}
+	for _, triggerConfig := range config.Triggers {
+		trigger, err := NewTrigger(triggerConfig)
+		if err != nil {
+			return nil, err
+		}
+		compiled.triggers = append(compiled.triggers, trigger)
+	}
+
+	return compiled, nil
+}
+
+// ConfigFile loads the receiver configuration from a file, and reloads it
+// whenever its content changes, such as when the kubelet updates the mounted
+// ConfigMap
+type ConfigFile struct {
+	Path string
+
+	mu       sync.Mutex
+	content  []byte
+	compiled *compiledConfig
+}
+
+// Load compiles the configuration file, it is meant to fail the start of the
+// receiver on an invalid configuration
+func (file *ConfigFile) Load() error {
+	_, err := file.load()
+	return err
+}
+
+// config returns the current configuration, and keeps the last valid one
+// when the file cannot be read or compiled
+func (file *ConfigFile) config() *compiledConfig {
+	compiled, err := file.load()
+	if err != nil {
+		log.Printf("failed to reload receiver config %s, keeping the previous one: %s", file.Path, err)
+	}
+	return compiled
+}
+
+func (file *ConfigFile) load() (*compiledConfig, error) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+
+	content, err := ioutil.ReadFile(file.Path)
+	if err != nil {
+		return file.compiled, err
+	}
+
+	if file.compiled != nil && bytes.Equal(content, file.content) {
+		return file.compiled, nil
+	}
+
+	config := &ReceiverConfig{}
+	if err := json.Unmarshal(content, config); err != nil {
+		return file.compiled, fmt.Errorf("invalid receiver config: %s", err)
+	}
+
+	compiled, err := compileConfig(config)
+	if err != nil {
+		return file.compiled, err
+	}
+
+	if file.compiled != nil {
+		log.Printf("reloaded receiver config %s", file.Path)
+	}
+	file.content = content
+	file.compiled = compiled
+
+	return compiled, nil
+}
@@ -0,0 +1,75 @@
+package githook
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigFileReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "githook-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"triggers": [{"name": "build", "eventTypes": ["push"], "runSpec": {}}]}`)
+	file := &ConfigFile{Path: path}
+	if err := file.Load(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if triggers := file.config().triggers; len(triggers) != 1 || triggers[0].Name != "build" {
+		t.Fatalf("expected the build trigger, got %v", triggers)
+	}
+
+	write(`{"triggers": [{"name": "test", "runSpec": {}}]}`)
+	if triggers := file.config().triggers; len(triggers) != 1 || triggers[0].Name != "test" {
+		t.Fatalf("expected the reloaded test trigger, got %v", triggers)
+	}
+
+	write(`{"when": ["event.ref =="]}`)
+	if triggers := file.config().triggers; len(triggers) != 1 || triggers[0].Name != "test" {
+		t.Errorf("expected the previous config to be kept, got %v", triggers)
+	}
+}
+
+func TestConfigFileLoadRejectsInvalidConfig(t *testing.T) {
+	file := &ConfigFile{Path: filepath.Join(os.TempDir(), "githook-missing-config.json")}
+	if err := file.Load(); err == nil {
+		t.Error("expected error for a missing config file")
+	}
+}
+
+func TestTriggerRunSpecFromTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "githook-template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	template := filepath.Join(dir, "runSpec")
+	if err := ioutil.WriteFile(template, []byte("pipelineRef:\n  name: build\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	trigger, err := NewTrigger(TriggerConfig{Name: "build", RunTemplate: template})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	runSpecJSON, err := trigger.runSpecJSON()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if runSpecJSON != `{"pipelineRef":{"name":"build"}}` {
+		t.Errorf("unexpected run spec %s", runSpecJSON)
+	}
+}
@@ -0,0 +1,65 @@
+package githook
+
+import "sync"
+
+// defaultDeliveryCacheSize bounds the delivery ids remembered by the receive adapter
+const defaultDeliveryCacheSize = 1024
+
+// deliveryCache remembers the ids of the most recent deliveries, so that
+// deliveries sent again by the git provider do not run the pipeline twice
+type deliveryCache struct {
+	mu    sync.Mutex
+	size  int
+	ids   map[string]bool
+	order []string
+}
+
+func newDeliveryCache(size int) *deliveryCache {
+	return &deliveryCache{
+		size: size,
+		ids:  make(map[string]bool, size),
+	}
+}
+
+// contains reports whether the delivery has been handled
+func (c *deliveryCache) contains(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ids[id]
+}
+
+// remove forgets a delivery
+func (c *deliveryCache) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.ids[id] {
+		return
+	}
+
+	delete(c.ids, id)
+	for i, seen := range c.order {
+		if seen == id {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// add records a handled delivery, forgetting the oldest one when full
+func (c *deliveryCache) add(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ids[id] {
+		return
+	}
+
+	if len(c.order) >= c.size {
+		delete(c.ids, c.order[0])
+		c.order = c.order[1:]
+	}
+
+	c.ids[id] = true
+	c.order = append(c.order, id)
+}
@@ -0,0 +1,36 @@
+package githook
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDeliveryCacheForgetsOldestDelivery(t *testing.T) {
+	cache := newDeliveryCache(2)
+
+	for i := 1; i <= 3; i++ {
+		cache.add(fmt.Sprintf("delivery-%d", i))
+	}
+
+	if cache.contains("delivery-1") {
+		t.Error("expected the oldest delivery to be forgotten")
+	}
+	if !cache.contains("delivery-2") || !cache.contains("delivery-3") {
+		t.Error("expected the recent deliveries to be remembered")
+	}
+}
+
+func TestDeliveryCacheRemovesDelivery(t *testing.T) {
+	cache := newDeliveryCache(2)
+	cache.add("delivery-1")
+	cache.add("delivery-2")
+	cache.remove("delivery-1")
+	cache.add("delivery-3")
+
+	if cache.contains("delivery-1") {
+		t.Error("expected the removed delivery to be forgotten")
+	}
+	if !cache.contains("delivery-2") || !cache.contains("delivery-3") {
+		t.Error("expected the other deliveries to be remembered")
+	}
+}
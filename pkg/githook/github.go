@@ -0,0 +1,206 @@
+package githook
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/google/go-github/v26/github"
+	"github.com/zhd173/githook/pkg/model"
+)
+
+// GithubHookServer verifies and parses github webhook deliveries
+type GithubHookServer struct {
+	Secrets *SecretTokens
+
+	// EnterpriseHost is the GitHub Enterprise Server expected to send the
+	// deliveries, deliveries announcing another enterprise host are rejected
+	EnterpriseHost string
+}
+
+// GetEventHeader returns the header carrying the github event type
+func (server *GithubHookServer) GetEventHeader() string {
+	return "GitHub-Event"
+}
+
+// GetDeliveryHeader returns the header carrying the github delivery id
+func (server *GithubHookServer) GetDeliveryHeader() string {
+	return "GitHub-Delivery"
+}
+
+// Parse verifies the X-Hub-Signature of the delivery and parses its payload
+// to a git event
+func (server *GithubHookServer) Parse(r *http.Request) (*model.GitEvent, error) {
+	enterpriseHost := r.Header.Get("X-GitHub-Enterprise-Host")
+	if server.EnterpriseHost != "" && enterpriseHost != "" && enterpriseHost != server.EnterpriseHost {
+		return nil, fmt.Errorf("unexpected github enterprise host %s", enterpriseHost)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read github payload: %s", err)
+	}
+
+	signature := r.Header.Get("X-Hub-Signature")
+	err = server.Secrets.Verify(func(token string) bool {
+		return github.ValidateSignature(signature, body, []byte(token)) == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return server.ParsePayload(r.Header, body)
+}
+
+// ParsePayload parses the payload of a verified delivery to a git event
+func (server *GithubHookServer) ParsePayload(header http.Header, body []byte) (*model.GitEvent, error) {
+	eventType := header.Get("X-" + server.GetEventHeader())
+	payload, err := github.ParseWebHook(eventType, body)
+	if err != nil {
+		return nil, err
+	}
+
+	event := githubEvent(eventType, payload)
+	event.Payload = body
+
+	return event, nil
+}
+
+// githubEvent converts a parsed github payload to a git event
+func githubEvent(eventType string, payload interface{}) *model.GitEvent {
+	event := &model.GitEvent{Provider: "github", Type: eventType}
+
+	switch payload := payload.(type) {
+	case *github.PushEvent:
+		repo := payload.GetRepo()
+		event.Repository = model.GitRepository{
+			FullName:      repo.GetFullName(),
+			Name:          repo.GetName(),
+			Owner:         githubLogin(repo.GetOwner()),
+			CloneURL:      repo.GetCloneURL(),
+			URL:           repo.GetHTMLURL(),
+			DefaultBranch: repo.GetDefaultBranch(),
+		}
+		event.Ref = payload.GetRef()
+		event.Before = payload.GetBefore()
+		event.After = payload.GetAfter()
+		event.Sender = githubUser(payload.GetSender())
+		for _, commit := range payload.Commits {
+			author := commit.GetAuthor()
+			event.Commits = append(event.Commits, model.GitCommit{
+				ID:       commit.GetID(),
+				Message:  commit.GetMessage(),
+				URL:      commit.GetURL(),
+				Author:   model.GitUser{Login: author.GetLogin(), Name: author.GetName(), Email: author.GetEmail()},
+				Added:    commit.Added,
+				Modified: commit.Modified,
+				Removed:  commit.Removed,
+			})
+		}
+	case *github.PullRequestEvent:
+		pr := payload.GetPullRequest()
+		head := pr.GetHead()
+		event.Action = payload.GetAction()
+		event.Repository = githubRepository(payload.GetRepo())
+		event.Ref = model.BranchRef(head.GetRef())
+		event.After = head.GetSHA()
+		event.Sender = githubUser(payload.GetSender())
+		event.Label = payload.GetLabel().GetName()
+		event.PullRequest = &model.GitPullRequest{
+			Number:       pr.GetNumber(),
+			Title:        pr.GetTitle(),
+			State:        pr.GetState(),
+			URL:          pr.GetHTMLURL(),
+			HeadRef:      head.GetRef(),
+			HeadSHA:      head.GetSHA(),
+			HeadCloneURL: head.GetRepo().GetCloneURL(),
+			BaseRef:      pr.GetBase().GetRef(),
+			BaseSHA:      pr.GetBase().GetSHA(),
+			Author:       githubUser(pr.GetUser()),
+			Merged:       pr.GetMerged(),
+		}
+		for _, label := range pr.Labels {
+			event.PullRequest.Labels = append(event.PullRequest.Labels, label.GetName())
+		}
+	case *github.CreateEvent:
+		event.Repository = githubRepository(payload.GetRepo())
+		event.Ref = githubRef(payload.GetRefType(), payload.GetRef())
+		event.Sender = githubUser(payload.GetSender())
+	case *github.DeleteEvent:
+		event.Repository = githubRepository(payload.GetRepo())
+		event.Ref = githubRef(payload.GetRefType(), payload.GetRef())
+		event.Sender = githubUser(payload.GetSender())
+	case *github.ReleaseEvent:
+		event.Action = payload.GetAction()
+		event.Repository = githubRepository(payload.GetRepo())
+		event.Ref = model.TagRef(payload.GetRelease().GetTagName())
+		event.Sender = githubUser(payload.GetSender())
+	case *github.IssuesEvent:
+		event.Action = payload.GetAction()
+		event.Repository = githubRepository(payload.GetRepo())
+		event.Sender = githubUser(payload.GetSender())
+		event.Issue = githubIssue(payload.GetIssue())
+	case *github.IssueCommentEvent:
+		comment := payload.GetComment()
+		event.Action = payload.GetAction()
+		event.Repository = githubRepository(payload.GetRepo())
+		event.Sender = githubUser(payload.GetSender())
+		event.Issue = githubIssue(payload.GetIssue())
+		event.Comment = &model.GitComment{
+			ID:     comment.GetID(),
+			Body:   comment.GetBody(),
+			URL:    comment.GetHTMLURL(),
+			Author: githubUser(comment.GetUser()),
+		}
+	case *github.ForkEvent:
+		event.Repository = githubRepository(payload.GetRepo())
+		event.Sender = githubUser(payload.GetSender())
+	}
+
+	event.Tag = tagName(event.Ref)
+
+	return event
+}
+
+func githubRepository(repo *github.Repository) model.GitRepository {
+	return model.GitRepository{
+		FullName:      repo.GetFullName(),
+		Name:          repo.GetName(),
+		Owner:         githubLogin(repo.GetOwner()),
+		CloneURL:      repo.GetCloneURL(),
+		URL:           repo.GetHTMLURL(),
+		DefaultBranch: repo.GetDefaultBranch(),
+	}
+}
+
+func githubIssue(issue *github.Issue) *model.GitIssue {
+	return &model.GitIssue{
+		Number:      issue.GetNumber(),
+		Title:       issue.GetTitle(),
+		State:       issue.GetState(),
+		URL:         issue.GetHTMLURL(),
+		Author:      githubUser(issue.GetUser()),
+		PullRequest: issue.IsPullRequest(),
+	}
+}
+
+func githubUser(user *github.User) model.GitUser {
+	return model.GitUser{Login: user.GetLogin(), Name: user.GetName(), Email: user.GetEmail()}
+}
+
+// githubLogin returns the login of a repository owner, push payloads only
+// carry its name
+func githubLogin(user *github.User) string {
+	if user.GetLogin() != "" {
+		return user.GetLogin()
+	}
+	return user.GetName()
+}
+
+// githubRef returns the full ref of the short ref of create and delete events
+func githubRef(refType, ref string) string {
+	if refType == "tag" {
+		return model.TagRef(ref)
+	}
+	return model.BranchRef(ref)
+}
@@ -0,0 +1,59 @@
+package githook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGithubParsePush(t *testing.T) {
+	body := `{
+		"ref": "refs/heads/master",
+		"before": "9049f1265b7d61be4a8904a9a27120d2064dab3b",
+		"after": "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+		"commits": [
+			{"id": "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c", "message": "Update README",
+			 "author": {"name": "Jane", "email": "jane@example.com", "username": "jane"},
+			 "added": ["docs/a.md"], "modified": ["README.md"], "removed": []}
+		],
+		"repository": {
+			"name": "repo", "full_name": "owner/repo",
+			"owner": {"name": "owner"},
+			"clone_url": "https://github.com/owner/repo.git",
+			"html_url": "https://github.com/owner/repo",
+			"default_branch": "master"
+		},
+		"sender": {"login": "jane"}
+	}`
+
+	mac := hmac.New(sha1.New, []byte("secret"))
+	mac.Write([]byte(body))
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("X-GitHub-Event", "push")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+
+	server := &GithubHookServer{Secrets: &SecretTokens{Current: "secret"}}
+	event, err := server.Parse(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if event.Provider != "github" || event.Type != "push" || event.Ref != "refs/heads/master" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.Repository.Owner != "owner" || event.Repository.FullName != "owner/repo" {
+		t.Errorf("unexpected repository %+v", event.Repository)
+	}
+	if event.Sender.Login != "jane" || len(event.Commits) != 1 || event.Commits[0].Author.Login != "jane" {
+		t.Errorf("unexpected sender %+v or commits %+v", event.Sender, event.Commits)
+	}
+	if files := event.ChangedFiles(); !reflect.DeepEqual(files, []string{"README.md", "docs/a.md"}) {
+		t.Errorf("unexpected changed files %v", files)
+	}
+}
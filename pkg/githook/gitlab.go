@@ -0,0 +1,349 @@
+package githook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/zhd173/githook/pkg/model"
+)
+
+// gitlabEventTypes maps the gitlab event headers to the GitHook eventTypes
+var gitlabEventTypes = map[string]string{
+	"Push Hook":               "push",
+	"Tag Push Hook":           "tag_push",
+	"Merge Request Hook":      "merge_request",
+	"Note Hook":               "note",
+	"Confidential Note Hook":  "note",
+	"Issue Hook":              "issues",
+	"Confidential Issue Hook": "issues",
+	"Pipeline Hook":           "pipeline",
+	"Job Hook":                "job",
+	"Build Hook":              "job",
+	"Wiki Page Hook":          "wiki_page",
+	"Release Hook":            "release",
+}
+
+// gitlabLabel is a label of a gitlab merge request
+type gitlabLabel struct {
+	Title string `json:"title"`
+}
+
+// gitlabUser is the user of a gitlab payload
+type gitlabUser struct {
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+// gitlabMergeRequest holds the merge request fields of merge request and note payloads
+type gitlabMergeRequest struct {
+	IID          int    `json:"iid"`
+	Title        string `json:"title"`
+	State        string `json:"state"`
+	URL          string `json:"url"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	LastCommit   struct {
+		ID string `json:"id"`
+	} `json:"last_commit"`
+	Source struct {
+		GitHTTPURL string `json:"git_http_url"`
+	} `json:"source"`
+}
+
+// gitlabPayload holds the fields shared by the gitlab payloads. go-gitlab
+// declares the project of every event as a distinct anonymous struct, so the
+// payloads are decoded here rather than through its event types.
+type gitlabPayload struct {
+	Before      string `json:"before"`
+	After       string `json:"after"`
+	Ref         string `json:"ref"`
+	CheckoutSHA string `json:"checkout_sha"`
+
+	// push and tag push events carry the user at the top level
+	UserName     string      `json:"user_name"`
+	UserUsername string      `json:"user_username"`
+	UserEmail    string      `json:"user_email"`
+	User         *gitlabUser `json:"user"`
+
+	Project struct {
+		Name              string `json:"name"`
+		PathWithNamespace string `json:"path_with_namespace"`
+		GitHTTPURL        string `json:"git_http_url"`
+		WebURL            string `json:"web_url"`
+		DefaultBranch     string `json:"default_branch"`
+	} `json:"project"`
+
+	// job events carry the repository instead of the project
+	Repository struct {
+		Name       string `json:"name"`
+		GitHTTPURL string `json:"git_http_url"`
+		Homepage   string `json:"homepage"`
+	} `json:"repository"`
+
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name  string `json:"name"`
+			Email string `json:"email"`
+		} `json:"author"`
+		Added    []string `json:"added"`
+		Modified []string `json:"modified"`
+		Removed  []string `json:"removed"`
+	} `json:"commits"`
+
+	ObjectAttributes struct {
+		gitlabMergeRequest
+		ID        int64  `json:"id"`
+		Action    string `json:"action"`
+		Note      string `json:"note"`
+		Ref       string `json:"ref"`
+		SHA       string `json:"sha"`
+		BeforeSHA string `json:"before_sha"`
+		Tag       bool   `json:"tag"`
+	} `json:"object_attributes"`
+
+	MergeRequest *gitlabMergeRequest `json:"merge_request"`
+	Issue        *struct {
+		IID   int    `json:"iid"`
+		Title string `json:"title"`
+		State string `json:"state"`
+		URL   string `json:"url"`
+	} `json:"issue"`
+
+	// merge request events carry the labels of the merge request and their changes
+	Labels  []gitlabLabel `json:"labels"`
+	Changes struct {
+		Labels *struct {
+			Previous []gitlabLabel `json:"previous"`
+			Current  []gitlabLabel `json:"current"`
+		} `json:"labels"`
+	} `json:"changes"`
+
+	// job events carry the sha at the top level
+	SHA       string `json:"sha"`
+	BeforeSHA string `json:"before_sha"`
+	// release events carry the tag name, job events whether the ref is a tag
+	Tag    json.RawMessage `json:"tag"`
+	Action string          `json:"action"`
+	Commit struct {
+		ID string `json:"id"`
+	} `json:"commit"`
+}
+
+// GitlabHookServer verifies and parses gitlab webhook deliveries
+type GitlabHookServer struct {
+	Secrets *SecretTokens
+}
+
+// GetEventHeader returns the header carrying the gitlab event type
+func (server *GitlabHookServer) GetEventHeader() string {
+	return "Gitlab-Event"
+}
+
+// GetDeliveryHeader returns the header carrying the gitlab delivery id
+func (server *GitlabHookServer) GetDeliveryHeader() string {
+	return "Gitlab-Event-UUID"
+}
+
+// Parse verifies the X-Gitlab-Token of the delivery and parses its payload
+// to a git event
+func (server *GitlabHookServer) Parse(r *http.Request) (*model.GitEvent, error) {
+	token := r.Header.Get("X-Gitlab-Token")
+	err := server.Secrets.Verify(func(secret string) bool {
+		return equalToken(token, secret)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gitlab payload: %s", err)
+	}
+
+	return server.ParsePayload(r.Header, body)
+}
+
+// ParsePayload parses the payload of a verified delivery to a git event
+func (server *GitlabHookServer) ParsePayload(header http.Header, body []byte) (*model.GitEvent, error) {
+	eventHeader := header.Get("X-" + server.GetEventHeader())
+	eventType, ok := gitlabEventTypes[eventHeader]
+	if !ok {
+		return nil, fmt.Errorf("unexpected gitlab event type: %s", eventHeader)
+	}
+
+	payload := &gitlabPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, fmt.Errorf("failed to parse gitlab payload: %s", err)
+	}
+
+	event := gitlabEvent(eventType, payload)
+	event.Payload = body
+
+	return event, nil
+}
+
+// gitlabEvent converts a parsed gitlab payload to a git event
+func gitlabEvent(eventType string, payload *gitlabPayload) *model.GitEvent {
+	event := &model.GitEvent{
+		Provider: "gitlab",
+		Type:     eventType,
+		Repository: model.GitRepository{
+			FullName:      payload.Project.PathWithNamespace,
+			Name:          payload.Project.Name,
+			Owner:         gitlabNamespace(payload.Project.PathWithNamespace),
+			CloneURL:      payload.Project.GitHTTPURL,
+			URL:           payload.Project.WebURL,
+			DefaultBranch: payload.Project.DefaultBranch,
+		},
+	}
+
+	if payload.User != nil {
+		event.Sender = model.GitUser{Login: payload.User.Username, Name: payload.User.Name, Email: payload.User.Email}
+	} else {
+		event.Sender = model.GitUser{Login: payload.UserUsername, Name: payload.UserName, Email: payload.UserEmail}
+	}
+
+	attributes := payload.ObjectAttributes
+
+	switch eventType {
+	case "push", "tag_push":
+		event.Ref = payload.Ref
+		event.Before = payload.Before
+		event.After = payload.CheckoutSHA
+		for _, commit := range payload.Commits {
+			event.Commits = append(event.Commits, model.GitCommit{
+				ID:       commit.ID,
+				Message:  commit.Message,
+				URL:      commit.URL,
+				Author:   model.GitUser{Name: commit.Author.Name, Email: commit.Author.Email},
+				Added:    commit.Added,
+				Modified: commit.Modified,
+				Removed:  commit.Removed,
+			})
+		}
+	case "merge_request":
+		event.Action = attributes.Action
+		event.Ref = model.BranchRef(attributes.SourceBranch)
+		event.After = attributes.LastCommit.ID
+		event.PullRequest = gitlabPullRequest(&attributes.gitlabMergeRequest)
+		for _, label := range payload.Labels {
+			event.PullRequest.Labels = append(event.PullRequest.Labels, label.Title)
+		}
+		if changes := payload.Changes.Labels; changes != nil {
+			event.Label = gitlabAddedLabel(changes.Previous, changes.Current)
+		}
+	case "note":
+		event.Action = "created"
+		event.Comment = &model.GitComment{ID: attributes.ID, Body: attributes.Note, URL: attributes.URL, Author: event.Sender}
+		if payload.MergeRequest != nil {
+			event.Ref = model.BranchRef(payload.MergeRequest.SourceBranch)
+			event.After = payload.MergeRequest.LastCommit.ID
+			event.PullRequest = gitlabPullRequest(payload.MergeRequest)
+			event.Issue = &model.GitIssue{
+				Number:      payload.MergeRequest.IID,
+				Title:       payload.MergeRequest.Title,
+				State:       payload.MergeRequest.State,
+				URL:         payload.MergeRequest.URL,
+				PullRequest: true,
+			}
+		}
+		if payload.Issue != nil {
+			event.Issue = &model.GitIssue{
+				Number: payload.Issue.IID,
+				Title:  payload.Issue.Title,
+				State:  payload.Issue.State,
+				URL:    payload.Issue.URL,
+			}
+		}
+	case "issues":
+		event.Action = attributes.Action
+		event.Issue = &model.GitIssue{
+			Number: attributes.IID,
+			Title:  attributes.Title,
+			State:  attributes.State,
+			URL:    attributes.URL,
+			Author: event.Sender,
+		}
+	case "pipeline":
+		event.Ref = gitlabRef(attributes.Ref, attributes.Tag)
+		event.Before = attributes.BeforeSHA
+		event.After = attributes.SHA
+	case "job":
+		event.Ref = gitlabRef(payload.Ref, gitlabIsTag(payload.Tag))
+		event.Before = payload.BeforeSHA
+		event.After = payload.SHA
+		if event.Repository.CloneURL == "" {
+			event.Repository.Name = payload.Repository.Name
+			event.Repository.CloneURL = payload.Repository.GitHTTPURL
+			event.Repository.URL = payload.Repository.Homepage
+		}
+	case "release":
+		var tag string
+		json.Unmarshal(payload.Tag, &tag)
+		event.Action = payload.Action
+		event.Ref = model.TagRef(tag)
+		event.After = payload.Commit.ID
+	case "wiki_page":
+		event.Action = attributes.Action
+	}
+
+	event.Tag = tagName(event.Ref)
+
+	return event
+}
+
+func gitlabPullRequest(mr *gitlabMergeRequest) *model.GitPullRequest {
+	return &model.GitPullRequest{
+		Number:       mr.IID,
+		Title:        mr.Title,
+		State:        mr.State,
+		URL:          mr.URL,
+		HeadRef:      mr.SourceBranch,
+		HeadSHA:      mr.LastCommit.ID,
+		HeadCloneURL: mr.Source.GitHTTPURL,
+		BaseRef:      mr.TargetBranch,
+	}
+}
+
+// gitlabAddedLabel returns the first label added by a merge request update
+func gitlabAddedLabel(previous, current []gitlabLabel) string {
+	titles := map[string]bool{}
+	for _, label := range previous {
+		titles[label.Title] = true
+	}
+	for _, label := range current {
+		if !titles[label.Title] {
+			return label.Title
+		}
+	}
+	return ""
+}
+
+// gitlabRef returns the full ref of the short ref of pipeline and job events
+func gitlabRef(ref string, tag bool) string {
+	if tag {
+		return model.TagRef(ref)
+	}
+	return model.BranchRef(ref)
+}
+
+func gitlabIsTag(raw json.RawMessage) bool {
+	var tag bool
+	json.Unmarshal(raw, &tag)
+	return tag
+}
+
+// gitlabNamespace returns the namespace of a project path
+func gitlabNamespace(path string) string {
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
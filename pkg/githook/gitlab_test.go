@@ -0,0 +1,102 @@
+package githook
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGitlabParseMergeRequest(t *testing.T) {
+	body := `{
+		"object_kind": "merge_request",
+		"user": {"name": "Jane", "username": "jane", "email": "jane@example.com"},
+		"project": {
+			"name": "project",
+			"path_with_namespace": "group/sub/project",
+			"git_http_url": "https://gitlab.example.com/group/sub/project.git",
+			"web_url": "https://gitlab.example.com/group/sub/project",
+			"default_branch": "master"
+		},
+		"object_attributes": {
+			"iid": 7,
+			"title": "Add feature",
+			"state": "opened",
+			"action": "open",
+			"url": "https://gitlab.example.com/group/sub/project/merge_requests/7",
+			"source_branch": "feature",
+			"target_branch": "master",
+			"last_commit": {"id": "da1560886d4f094c3e6c9ef40349f7d38b5d27d7"},
+			"source": {"git_http_url": "https://gitlab.example.com/fork/project.git"}
+		},
+		"labels": [{"title": "bug"}, {"title": "ok-to-test"}],
+		"changes": {
+			"labels": {
+				"previous": [{"title": "bug"}],
+				"current": [{"title": "bug"}, {"title": "ok-to-test"}]
+			}
+		}
+	}`
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("X-Gitlab-Event", "Merge Request Hook")
+	req.Header.Set("X-Gitlab-Token", "secret")
+
+	server := &GitlabHookServer{Secrets: &SecretTokens{Current: "secret"}}
+	event, err := server.Parse(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if event.Type != "merge_request" || event.Action != "open" {
+		t.Errorf("unexpected type %q and action %q", event.Type, event.Action)
+	}
+	if event.Repository.FullName != "group/sub/project" || event.Repository.Owner != "group/sub" {
+		t.Errorf("unexpected repository %+v", event.Repository)
+	}
+	if event.Ref != "refs/heads/feature" || event.After != "da1560886d4f094c3e6c9ef40349f7d38b5d27d7" {
+		t.Errorf("unexpected ref %q and commit %q", event.Ref, event.After)
+	}
+	if event.Sender.Login != "jane" {
+		t.Errorf("unexpected sender %+v", event.Sender)
+	}
+	if event.PullRequest == nil || event.PullRequest.Number != 7 || event.PullRequest.BaseRef != "master" {
+		t.Fatalf("unexpected merge request %+v", event.PullRequest)
+	}
+	if !event.PullRequest.HasLabel("ok-to-test") || event.Label != "ok-to-test" {
+		t.Errorf("expected the added ok-to-test label, got %v and %q", event.PullRequest.Labels, event.Label)
+	}
+	if event.CloneURL() != "https://gitlab.example.com/fork/project.git" {
+		t.Errorf("expected the source project to be cloned, got %q", event.CloneURL())
+	}
+	if string(event.Payload) != body {
+		t.Error("expected the raw payload to be kept")
+	}
+}
+
+func TestGitlabParseTagPush(t *testing.T) {
+	body := `{
+		"object_kind": "tag_push",
+		"ref": "refs/tags/v1.0.0",
+		"before": "0000000000000000000000000000000000000000",
+		"checkout_sha": "82b3d5ae55f7080f1e6022629cdb57bfae7cccc7",
+		"user_username": "jane",
+		"project": {"git_http_url": "https://gitlab.example.com/group/project.git"}
+	}`
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("X-Gitlab-Event", "Tag Push Hook")
+	req.Header.Set("X-Gitlab-Token", "secret")
+
+	server := &GitlabHookServer{Secrets: &SecretTokens{Current: "secret"}}
+	event, err := server.Parse(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if event.Type != "tag_push" || event.Tag != "v1.0.0" || event.Sender.Login != "jane" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	if event.Revision() != "82b3d5ae55f7080f1e6022629cdb57bfae7cccc7" {
+		t.Errorf("unexpected revision %q", event.Revision())
+	}
+}
@@ -0,0 +1,209 @@
+package githook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	gogs "github.com/gogits/go-gogs-client"
+	"github.com/zhd173/githook/pkg/model"
+)
+
+// GogsHookServer verifies and parses gogs webhook deliveries
+type GogsHookServer struct {
+	Secrets *SecretTokens
+}
+
+// GetEventHeader returns the header carrying the gogs event type
+func (server *GogsHookServer) GetEventHeader() string {
+	return "Gogs-Event"
+}
+
+// GetDeliveryHeader returns the header carrying the gogs delivery id
+func (server *GogsHookServer) GetDeliveryHeader() string {
+	return "Gogs-Delivery"
+}
+
+// Parse verifies the X-Gogs-Signature of the delivery and parses its payload
+// to a git event
+func (server *GogsHookServer) Parse(r *http.Request) (*model.GitEvent, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gogs payload: %s", err)
+	}
+
+	signature := r.Header.Get("X-Gogs-Signature")
+	err = server.Secrets.Verify(func(token string) bool {
+		mac := hmac.New(sha256.New, []byte(token))
+		mac.Write(body)
+		return equalToken(signature, hex.EncodeToString(mac.Sum(nil)))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return server.ParsePayload(r.Header, body)
+}
+
+// ParsePayload parses the payload of a verified delivery to a git event
+func (server *GogsHookServer) ParsePayload(header http.Header, body []byte) (*model.GitEvent, error) {
+	var payload interface{}
+	eventType := header.Get("X-" + server.GetEventHeader())
+	switch eventType {
+	case "push":
+		payload = &gogs.PushPayload{}
+	case "create":
+		payload = &gogs.CreatePayload{}
+	case "delete":
+		payload = &gogs.DeletePayload{}
+	case "fork":
+		payload = &gogs.ForkPayload{}
+	case "issues":
+		payload = &gogs.IssuesPayload{}
+	case "issue_comment":
+		payload = &gogs.IssueCommentPayload{}
+	case "pull_request":
+		payload = &gogs.PullRequestPayload{}
+	case "release":
+		payload = &gogs.ReleasePayload{}
+	default:
+		return nil, fmt.Errorf("unexpected gogs event type: %s", eventType)
+	}
+
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, fmt.Errorf("failed to parse gogs payload: %s", err)
+	}
+
+	event := gogsEvent(eventType, payload)
+	event.Payload = body
+
+	return event, nil
+}
+
+// gogsEvent converts a parsed gogs payload to a git event
+func gogsEvent(eventType string, payload interface{}) *model.GitEvent {
+	event := &model.GitEvent{Provider: "gogs", Type: eventType}
+
+	switch payload := payload.(type) {
+	case *gogs.PushPayload:
+		event.Repository = gogsRepository(payload.Repo)
+		event.Ref = payload.Ref
+		event.Before = payload.Before
+		event.After = payload.After
+		event.Sender = gogsUser(payload.Sender)
+		for _, commit := range payload.Commits {
+			gitCommit := model.GitCommit{
+				ID:       commit.ID,
+				Message:  commit.Message,
+				URL:      commit.URL,
+				Added:    commit.Added,
+				Modified: commit.Modified,
+				Removed:  commit.Removed,
+			}
+			if commit.Author != nil {
+				gitCommit.Author = model.GitUser{Login: commit.Author.UserName, Name: commit.Author.Name, Email: commit.Author.Email}
+			}
+			event.Commits = append(event.Commits, gitCommit)
+		}
+	case *gogs.PullRequestPayload:
+		event.Action = string(payload.Action)
+		event.Repository = gogsRepository(payload.Repository)
+		event.Sender = gogsUser(payload.Sender)
+		if pr := payload.PullRequest; pr != nil {
+			event.Ref = model.BranchRef(pr.HeadBranch)
+			event.PullRequest = &model.GitPullRequest{
+				Number:       int(pr.Index),
+				Title:        pr.Title,
+				State:        string(pr.State),
+				URL:          pr.HTMLURL,
+				HeadRef:      pr.HeadBranch,
+				HeadCloneURL: gogsRepository(pr.HeadRepo).CloneURL,
+				BaseRef:      pr.BaseBranch,
+				Author:       gogsUser(pr.Poster),
+				Merged:       pr.HasMerged,
+			}
+		}
+	case *gogs.CreatePayload:
+		event.Repository = gogsRepository(payload.Repo)
+		event.Ref = githubRef(payload.RefType, payload.Ref)
+		event.Sender = gogsUser(payload.Sender)
+	case *gogs.DeletePayload:
+		event.Repository = gogsRepository(payload.Repo)
+		event.Ref = githubRef(payload.RefType, payload.Ref)
+		event.Sender = gogsUser(payload.Sender)
+	case *gogs.ReleasePayload:
+		event.Action = string(payload.Action)
+		event.Repository = gogsRepository(payload.Repository)
+		event.Sender = gogsUser(payload.Sender)
+		if payload.Release != nil {
+			event.Ref = model.TagRef(payload.Release.TagName)
+		}
+	case *gogs.ForkPayload:
+		event.Repository = gogsRepository(payload.Repo)
+		event.Sender = gogsUser(payload.Sender)
+	case *gogs.IssuesPayload:
+		event.Action = string(payload.Action)
+		event.Repository = gogsRepository(payload.Repository)
+		event.Sender = gogsUser(payload.Sender)
+		event.Issue = gogsIssue(payload.Issue)
+	case *gogs.IssueCommentPayload:
+		event.Action = string(payload.Action)
+		event.Repository = gogsRepository(payload.Repository)
+		event.Sender = gogsUser(payload.Sender)
+		event.Issue = gogsIssue(payload.Issue)
+		if comment := payload.Comment; comment != nil {
+			event.Comment = &model.GitComment{
+				ID:     comment.ID,
+				Body:   comment.Body,
+				URL:    comment.HTMLURL,
+				Author: gogsUser(comment.Poster),
+			}
+		}
+	}
+
+	event.Tag = tagName(event.Ref)
+
+	return event
+}
+
+func gogsRepository(repo *gogs.Repository) model.GitRepository {
+	if repo == nil {
+		return model.GitRepository{}
+	}
+	return model.GitRepository{
+		FullName:      repo.FullName,
+		Name:          repo.Name,
+		Owner:         gogsUser(repo.Owner).Login,
+		CloneURL:      repo.CloneURL,
+		URL:           repo.HTMLURL,
+		DefaultBranch: repo.DefaultBranch,
+	}
+}
+
+func gogsIssue(issue *gogs.Issue) *model.GitIssue {
+	if issue == nil {
+		return nil
+	}
+	return &model.GitIssue{
+		Number:      int(issue.Index),
+		Title:       issue.Title,
+		State:       string(issue.State),
+		Author:      gogsUser(issue.Poster),
+		PullRequest: issue.PullRequest != nil,
+	}
+}
+
+func gogsUser(user *gogs.User) model.GitUser {
+	if user == nil {
+		return model.GitUser{}
+	}
+	login := user.Login
+	if login == "" {
+		login = user.UserName
+	}
+	return model.GitUser{Login: login, Name: user.FullName, Email: user.Email}
+}
@@ -0,0 +1,27 @@
+package githook
+
+import (
+	"time"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/zhd173/githook/pkg/model"
+	"github.com/zhd173/githook/pkg/tekton"
+)
+
+// EventManual is the type of the events of manual runs, which are not sent
+// by the git provider
+const EventManual = "manual"
+
+// RunManual creates the PipelineRun of a trigger for a manual event, the same
+// way the receiver does for webhook events, and returns it. In dry run the
+// PipelineRun is only rendered. The when expressions and event types of the
+// trigger are not checked.
+func RunManual(client PipelineRunClient, namespace, name string, config TriggerConfig, params []tekton.Param, event *model.GitEvent, dryRun bool) (*v1alpha1.PipelineRun, error) {
+	trigger, err := NewTrigger(config)
+	if err != nil {
+		return nil, err
+	}
+
+	ra := &ReceiveAdapter{TektonClient: client, Namespace: namespace, Name: name}
+	return ra.runTrigger(trigger, &compiledConfig{params: params, dryRun: dryRun}, event, time.Now())
+}
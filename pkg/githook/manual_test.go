@@ -0,0 +1,39 @@
+package githook
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zhd173/githook/pkg/model"
+	"github.com/zhd173/githook/pkg/tekton"
+)
+
+func TestRunManualCreatesPipelineRunOfTrigger(t *testing.T) {
+	tektonClient := &fakePipelineRunClient{}
+	event := &model.GitEvent{Type: EventManual, Ref: model.BranchRef("main"), After: "abc123"}
+
+	pipelineRun, err := RunManual(tektonClient, "default", "hook", TriggerConfig{
+		Name:       "build",
+		EventTypes: []string{"push"},
+		RunSpec:    []byte(`{}`),
+		Params:     []tekton.Param{{Name: "ref", JSONPath: "{.ref}"}},
+	}, []tekton.Param{{Name: "type", JSONPath: "{.type}"}}, event, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if pipelineRun.Name != "hook-build-1" || len(tektonClient.created) != 1 {
+		t.Fatalf("expected PipelineRun hook-build-1, got %s of %d", pipelineRun.Name, len(tektonClient.created))
+	}
+	params := map[string]string{}
+	for _, param := range tektonClient.created[0].Params {
+		params[param.Name] = param.Value
+	}
+	expected := map[string]string{"type": "manual", "ref": "refs/heads/main"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("expected params %v, got %v", expected, params)
+	}
+	if revision := tektonClient.revisions[0]; revision != "abc123" {
+		t.Errorf("expected revision abc123, got %s", revision)
+	}
+}
@@ -0,0 +1,91 @@
+package githook
+
+import (
+	"fmt"
+
+	"github.com/zhd173/githook/pkg/model"
+)
+
+// Policies of pull requests from untrusted authors
+const (
+	untrustedRun             = "Run"
+	untrustedRequireOkToTest = "RequireOkToTest"
+	untrustedNever           = "Never"
+)
+
+// okToTestLabel approves the pull requests of untrusted authors, it is also
+// the action of the events the approval runs the triggers with
+const okToTestLabel = "ok-to-test"
+
+// PullRequestConfig is the policy of pull requests from forks whose author
+// has no write permission on the repository
+type PullRequestConfig struct {
+	UntrustedPolicy string `json:"untrustedPolicy,omitempty"`
+}
+
+// requiresApproval reports whether untrusted pull requests wait for ok-to-test
+func (config *PullRequestConfig) requiresApproval() bool {
+	return config != nil && config.UntrustedPolicy == untrustedRequireOkToTest
+}
+
+// admitPullRequest reports whether the triggers may run for the pull request
+// of an event, or the reason they may not
+func (ra *ReceiveAdapter) admitPullRequest(config *compiledConfig, event *model.GitEvent) (bool, string, error) {
+	if event.PullRequest == nil || config.pullRequest == nil {
+		return true, "", nil
+	}
+	policy := config.pullRequest.UntrustedPolicy
+	if policy == "" || policy == untrustedRun {
+		return true, "", nil
+	}
+
+	if ra.ChatOps == nil {
+		return false, "", fmt.Errorf("no git provider client to check the author of pull request #%d", event.PullRequest.Number)
+	}
+
+	trusted, author, err := ra.trustedAuthor(event)
+	if err != nil || trusted {
+		return trusted, "", err
+	}
+
+	if policy == untrustedRequireOkToTest {
+		if event.PullRequest.HasLabel(okToTestLabel) {
+			return true, "", nil
+		}
+		return false, fmt.Sprintf("%s is not a collaborator, waiting for the %s label or a /%s comment", author, okToTestLabel, okToTestLabel), nil
+	}
+
+	return false, fmt.Sprintf("%s is not a collaborator", author), nil
+}
+
+// trustedAuthor reports whether the author of a pull request may run the
+// triggers, pull requests from the repository itself being trusted
+func (ra *ReceiveAdapter) trustedAuthor(event *model.GitEvent) (bool, string, error) {
+	pr := event.PullRequest
+	author := pr.Author.Login
+	if pr.HeadCloneURL != "" && pr.HeadCloneURL == event.Repository.CloneURL {
+		return true, author, nil
+	}
+
+	// gitlab 的 merge request 事件不包含作者的用户名
+	if author == "" {
+		fetched, err := ra.ChatOps.Client.GetPullRequest(ra.ChatOps.Options, pr.Number)
+		if err != nil {
+			return false, "", err
+		}
+		author = fetched.Author.Login
+	}
+
+	permission, err := ra.ChatOps.Client.GetPermission(ra.ChatOps.Options, author)
+	if err != nil {
+		return false, author, err
+	}
+
+	return model.PermissionAllows(permission, model.PermissionWrite), author, nil
+}
+
+// isApproval reports whether an event adds the ok-to-test label to a pull request
+func isApproval(config *compiledConfig, event *model.GitEvent) bool {
+	return config.pullRequest.requiresApproval() && event.PullRequest != nil &&
+		event.Label == okToTestLabel && event.Action != "unlabeled"
+}
@@ -0,0 +1,134 @@
+package githook
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/zhd173/githook/pkg/model"
+)
+
+func newPullRequestEvent(headCloneURL string, labels ...string) *model.GitEvent {
+	return &model.GitEvent{
+		Provider:   "github",
+		Type:       "pull_request",
+		Action:     "synchronize",
+		Repository: model.GitRepository{FullName: "owner/repo", CloneURL: "https://github.com/owner/repo.git"},
+		Sender:     model.GitUser{Login: "mallory"},
+		After:      "abc123",
+		PullRequest: &model.GitPullRequest{
+			Number:       7,
+			HeadSHA:      "abc123",
+			HeadCloneURL: headCloneURL,
+			Author:       model.GitUser{Login: "mallory"},
+			Labels:       labels,
+		},
+	}
+}
+
+func TestAdmitPullRequest(t *testing.T) {
+	ra := &ReceiveAdapter{ChatOps: &ChatOps{Client: &fakeChatOpsClient{
+		permissions: map[string]string{"alice": model.PermissionWrite},
+	}}}
+	fork := "https://github.com/mallory/repo.git"
+
+	collaborator := newPullRequestEvent(fork)
+	collaborator.PullRequest.Author.Login = "alice"
+
+	tests := []struct {
+		name     string
+		policy   string
+		event    *model.GitEvent
+		expected bool
+	}{
+		{"run", untrustedRun, newPullRequestEvent(fork), true},
+		{"same repository", untrustedNever, newPullRequestEvent("https://github.com/owner/repo.git"), true},
+		{"collaborator", untrustedNever, collaborator, true},
+		{"never", untrustedNever, newPullRequestEvent(fork, okToTestLabel), false},
+		{"waiting for ok-to-test", untrustedRequireOkToTest, newPullRequestEvent(fork), false},
+		{"ok-to-test", untrustedRequireOkToTest, newPullRequestEvent(fork, okToTestLabel), true},
+	}
+
+	for _, test := range tests {
+		config := &compiledConfig{pullRequest: &PullRequestConfig{UntrustedPolicy: test.policy}}
+		admitted, reason, err := ra.admitPullRequest(config, test.event)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.name, err)
+		}
+		if admitted != test.expected {
+			t.Errorf("%s: expected admitted %t, got %t: %s", test.name, test.expected, admitted, reason)
+		}
+	}
+}
+
+func TestUntrustedPullRequestRunsOnceApproved(t *testing.T) {
+	build, err := NewTrigger(TriggerConfig{Name: "build", EventTypes: []string{"pull_request"}, RunSpec: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tektonClient := &fakePipelineRunClient{}
+	ra := &ReceiveAdapter{
+		TektonClient:      tektonClient,
+		Provider:          "github",
+		Name:              "hook",
+		Triggers:          []*Trigger{build},
+		PullRequestConfig: &PullRequestConfig{UntrustedPolicy: untrustedRequireOkToTest},
+		ChatOps:           &ChatOps{Client: &fakeChatOpsClient{}, Options: &model.HookOptions{}},
+	}
+
+	handled, err := ra.handleEvent(newPullRequestEvent("https://github.com/mallory/repo.git"), http.Header{}, time.Now())
+	if err != nil || !handled {
+		t.Fatalf("expected the event to be handled, got %t: %v", handled, err)
+	}
+	if len(tektonClient.created) != 0 {
+		t.Fatalf("expected the untrusted pull request to be held, got %d PipelineRuns", len(tektonClient.created))
+	}
+
+	labeled := newPullRequestEvent("https://github.com/mallory/repo.git", okToTestLabel)
+	labeled.Action = "labeled"
+	labeled.Label = okToTestLabel
+	if _, err := ra.handleEvent(labeled, http.Header{}, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tektonClient.created) != 1 {
+		t.Fatalf("expected the approved pull request to run, got %d PipelineRuns", len(tektonClient.created))
+	}
+}
+
+func TestOkToTestCommentRequiresCollaborator(t *testing.T) {
+	build, err := NewTrigger(TriggerConfig{Name: "build", EventTypes: []string{"pull_request"}, RunSpec: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tektonClient := &fakePipelineRunClient{}
+	chatopsClient := &fakeChatOpsClient{
+		pullRequest: newPullRequestEvent("https://github.com/mallory/repo.git").PullRequest,
+		permissions: map[string]string{"alice": model.PermissionWrite},
+	}
+	ra := &ReceiveAdapter{
+		TektonClient:      tektonClient,
+		Provider:          "github",
+		Name:              "hook",
+		Triggers:          []*Trigger{build},
+		PullRequestConfig: &PullRequestConfig{UntrustedPolicy: untrustedRequireOkToTest},
+		ChatOps:           &ChatOps{Client: chatopsClient, Options: &model.HookOptions{}},
+	}
+
+	comment := newCommentEvent("/ok-to-test")
+	comment.Sender.Login = "mallory"
+	if _, err := ra.handleEvent(comment, http.Header{}, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tektonClient.created) != 0 {
+		t.Fatalf("expected the author not to approve the pull request, got %d PipelineRuns", len(tektonClient.created))
+	}
+
+	if _, err := ra.handleEvent(newCommentEvent("/ok-to-test"), http.Header{}, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tektonClient.created) != 1 {
+		t.Fatalf("expected the collaborator to approve the pull request, got %d PipelineRuns", len(tektonClient.created))
+	}
+}
@@ -0,0 +1,204 @@
+package githook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/zhd173/githook/pkg/metrics"
+	"github.com/zhd173/githook/pkg/model"
+	"github.com/zhd173/githook/pkg/tekton"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Defaults of the asynchronous processing of the receiver
+const (
+	DefaultQueueSize = 100
+	DefaultWorkers   = 4
+)
+
+// DefaultRetryBackoff retries the Tekton API errors for about fifteen
+// seconds, within the drain timeout of the receiver
+var DefaultRetryBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    4,
+}
+
+// Reasons the queue refuses a delivery
+var (
+	errQueueFull    = errors.New("the queue is full")
+	errShuttingDown = errors.New("the receiver is shutting down")
+)
+
+// queuedDelivery is a verified and parsed delivery waiting for a worker
+type queuedDelivery struct {
+	event    *model.GitEvent
+	header   http.Header
+	received time.Time
+	record   *DeliveryRecord
+}
+
+// EventQueue is the bounded queue of the deliveries handled asynchronously
+// by the workers of the receiver
+type EventQueue struct {
+	deliveries chan *queuedDelivery
+	workers    sync.WaitGroup
+	// aborted is closed when the drain times out, the workers stop retrying
+	aborted chan struct{}
+
+	mu        sync.RWMutex
+	closed    bool
+	abortOnce sync.Once
+}
+
+// NewEventQueue returns a queue holding up to size deliveries
+func NewEventQueue(size int) *EventQueue {
+	return &EventQueue{deliveries: make(chan *queuedDelivery, size), aborted: make(chan struct{})}
+}
+
+// add queues a delivery without waiting, it fails when the queue is full or
+// drained
+func (queue *EventQueue) add(delivery *queuedDelivery) error {
+	queue.mu.RLock()
+	defer queue.mu.RUnlock()
+
+	if queue.closed {
+		return errShuttingDown
+	}
+	select {
+	case queue.deliveries <- delivery:
+		metrics.QueuedDeliveries.Inc()
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Drain stops accepting deliveries and waits for the workers to handle the
+// queued ones, or for the context to be done. The retries of the workers
+// stop when the context is done.
+func (queue *EventQueue) Drain(ctx context.Context) error {
+	queue.mu.Lock()
+	if !queue.closed {
+		queue.closed = true
+		close(queue.deliveries)
+	}
+	queue.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		queue.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		queue.abortOnce.Do(func() { close(queue.aborted) })
+		return ctx.Err()
+	}
+}
+
+// StartWorkers starts the workers handling the deliveries of the queue of
+// the receiver
+func (ra *ReceiveAdapter) StartWorkers(workers int) {
+	for i := 0; i < workers; i++ {
+		ra.Queue.workers.Add(1)
+		go func() {
+			defer ra.Queue.workers.Done()
+			for delivery := range ra.Queue.deliveries {
+				metrics.QueuedDeliveries.Dec()
+				ra.process(delivery)
+			}
+		}()
+	}
+}
+
+// process handles a delivery and stores it. A delivery which was not handled
+// is forgotten by the duplicate check, so that the git provider can send it
+// again.
+func (ra *ReceiveAdapter) process(delivery *queuedDelivery) {
+	record := delivery.record
+	handled, err := ra.handleDelivery(delivery.event, delivery.header, delivery.received, record)
+	if err != nil {
+		record.Error = err.Error()
+		// 未处理的 delivery 记录为失败，可以重放
+		if !handled {
+			record.Decision = decisionFailed
+			ra.event(corev1.EventTypeWarning, reasonDeliveryFailed, "Failed to handle %s delivery %s: %s", delivery.event.Type, delivery.event.DeliveryID, err)
+		}
+	}
+	ra.saveDelivery(record)
+	if err != nil {
+		log.Printf("unexpected error handling git event: %s", err)
+	}
+
+	if id := delivery.event.DeliveryID; id != "" {
+		if handled {
+			ra.deliveries().add(id)
+		} else {
+			ra.deliveries().remove(id)
+		}
+	}
+}
+
+// createPipelineRun creates a PipelineRun, retrying the transient Tekton API
+// errors with the Backoff of the receiver. A PipelineRun created by an API
+// server answering too late is created again by the retry.
+func (ra *ReceiveAdapter) createPipelineRun(options tekton.PipelineOptions, event *model.GitEvent) (*v1alpha1.PipelineRun, error) {
+	backoff := ra.Backoff
+	for {
+		pipelineRun, err := ra.TektonClient.CreatePipelineRun(options, event)
+		if err == nil || backoff.Steps < 1 || !transient(err) {
+			return pipelineRun, err
+		}
+
+		delay := backoff.Step()
+		log.Printf("failed to create pipeline run, retrying in %s: %s", delay, err)
+		if !ra.sleep(delay) {
+			return pipelineRun, fmt.Errorf("stopped retrying on shutdown: %w", err)
+		}
+	}
+}
+
+// sleep waits for delay, it returns false when the drain of the queue timed
+// out first
+func (ra *ReceiveAdapter) sleep(delay time.Duration) bool {
+	var aborted chan struct{}
+	if ra.Queue != nil {
+		aborted = ra.Queue.aborted
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-aborted:
+		return false
+	}
+}
+
+// transient reports whether a Tekton API error may succeed when retried:
+// rate limited calls, server errors and failures to reach the API server
+func transient(err error) bool {
+	var status apierrs.APIStatus
+	if errors.As(err, &status) {
+		code := status.Status().Code
+		return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
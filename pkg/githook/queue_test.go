@@ -0,0 +1,175 @@
+package githook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tektonv1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/zhd173/githook/pkg/model"
+	"github.com/zhd173/githook/pkg/tekton"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// flakyPipelineRunClient fails the first creations with err
+type flakyPipelineRunClient struct {
+	fakePipelineRunClient
+	failures int
+	err      error
+	calls    int
+}
+
+func (client *flakyPipelineRunClient) CreatePipelineRun(options tekton.PipelineOptions, event *model.GitEvent) (*tektonv1alpha1.PipelineRun, error) {
+	client.calls++
+	if client.calls <= client.failures {
+		return nil, fmt.Errorf("error creating pipeline run: %w", client.err)
+	}
+	return client.fakePipelineRunClient.CreatePipelineRun(options, event)
+}
+
+func newQueueAdapter(tektonClient PipelineRunClient, queue *EventQueue) *ReceiveAdapter {
+	trigger, _ := NewTrigger(TriggerConfig{Name: "build", EventTypes: []string{"push"}, RunSpec: []byte(`{}`)})
+	return &ReceiveAdapter{
+		TektonClient: tektonClient,
+		HookServer:   &GogsHookServer{Secrets: &SecretTokens{Current: "secret"}},
+		Provider:     "gogs",
+		Namespace:    "default",
+		Name:         "hook",
+		Triggers:     []*Trigger{trigger},
+		Queue:        queue,
+	}
+}
+
+func TestHandleRequestQueuesDelivery(t *testing.T) {
+	tektonClient := &fakePipelineRunClient{}
+	ra := newQueueAdapter(tektonClient, NewEventQueue(1))
+
+	body := `{"ref": "refs/heads/main", "after": "abc123", "repository": {"clone_url": "http://gogs.example.com/owner/repo.git"}}`
+	w := httptest.NewRecorder()
+	ra.HandleRequest(w, newGogsPush(body))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d", http.StatusAccepted, w.Code)
+	}
+
+	second := newGogsPush(body)
+	second.Header.Set("X-Gogs-Delivery", "delivery-2")
+	w = httptest.NewRecorder()
+	ra.HandleRequest(w, second)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d when the queue is full, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	if ra.deliveries().contains("delivery-2") {
+		t.Error("expected the dropped delivery to be sent again by the git provider")
+	}
+	if len(tektonClient.created) != 0 {
+		t.Fatalf("expected no PipelineRun before the workers start, got %d", len(tektonClient.created))
+	}
+
+	ra.StartWorkers(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ra.Queue.Drain(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(tektonClient.created) != 1 || tektonClient.revisions[0] != "abc123" {
+		t.Errorf("expected the queued delivery to create a PipelineRun, got %v", tektonClient.revisions)
+	}
+	if !ra.deliveries().contains("delivery-1") {
+		t.Error("expected the handled delivery to be remembered")
+	}
+
+	third := newGogsPush(body)
+	third.Header.Set("X-Gogs-Delivery", "delivery-3")
+	w = httptest.NewRecorder()
+	ra.HandleRequest(w, third)
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "shutting down") {
+		t.Errorf("expected status %d shutting down once drained, got %d %s", http.StatusServiceUnavailable, w.Code, w.Body.String())
+	}
+}
+
+func TestProcessRetriesTransientErrors(t *testing.T) {
+	unavailable := apierrs.NewServiceUnavailable("etcd is down")
+	invalid := apierrs.NewBadRequest("invalid pipeline run")
+	tests := []struct {
+		err      error
+		failures int
+		calls    int
+		handled  bool
+	}{
+		{unavailable, 2, 3, true},
+		{apierrs.NewTooManyRequests("slow down", 1), 1, 2, true},
+		{unavailable, 5, 4, false},
+		{invalid, 1, 1, false},
+		{apierrs.NewNotFound(schema.GroupResource{Resource: "pipelineresources"}, "git"), 1, 1, false},
+	}
+
+	for _, test := range tests {
+		tektonClient := &flakyPipelineRunClient{failures: test.failures, err: test.err}
+		ra := newQueueAdapter(tektonClient, nil)
+		ra.Backoff = wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}
+
+		event := &model.GitEvent{Type: "push", Ref: model.BranchRef("main"), After: "abc123", DeliveryID: "delivery-1",
+			Repository: model.GitRepository{CloneURL: "http://gogs.example.com/owner/repo.git"}}
+		record := &DeliveryRecord{ID: "delivery-1"}
+		ra.deliveries().add("delivery-1")
+		ra.process(&queuedDelivery{event: event, received: time.Now(), record: record})
+
+		if tektonClient.calls != test.calls {
+			t.Errorf("%s: expected %d calls, got %d", test.err, test.calls, tektonClient.calls)
+		}
+		if handled := record.Error == ""; handled != test.handled {
+			t.Errorf("%s: expected handled %t, got error %q", test.err, test.handled, record.Error)
+		}
+		if ra.deliveries().contains("delivery-1") != test.handled {
+			t.Errorf("%s: expected the delivery to be remembered only when handled", test.err)
+		}
+	}
+}
+
+func TestDrainStopsRetriesOnTimeout(t *testing.T) {
+	tektonClient := &flakyPipelineRunClient{failures: 10, err: apierrs.NewServiceUnavailable("etcd is down")}
+	store := &ConfigMapDeliveryStore{Client: fake.NewSimpleClientset().CoreV1(), Namespace: "default", Name: "hook"}
+	ra := newQueueAdapter(tektonClient, NewEventQueue(1))
+	ra.Backoff = wait.Backoff{Duration: time.Hour, Factor: 1, Steps: 3}
+	ra.Deliveries = store
+
+	body := `{"ref": "refs/heads/main", "after": "abc123", "repository": {"clone_url": "http://gogs.example.com/owner/repo.git"}}`
+	ra.HandleRequest(httptest.NewRecorder(), newGogsPush(body))
+	ra.StartWorkers(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := ra.Queue.Drain(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the drain to time out, got %v", err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		ra.Queue.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the worker to stop retrying once the drain timed out")
+	}
+
+	record, err := store.Load("delivery-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if record.Decision != "failed" || !strings.Contains(record.Error, "shutdown") {
+		t.Errorf("expected the delivery to be stored as failed, got %+v", record)
+	}
+	if ra.deliveries().contains("delivery-1") {
+		t.Error("expected the failed delivery to be sent again by the git provider")
+	}
+}
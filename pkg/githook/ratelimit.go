@@ -0,0 +1,392 @@
+package githook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhd173/githook/pkg/metrics"
+	"github.com/zhd173/githook/pkg/model"
+	"github.com/zhd173/githook/pkg/tekton"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// Keys of the rate limit state in its ConfigMap
+const (
+	rateLimitDebounceKey = "debounce.json"
+	rateLimitWindowKey   = "window"
+	rateLimitCountKey    = "count"
+)
+
+// debounceExpiry bounds how long the debounced deliveries of a receiver
+// stopped before the end of their window are kept in the shared state
+const debounceExpiry = 10 * time.Minute
+
+// rateLimitBackoff retries the conflicts of the replicas updating the state
+// for a few seconds, the bursts of events of a repository conflict more than
+// retry.DefaultRetry allows for
+var rateLimitBackoff = wait.Backoff{
+	Duration: 10 * time.Millisecond,
+	Factor:   1.5,
+	Jitter:   0.5,
+	Steps:    12,
+}
+
+// RateLimitConfig limits the events running the triggers of a GitHook. The
+// slash commands and the replays are not limited.
+type RateLimitConfig struct {
+	// DebounceSeconds is the window during which the events of a ref are
+	// coalesced, only the latest event runs once no event came in the window
+	DebounceSeconds int `json:"debounceSeconds,omitempty"`
+	// MaxPerMinute bounds the events running the triggers each minute, the
+	// events over the limit are dropped
+	MaxPerMinute int `json:"maxPerMinute,omitempty"`
+}
+
+// RateLimitState is the state of the rate limits, shared by the replicas of
+// the receiver
+type RateLimitState interface {
+	// Debounce records a delivery as the latest event of a key
+	Debounce(key, delivery string, received time.Time) error
+	// Settle returns the latest delivery of a key, and forgets the key when
+	// it is the delivery settled
+	Settle(key, delivery string) (string, error)
+	// Take takes one of the max events of the minute of now, it reports
+	// whether one was left
+	Take(now time.Time, max int) (bool, error)
+}
+
+// ConfigMapRateLimitState keeps the rate limit state of a GitHook in a
+// ConfigMap, updated with optimistic concurrency
+type ConfigMapRateLimitState struct {
+	Client    typedcorev1.ConfigMapsGetter
+	Namespace string
+	// Name is the name of the GitHook
+	Name string
+	// Owner is the GitHook owning the ConfigMap, nil leaves it unowned
+	Owner *metav1.OwnerReference
+}
+
+// debounced is the latest delivery of a debounce key
+type debounced struct {
+	Delivery string    `json:"delivery"`
+	Received time.Time `json:"received"`
+}
+
+// RateLimitConfigMapName returns the name of the ConfigMap of the rate limit
+// state of a GitHook
+func RateLimitConfigMapName(name string) string {
+	return name + "-ratelimit"
+}
+
+// Debounce records a delivery as the latest event of a key
+func (state *ConfigMapRateLimitState) Debounce(key, delivery string, received time.Time) error {
+	return state.update(func(configMap *corev1.ConfigMap) (bool, error) {
+		entries, err := debounceEntries(configMap)
+		if err != nil {
+			return false, err
+		}
+		for entryKey, entry := range entries {
+			if time.Since(entry.Received) > debounceExpiry {
+				delete(entries, entryKey)
+			}
+		}
+		// 多个副本同时收到同一 ref 的事件时以接收时间较晚的为准
+		if latest, ok := entries[key]; ok && latest.Received.After(received) {
+			return false, nil
+		}
+		entries[key] = debounced{Delivery: delivery, Received: received}
+		return true, setDebounceEntries(configMap, entries)
+	})
+}
+
+// Settle returns the latest delivery of a key, and forgets the key when it
+// is the delivery settled
+func (state *ConfigMapRateLimitState) Settle(key, delivery string) (string, error) {
+	latest := delivery
+	err := state.update(func(configMap *corev1.ConfigMap) (bool, error) {
+		entries, err := debounceEntries(configMap)
+		if err != nil {
+			return false, err
+		}
+		entry, ok := entries[key]
+		if !ok {
+			latest = delivery
+			return false, nil
+		}
+		latest = entry.Delivery
+		if entry.Delivery != delivery {
+			return false, nil
+		}
+		delete(entries, key)
+		return true, setDebounceEntries(configMap, entries)
+	})
+	return latest, err
+}
+
+// Take takes one of the max events of the minute of now, it reports whether
+// one was left
+func (state *ConfigMapRateLimitState) Take(now time.Time, max int) (bool, error) {
+	window := now.UTC().Truncate(time.Minute).Format(time.RFC3339)
+	taken := false
+	err := state.update(func(configMap *corev1.ConfigMap) (bool, error) {
+		count := 0
+		if configMap.Data[rateLimitWindowKey] == window {
+			count, _ = strconv.Atoi(configMap.Data[rateLimitCountKey])
+		}
+		taken = count < max
+		if !taken {
+			return false, nil
+		}
+		configMap.Data[rateLimitWindowKey] = window
+		configMap.Data[rateLimitCountKey] = strconv.Itoa(count + 1)
+		return true, nil
+	})
+	return taken, err
+}
+
+// update applies a change to the ConfigMap of the state, creating it when
+// missing, and retries on conflicts with the other replicas. The change
+// reports whether the ConfigMap was modified.
+func (state *ConfigMapRateLimitState) update(change func(configMap *corev1.ConfigMap) (bool, error)) error {
+	configMaps := state.Client.ConfigMaps(state.Namespace)
+	name := RateLimitConfigMapName(state.Name)
+
+	err := retry.RetryOnConflict(rateLimitBackoff, func() error {
+		configMap, err := configMaps.Get(name, metav1.GetOptions{})
+		if apierrs.IsNotFound(err) {
+			configMap = state.configMap(name)
+			modified, err := change(configMap)
+			if err != nil || !modified {
+				return err
+			}
+			_, err = configMaps.Create(configMap)
+			if apierrs.IsAlreadyExists(err) {
+				// 其他副本先创建了 ConfigMap，按冲突重试
+				return apierrs.NewConflict(corev1.Resource("configmaps"), name, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		modified, err := change(configMap)
+		if err != nil || !modified {
+			return err
+		}
+		_, err = configMaps.Update(configMap)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update rate limit state: %s", err)
+	}
+	return nil
+}
+
+func (state *ConfigMapRateLimitState) configMap(name string) *corev1.ConfigMap {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: state.Namespace,
+			Labels:    map[string]string{tekton.LabelGitHook: state.Name},
+		},
+		Data: map[string]string{},
+	}
+	if state.Owner != nil {
+		configMap.OwnerReferences = []metav1.OwnerReference{*state.Owner}
+	}
+	return configMap
+}
+
+func debounceEntries(configMap *corev1.ConfigMap) (map[string]debounced, error) {
+	entries := map[string]debounced{}
+	if data, ok := configMap.Data[rateLimitDebounceKey]; ok {
+		if err := json.Unmarshal([]byte(data), &entries); err != nil {
+			return nil, fmt.Errorf("invalid rate limit state %s: %s", configMap.Name, err)
+		}
+	}
+	return entries, nil
+}
+
+func setDebounceEntries(configMap *corev1.ConfigMap, entries map[string]debounced) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	configMap.Data[rateLimitDebounceKey] = string(data)
+	return nil
+}
+
+// debounceKey returns the key the events are debounced by, the event type
+// and its ref, empty for the events without ref
+func debounceKey(event *model.GitEvent) string {
+	if event.Ref == "" {
+		return ""
+	}
+	return event.Type + " " + event.Ref
+}
+
+// debounceWaits tracks the debounced deliveries waiting for the end of their
+// window, so that the receiver settles them before exiting
+type debounceWaits struct {
+	mu      sync.Mutex
+	waiting sync.WaitGroup
+	stop    chan struct{}
+	stopped bool
+}
+
+// start runs settle in the background at the end of delay, or as soon as the
+// waits are drained. It reports false once drained, the caller settles then.
+func (waits *debounceWaits) start(delay time.Duration, settle func()) bool {
+	waits.mu.Lock()
+	defer waits.mu.Unlock()
+
+	if waits.stopped {
+		return false
+	}
+	if waits.stop == nil {
+		waits.stop = make(chan struct{})
+	}
+
+	stop := waits.stop
+	waits.waiting.Add(1)
+	go func() {
+		defer waits.waiting.Done()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-stop:
+		}
+		settle()
+	}()
+	return true
+}
+
+// drain ends the waits at once and waits for the deliveries to settle, or
+// for the context to be done
+func (waits *debounceWaits) drain(ctx context.Context) error {
+	waits.mu.Lock()
+	if !waits.stopped {
+		waits.stopped = true
+		if waits.stop != nil {
+			close(waits.stop)
+		}
+	}
+	waits.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		waits.waiting.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DrainDebounced settles the debounced deliveries without waiting for the
+// end of their window, and waits for them or for the context to be done. The
+// deliveries debounced afterwards are settled at once.
+func (ra *ReceiveAdapter) DrainDebounced(ctx context.Context) error {
+	return ra.debounced.drain(ctx)
+}
+
+// limitDelivery applies the rate limits to an event about to run the
+// triggers. A debounced event runs later, in the background, when it is
+// still the latest event of its ref at the end of the window.
+func (ra *ReceiveAdapter) limitDelivery(config *compiledConfig, event *model.GitEvent, header http.Header, received time.Time, record *DeliveryRecord) (bool, error) {
+	window := time.Duration(config.rateLimit.DebounceSeconds) * time.Second
+	key := debounceKey(event)
+	if window <= 0 || key == "" {
+		return ra.runLimited(config, event, header, received, record)
+	}
+
+	delivery := event.DeliveryID
+	if delivery == "" {
+		delivery = uuid.New().String()
+	}
+	if err := ra.RateLimits.Debounce(key, delivery, received); err != nil {
+		ra.event(corev1.EventTypeWarning, reasonRateLimitFailed, "Failed to debounce %s delivery %s: %s", event.Type, delivery, err)
+		return false, err
+	}
+
+	log.Printf("Debouncing %s delivery %s on %s for %s", event.Type, delivery, event.Ref, window)
+	metrics.RateLimitedEvents.WithLabelValues(metrics.RateLimitDebounced).Inc()
+	record.Decision = metrics.RateLimitDebounced
+
+	// 后台运行的 delivery 使用记录的副本，避免与请求处理同时修改
+	pending := *record
+	started := ra.debounced.start(time.Until(received.Add(window)), func() {
+		ra.settleDebounced(config, key, delivery, event, header, received, &pending)
+		ra.saveDelivery(&pending)
+	})
+	if !started {
+		// receiver 退出时不再等待窗口结束
+		ra.settleDebounced(config, key, delivery, event, header, received, record)
+	}
+	return true, nil
+}
+
+// settleDebounced runs a debounced event when it is still the latest event
+// of its ref, it is coalesced into the latest event otherwise
+func (ra *ReceiveAdapter) settleDebounced(config *compiledConfig, key, delivery string, event *model.GitEvent, header http.Header, received time.Time, record *DeliveryRecord) {
+	latest, err := ra.RateLimits.Settle(key, delivery)
+	switch {
+	case err != nil:
+		log.Printf("failed to settle debounced delivery %s: %s", delivery, err)
+		ra.event(corev1.EventTypeWarning, reasonRateLimitFailed, "Failed to run debounced %s delivery %s: %s", event.Type, delivery, err)
+		record.Error = err.Error()
+	case latest != delivery:
+		log.Printf("Coalescing %s delivery %s into %s", event.Type, delivery, latest)
+		metrics.RateLimitedEvents.WithLabelValues(metrics.RateLimitCoalesced).Inc()
+		ra.event(corev1.EventTypeNormal, reasonEventCoalesced, "Coalesced %s delivery %s on %s into delivery %s", event.Type, delivery, event.Ref, latest)
+		record.Decision = metrics.RateLimitCoalesced
+		record.CoalescedInto = latest
+	default:
+		record.Decision = metrics.DecisionAccepted
+		if _, err := ra.runLimited(config, event, header, received, record); err != nil {
+			log.Printf("unexpected error handling debounced git event: %s", err)
+			record.Error = err.Error()
+		}
+	}
+}
+
+// runLimited runs the triggers for an event within the events per minute
+func (ra *ReceiveAdapter) runLimited(config *compiledConfig, event *model.GitEvent, header http.Header, received time.Time, record *DeliveryRecord) (bool, error) {
+	if max := config.rateLimit.MaxPerMinute; max > 0 {
+		taken, err := ra.RateLimits.Take(time.Now(), max)
+		if err != nil {
+			ra.event(corev1.EventTypeWarning, reasonRateLimitFailed, "Failed to rate limit %s delivery %s: %s", event.Type, event.DeliveryID, err)
+			return false, err
+		}
+		if !taken {
+			log.Printf("Dropping %s delivery %s over the rate limit", event.Type, event.DeliveryID)
+			metrics.RateLimitedEvents.WithLabelValues(metrics.RateLimitDropped).Inc()
+			ra.event(corev1.EventTypeWarning, reasonEventRateLimited, "Dropped %s delivery %s: more than %d events per minute", event.Type, event.DeliveryID, max)
+			record.Decision = metrics.RateLimitDropped
+			return true, nil
+		}
+	}
+	return ra.runTriggers(config, event, header, received, record)
+}
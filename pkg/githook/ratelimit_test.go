@@ -0,0 +1,193 @@
+package githook
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zhd173/githook/pkg/model"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newRateLimitState() *ConfigMapRateLimitState {
+	return &ConfigMapRateLimitState{Client: fake.NewSimpleClientset().CoreV1(), Namespace: "default", Name: "hook"}
+}
+
+func TestRateLimitStateSettlesLatestDelivery(t *testing.T) {
+	state := newRateLimitState()
+	now := time.Now()
+
+	if err := state.Debounce("push refs/heads/main", "delivery-1", now); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := state.Debounce("push refs/heads/main", "delivery-2", now.Add(time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// 接收时间较早的 delivery 不覆盖较晚的
+	if err := state.Debounce("push refs/heads/main", "delivery-0", now.Add(-time.Second)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if latest, err := state.Settle("push refs/heads/main", "delivery-1"); err != nil || latest != "delivery-2" {
+		t.Errorf("expected delivery-1 to be coalesced into delivery-2, got %s: %v", latest, err)
+	}
+	if latest, err := state.Settle("push refs/heads/main", "delivery-2"); err != nil || latest != "delivery-2" {
+		t.Errorf("expected delivery-2 to run, got %s: %v", latest, err)
+	}
+	if latest, err := state.Settle("push refs/heads/main", "delivery-3"); err != nil || latest != "delivery-3" {
+		t.Errorf("expected the settled ref to be forgotten, got %s: %v", latest, err)
+	}
+}
+
+func TestRateLimitStateTakesEventsPerMinute(t *testing.T) {
+	state := newRateLimitState()
+	minute := time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	for i, expected := range []bool{true, true, false} {
+		taken, err := state.Take(minute.Add(time.Duration(i)*time.Second), 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if taken != expected {
+			t.Errorf("event %d: expected taken %t, got %t", i, expected, taken)
+		}
+	}
+
+	if taken, err := state.Take(minute.Add(time.Minute), 2); err != nil || !taken {
+		t.Errorf("expected an event to be taken in the next minute, got %t: %v", taken, err)
+	}
+}
+
+func TestHandleRequestDropsEventsOverRateLimit(t *testing.T) {
+	trigger, err := NewTrigger(TriggerConfig{Name: "build", EventTypes: []string{"push"}, RunSpec: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tektonClient := &fakePipelineRunClient{}
+	store := &ConfigMapDeliveryStore{Client: fake.NewSimpleClientset().CoreV1(), Namespace: "default", Name: "hook"}
+	ra := &ReceiveAdapter{
+		TektonClient: tektonClient,
+		HookServer:   &GogsHookServer{Secrets: &SecretTokens{Current: "secret"}},
+		Provider:     "gogs",
+		Namespace:    "default",
+		Name:         "hook",
+		Triggers:     []*Trigger{trigger},
+		Deliveries:   store,
+		RateLimit:    &RateLimitConfig{MaxPerMinute: 1},
+		RateLimits:   newRateLimitState(),
+	}
+
+	body := `{"ref": "refs/heads/main", "after": "abc123", "repository": {"clone_url": "http://gogs.example.com/owner/repo.git"}}`
+	ra.HandleRequest(httptest.NewRecorder(), newGogsPush(body))
+	second := newGogsPush(body)
+	second.Header.Set("X-Gogs-Delivery", "delivery-2")
+	ra.HandleRequest(httptest.NewRecorder(), second)
+
+	if len(tektonClient.created) != 1 {
+		t.Fatalf("expected one PipelineRun within the rate limit, got %d", len(tektonClient.created))
+	}
+	record, err := store.Load("delivery-2")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if record.Decision != "rate_limited" || len(record.Runs) != 0 {
+		t.Errorf("expected the second delivery to be dropped, got %+v", record)
+	}
+}
+
+func TestSettleDebouncedRunsOnlyLatestDelivery(t *testing.T) {
+	trigger, err := NewTrigger(TriggerConfig{Name: "build", EventTypes: []string{"push"}, RunSpec: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tektonClient := &fakePipelineRunClient{}
+	state := newRateLimitState()
+	ra := &ReceiveAdapter{
+		TektonClient: tektonClient,
+		Namespace:    "default",
+		Name:         "hook",
+		Triggers:     []*Trigger{trigger},
+		RateLimits:   state,
+	}
+	config := &compiledConfig{triggers: ra.Triggers, rateLimit: &RateLimitConfig{DebounceSeconds: 30}}
+
+	now := time.Now()
+	events := map[string]*model.GitEvent{}
+	records := map[string]*DeliveryRecord{}
+	for i, id := range []string{"delivery-1", "delivery-2"} {
+		events[id] = &model.GitEvent{Type: "push", Ref: model.BranchRef("main"), After: id, DeliveryID: id,
+			Repository: model.GitRepository{CloneURL: "http://gogs.example.com/owner/repo.git"}}
+		records[id] = &DeliveryRecord{ID: id, Event: "push"}
+		if err := state.Debounce(debounceKey(events[id]), id, now.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	for _, id := range []string{"delivery-1", "delivery-2"} {
+		ra.settleDebounced(config, debounceKey(events[id]), id, events[id], nil, now, records[id])
+	}
+
+	if len(tektonClient.created) != 1 || tektonClient.revisions[0] != "delivery-2" {
+		t.Fatalf("expected only the latest delivery to run, got %v", tektonClient.revisions)
+	}
+	if record := records["delivery-1"]; record.Decision != "coalesced" || record.CoalescedInto != "delivery-2" {
+		t.Errorf("expected delivery-1 to be coalesced into delivery-2, got %+v", record)
+	}
+	if record := records["delivery-2"]; record.Decision != "accepted" || len(record.Runs) != 1 {
+		t.Errorf("expected delivery-2 to run, got %+v", record)
+	}
+}
+
+func TestDrainDebouncedSettlesWithoutWaitingForWindow(t *testing.T) {
+	trigger, err := NewTrigger(TriggerConfig{Name: "build", EventTypes: []string{"push"}, RunSpec: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tektonClient := &fakePipelineRunClient{}
+	store := &ConfigMapDeliveryStore{Client: fake.NewSimpleClientset().CoreV1(), Namespace: "default", Name: "hook"}
+	ra := &ReceiveAdapter{
+		TektonClient: tektonClient,
+		HookServer:   &GogsHookServer{Secrets: &SecretTokens{Current: "secret"}},
+		Provider:     "gogs",
+		Namespace:    "default",
+		Name:         "hook",
+		Triggers:     []*Trigger{trigger},
+		Deliveries:   store,
+		RateLimit:    &RateLimitConfig{DebounceSeconds: 3600},
+		RateLimits:   newRateLimitState(),
+	}
+
+	body := `{"ref": "refs/heads/main", "after": "abc123", "repository": {"clone_url": "http://gogs.example.com/owner/repo.git"}}`
+	ra.HandleRequest(httptest.NewRecorder(), newGogsPush(body))
+	if len(tektonClient.created) != 0 {
+		t.Fatalf("expected the delivery to wait for the end of the window, got %d PipelineRuns", len(tektonClient.created))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ra.DrainDebounced(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tektonClient.created) != 1 {
+		t.Fatalf("expected the debounced delivery to run on drain, got %d PipelineRuns", len(tektonClient.created))
+	}
+
+	// 之后防抖的 delivery 立即处理
+	second := newGogsPush(body)
+	second.Header.Set("X-Gogs-Delivery", "delivery-2")
+	ra.HandleRequest(httptest.NewRecorder(), second)
+	if len(tektonClient.created) != 2 {
+		t.Fatalf("expected the delivery debounced after the drain to run at once, got %d PipelineRuns", len(tektonClient.created))
+	}
+	record, err := store.Load("delivery-2")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if record.Decision != "accepted" || len(record.Runs) != 1 {
+		t.Errorf("expected delivery-2 to run, got %+v", record)
+	}
+}
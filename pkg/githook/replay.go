@@ -0,0 +1,171 @@
+package githook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// ReplayPath is the path of the receiver replaying the stored deliveries
+const ReplayPath = "/replay"
+
+// Headers of the replay requests, signed with the secret token of the GitHook
+const (
+	replayDeliveryHeader  = "X-Githook-Replay-Delivery"
+	replayNonceHeader     = "X-Githook-Replay-Nonce"
+	replayTimestampHeader = "X-Githook-Replay-Timestamp"
+	replaySignatureHeader = "X-Githook-Replay-Signature"
+)
+
+// replayMaxAge bounds the age of the replay requests, so that a replay
+// request seen on the wire cannot be sent again later. Within that age the
+// nonce of a replay request is remembered with the delivery and reuse of the
+// request is rejected.
+const replayMaxAge = 5 * time.Minute
+
+// NewReplayRequest returns the request replaying a stored delivery on the
+// receiver at receiverURL
+func NewReplayRequest(receiverURL, secretToken, id string, now time.Time) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(receiverURL, "/")+ReplayPath, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := uuid.New().String()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(replayDeliveryHeader, id)
+	req.Header.Set(replayNonceHeader, nonce)
+	req.Header.Set(replayTimestampHeader, timestamp)
+	req.Header.Set(replaySignatureHeader, replaySignature(secretToken, id, nonce, timestamp))
+	return req, nil
+}
+
+func replaySignature(token, id, nonce, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(id + "\n" + nonce + "\n" + timestamp))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyReplay checks the signature and the age of a replay request
+func verifyReplay(secrets *SecretTokens, header http.Header, now time.Time) error {
+	id := header.Get(replayDeliveryHeader)
+	nonce := header.Get(replayNonceHeader)
+	timestamp := header.Get(replayTimestampHeader)
+	if id == "" {
+		return fmt.Errorf("missing %s header", replayDeliveryHeader)
+	}
+	if nonce == "" {
+		return fmt.Errorf("missing %s header", replayNonceHeader)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %s", replayTimestampHeader, err)
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > replayMaxAge || age < -replayMaxAge {
+		return fmt.Errorf("replay request expired")
+	}
+
+	signature := header.Get(replaySignatureHeader)
+	return secrets.Verify(func(token string) bool {
+		return equalToken(signature, replaySignature(token, id, nonce, timestamp))
+	})
+}
+
+// HandleReplay handles the replay requests of the stored deliveries, which
+// are handled again without the duplicate check. It answers with the record
+// of the delivery.
+func (ra *ReceiveAdapter) HandleReplay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if ra.Deliveries == nil {
+		http.Error(w, "deliveries are not stored", http.StatusNotFound)
+		return
+	}
+
+	id := r.Header.Get(replayDeliveryHeader)
+	now := time.Now()
+	if err := verifyReplay(ra.Secrets, r.Header, now); err != nil {
+		log.Printf("Rejecting replay of delivery %s: %s", id, err)
+		ra.event(corev1.EventTypeWarning, reasonDeliveryRejected, "Rejected replay of delivery %s: %s", id, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	record, err := ra.Deliveries.Load(id)
+	if apierrs.IsNotFound(err) {
+		http.Error(w, fmt.Sprintf("delivery %s is not stored", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// 同一重放请求只处理一次，nonce 随 delivery 保存，多个 receiver 实例共享
+	if !record.useReplayNonce(r.Header.Get(replayNonceHeader), now) {
+		log.Printf("Rejecting replay of delivery %s: replay request already used", id)
+		ra.event(corev1.EventTypeWarning, reasonDeliveryRejected, "Rejected replay of delivery %s: replay request already used", id)
+		http.Error(w, "replay request already used", http.StatusUnauthorized)
+		return
+	}
+	if record.Truncated {
+		http.Error(w, fmt.Sprintf("the payload of delivery %s is too large to be stored", id), http.StatusUnprocessableEntity)
+		return
+	}
+
+	event, err := ra.HookServer.ParsePayload(record.Header, record.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	event.DeliveryID = id
+
+	log.Printf("Replaying %s delivery %s", event.Type, id)
+	record.Replays++
+	record.Decision = ""
+	record.Runs = nil
+	record.DryRun = false
+	record.Rendered = nil
+	record.CoalescedInto = ""
+	record.Error = ""
+	_, err = ra.handleDelivery(event, record.Header, time.Now(), record)
+	if err != nil {
+		record.Error = err.Error()
+	}
+	ra.saveDelivery(record)
+	ra.event(corev1.EventTypeNormal, reasonDeliveryReplayed, "Replayed %s delivery %s: %s", event.Type, id, record.Decision)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(record)
+}
+
+// saveDelivery stores a delivery, failures are only reported
+func (ra *ReceiveAdapter) saveDelivery(record *DeliveryRecord) {
+	if ra.Deliveries == nil {
+		return
+	}
+	if record.ID == "" {
+		record.ID = uuid.New().String()
+	}
+
+	if err := ra.Deliveries.Save(record); err != nil {
+		log.Printf("failed to store delivery %s: %s", record.ID, err)
+		ra.event(corev1.EventTypeWarning, reasonDeliveryStoreFailed, "Failed to store %s delivery %s: %s", record.Event, record.ID, err)
+	}
+}
@@ -0,0 +1,128 @@
+package githook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newGogsPush(body string) *http.Request {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("X-Gogs-Event", "push")
+	req.Header.Set("X-Gogs-Delivery", "delivery-1")
+	req.Header.Set("X-Gogs-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestReplayHandlesStoredDeliveryAgain(t *testing.T) {
+	trigger, err := NewTrigger(TriggerConfig{Name: "build", EventTypes: []string{"push"}, RunSpec: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	secrets := &SecretTokens{Current: "secret"}
+	tektonClient := &fakePipelineRunClient{}
+	store := &ConfigMapDeliveryStore{Client: fake.NewSimpleClientset().CoreV1(), Namespace: "default", Name: "hook"}
+	ra := &ReceiveAdapter{
+		TektonClient: tektonClient,
+		HookServer:   &GogsHookServer{Secrets: secrets},
+		Provider:     "gogs",
+		Namespace:    "default",
+		Name:         "hook",
+		Triggers:     []*Trigger{trigger},
+		Deliveries:   store,
+		Secrets:      secrets,
+	}
+
+	body := `{"ref": "refs/heads/main", "after": "abc123", "repository": {"clone_url": "http://gogs.example.com/owner/repo.git"}}`
+	ra.HandleRequest(httptest.NewRecorder(), newGogsPush(body))
+	ra.HandleRequest(httptest.NewRecorder(), newGogsPush(body))
+	if len(tektonClient.created) != 1 {
+		t.Fatalf("expected the duplicate delivery to be dropped, got %d PipelineRuns", len(tektonClient.created))
+	}
+
+	record, err := store.Load("delivery-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if record.Decision != "accepted" || len(record.Runs) != 1 || string(record.Body) != body {
+		t.Errorf("unexpected stored delivery %+v", record)
+	}
+
+	req, err := NewReplayRequest("http://receiver", "secret", "delivery-1", time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	w := httptest.NewRecorder()
+	ra.HandleReplay(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body)
+	}
+	if len(tektonClient.created) != 2 || tektonClient.revisions[1] != "abc123" {
+		t.Errorf("expected the replay to create a PipelineRun for abc123, got %v", tektonClient.revisions)
+	}
+	if record, err := store.Load("delivery-1"); err != nil || record.Replays != 1 {
+		t.Errorf("expected one replay to be recorded, got %+v: %v", record, err)
+	}
+
+	// the same replay request is rejected when sent again, a new one is handled
+	w = httptest.NewRecorder()
+	ra.HandleReplay(w, req)
+	if w.Code != http.StatusUnauthorized || len(tektonClient.created) != 2 {
+		t.Errorf("expected the reused replay request to be rejected, got %d with %d PipelineRuns", w.Code, len(tektonClient.created))
+	}
+
+	req, _ = NewReplayRequest("http://receiver", "secret", "delivery-1", time.Now())
+	w = httptest.NewRecorder()
+	ra.HandleReplay(w, req)
+	if w.Code != http.StatusOK || len(tektonClient.created) != 3 {
+		t.Errorf("expected a new replay request to be handled, got %d with %d PipelineRuns", w.Code, len(tektonClient.created))
+	}
+}
+
+func TestReplayRejectsUnsignedRequest(t *testing.T) {
+	secrets := &SecretTokens{Current: "secret"}
+	ra := &ReceiveAdapter{
+		HookServer: &GogsHookServer{Secrets: secrets},
+		Deliveries: &ConfigMapDeliveryStore{Client: fake.NewSimpleClientset().CoreV1(), Namespace: "default", Name: "hook"},
+		Secrets:    secrets,
+	}
+
+	tests := []struct {
+		token string
+		at    time.Time
+		nonce string
+	}{
+		{"other", time.Now(), ""},
+		{"secret", time.Now().Add(-time.Hour), ""},
+		// the nonce is signed with the delivery
+		{"secret", time.Now(), "forged"},
+	}
+
+	for _, test := range tests {
+		req, err := NewReplayRequest("http://receiver", test.token, "delivery-1", test.at)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if test.nonce != "" {
+			req.Header.Set(replayNonceHeader, test.nonce)
+		}
+		w := httptest.NewRecorder()
+		ra.HandleReplay(w, req)
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %d for %s at %s, got %d", http.StatusUnauthorized, test.token, test.at, w.Code)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package githook
+
+import (
+	"crypto/subtle"
+	"errors"
+	"time"
+)
+
+// ErrSignatureMismatch is returned when a delivery is not signed with any of
+// the accepted secret tokens
+var ErrSignatureMismatch = errors.New("webhook signature does not match any secret token")
+
+// SecretTokens keeps the secret tokens a delivery may be signed with. After a
+// rotation the previous token stays valid until PreviousExpiry, so deliveries
+// signed by the provider before it picked up the new token are not rejected.
+// A zero PreviousExpiry keeps the previous token valid, the new token has not
+// been pushed to the provider yet.
+type SecretTokens struct {
+	Current        string
+	Previous       string
+	PreviousExpiry time.Time
+}
+
+// Tokens returns the tokens accepted at the given time, current first
+func (s *SecretTokens) Tokens(now time.Time) []string {
+	if s == nil {
+		return nil
+	}
+
+	tokens := []string{}
+	if s.Current != "" {
+		tokens = append(tokens, s.Current)
+	}
+	if s.Previous != "" && s.Previous != s.Current && (s.PreviousExpiry.IsZero() || now.Before(s.PreviousExpiry)) {
+		tokens = append(tokens, s.Previous)
+	}
+
+	return tokens
+}
+
+// Verify runs match against every accepted token. Deliveries are not verified
+// when no token is configured.
+func (s *SecretTokens) Verify(match func(token string) bool) error {
+	tokens := s.Tokens(time.Now())
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	for _, token := range tokens {
+		if match(token) {
+			return nil
+		}
+	}
+
+	return ErrSignatureMismatch
+}
+
+func equalToken(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,51 @@
+package githook
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSecretTokensAcceptPreviousTokenUntilExpiry(t *testing.T) {
+	now := time.Date(2019, 6, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		secrets *SecretTokens
+		tokens  []string
+	}{
+		{"no tokens", nil, nil},
+		{"current only", &SecretTokens{Current: "new"}, []string{"new"}},
+		{"previous within overlap", &SecretTokens{Current: "new", Previous: "old", PreviousExpiry: now.Add(time.Minute)}, []string{"new", "old"}},
+		{"previous expired", &SecretTokens{Current: "new", Previous: "old", PreviousExpiry: now.Add(-time.Minute)}, []string{"new"}},
+		{"previous until pushed", &SecretTokens{Current: "new", Previous: "old"}, []string{"new", "old"}},
+		{"previous same as current", &SecretTokens{Current: "new", Previous: "new"}, []string{"new"}},
+	}
+
+	for _, test := range tests {
+		if tokens := test.secrets.Tokens(now); fmt.Sprint(tokens) != fmt.Sprint(test.tokens) {
+			t.Errorf("%s: expected tokens %v, got %v", test.name, test.tokens, tokens)
+		}
+	}
+}
+
+func TestSecretTokensVerify(t *testing.T) {
+	tests := []struct {
+		name      string
+		secrets   *SecretTokens
+		signature string
+		err       error
+	}{
+		{"unverified without token", &SecretTokens{}, "any", nil},
+		{"current token", &SecretTokens{Current: "new", Previous: "old"}, "new", nil},
+		{"previous token", &SecretTokens{Current: "new", Previous: "old", PreviousExpiry: time.Now().Add(time.Minute)}, "old", nil},
+		{"expired previous token", &SecretTokens{Current: "new", Previous: "old", PreviousExpiry: time.Now().Add(-time.Minute)}, "old", ErrSignatureMismatch},
+		{"unknown token", &SecretTokens{Current: "new"}, "other", ErrSignatureMismatch},
+	}
+
+	for _, test := range tests {
+		err := test.secrets.Verify(func(token string) bool { return equalToken(token, test.signature) })
+		if err != test.err {
+			t.Errorf("%s: expected error %v, got %v", test.name, test.err, err)
+		}
+	}
+}
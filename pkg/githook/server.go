@@ -1,71 +1,527 @@
 package githook
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/zhd173/githook/pkg/filter"
+	"github.com/zhd173/githook/pkg/metrics"
+	"github.com/zhd173/githook/pkg/model"
 	"github.com/zhd173/githook/pkg/tekton"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
+)
+
+// Event reasons of the receive adapter
+const (
+	reasonPipelineRunCreated = "PipelineRunCreated"
+	reasonPipelineRunFailed  = "PipelineRunFailed"
+	reasonParamsFailed       = "ParamsFailed"
+	reasonEventSkipped       = "EventSkipped"
+	reasonDeliveryRejected   = "DeliveryRejected"
+	reasonCloudEventFailed   = "CloudEventFailed"
+
+	reasonCommandRejected      = "CommandRejected"
+	reasonCommandFailed        = "CommandFailed"
+	reasonPipelineRunCancelled = "PipelineRunCancelled"
+	reasonPullRequestUntrusted = "PullRequestUntrusted"
+
+	reasonDeliveryReplayed    = "DeliveryReplayed"
+	reasonDeliveryStoreFailed = "DeliveryStoreFailed"
+	reasonPipelineRunRendered = "PipelineRunRendered"
+	reasonEventCoalesced      = "EventCoalesced"
+	reasonEventRateLimited    = "EventRateLimited"
+	reasonRateLimitFailed     = "RateLimitFailed"
+	reasonQueueFull           = "QueueFull"
+	reasonDeliveryFailed      = "DeliveryFailed"
+	reasonCommitStatusFailed  = "CommitStatusFailed"
 )
 
 // HookServer provides git provider specific functionality
 type HookServer interface {
 	GetEventHeader() string
-	Parse(r *http.Request) (interface{}, error)
-	BuildOptionFromPayload(payload interface{}) tekton.PipelineOptions
+	GetDeliveryHeader() string
+	Parse(r *http.Request) (*model.GitEvent, error)
+	// ParsePayload parses a delivery without verifying it, for the stored
+	// deliveries verified when they were received
+	ParsePayload(header http.Header, body []byte) (*model.GitEvent, error)
 }
 
-// ReceiveAdapter converts incoming git webhook events to
-// CloudEvents and then sends them to the specified Sink
+// PipelineRunClient creates and cancels the PipelineRuns of the events, and
+// renders them in dry run
+type PipelineRunClient interface {
+	CreatePipelineRun(options tekton.PipelineOptions, event *model.GitEvent) (*v1alpha1.PipelineRun, error)
+	RenderPipelineRun(options tekton.PipelineOptions, event *model.GitEvent) (*v1alpha1.PipelineRun, error)
+	CancelPipelineRuns(namespace string, selector map[string]string) ([]string, error)
+}
+
+// ReceiveAdapter converts incoming git webhook events to CloudEvents sent to
+// the specified Sink, and creates the PipelineRuns of the matching triggers
+// of the GitHook for them
 type ReceiveAdapter struct {
-	TektonClient *tekton.Client
+	TektonClient PipelineRunClient
+
+	HookServer HookServer
+	Provider   string
+	Namespace  string
+	Name       string
+	// Params are evaluated against each event and passed to the PipelineRuns
+	// of all triggers
+	Params []tekton.Param
+
+	// Triggers create the PipelineRuns of the events, the run spec of the
+	// GitHook itself being the trigger without name
+	Triggers []*Trigger
+
+	// When selects the events forwarded to the sink and triggering PipelineRuns
+	When *filter.When
+
+	// ChatOpsConfig allows users to run the slash commands of pull request
+	// comments, the commands are handled as other comments when nil
+	ChatOpsConfig *ChatOpsConfig
+
+	// PullRequestConfig holds the pull requests of untrusted authors, all pull
+	// requests run when nil
+	PullRequestConfig *PullRequestConfig
+
+	// DryRun renders the PipelineRuns of the events and reports them in
+	// Events and in the delivery store, without creating them
+	DryRun bool
+
+	// CommitStatus reports a pending status on the commit of the events the
+	// PipelineRuns are created for, with the client of ChatOps
+	CommitStatus bool
+
+	// Queue hands the deliveries to the workers, HandleRequest answers 202
+	// once a delivery is queued. Deliveries are handled within the request
+	// when nil.
+	Queue *EventQueue
+	// Backoff retries the transient Tekton API errors creating the
+	// PipelineRuns, none when it has no Steps
+	Backoff wait.Backoff
+
+	// RateLimit debounces and limits the events running the triggers, with
+	// the state shared in RateLimits. Events are not limited when either is nil.
+	RateLimit  *RateLimitConfig
+	RateLimits RateLimitState
+	// debounced tracks the debounced deliveries waiting for their window
+	debounced debounceWaits
 
-	HookServer  HookServer
-	Namespace   string
-	Name        string
-	RunSpecJSON string
+	// Config provides When, Params, Triggers, ChatOpsConfig, PullRequestConfig,
+	// DryRun, RateLimit and CommitStatus from the configuration file of the
+	// receiver when set
+	Config *ConfigFile
+
+	// ChatOps calls the git provider for the slash commands and to check the
+	// authors of pull requests, nil disables the slash commands
+	ChatOps *ChatOps
+
+	// Sender forwards the events to the sink of the GitHook, nil without sink
+	Sender *CloudEventSender
+
+	// Deliveries stores the verified deliveries for inspection and replay,
+	// nil disables both
+	Deliveries DeliveryStore
+	// Secrets authenticate the replay requests, see NewReplayRequest
+	Secrets *SecretTokens
+
+	// Recorder emits the Events of the receiver on Source, the GitHook
+	// served by the receiver. No Events are emitted when nil.
+	Recorder record.EventRecorder
+	Source   *corev1.ObjectReference
+
+	deliveriesOnce sync.Once
+	deliveryCache  *deliveryCache
 }
 
-// HandleRequest handles webhook request
+// HandleRequest handles webhook request, it answers 202 once the delivery
+// is verified, parsed and queued when the receiver has a Queue
 func (ra *ReceiveAdapter) HandleRequest(w http.ResponseWriter, r *http.Request) {
-	payload, err := ra.HookServer.Parse(r)
+	received := time.Now()
+	gitEventType := r.Header.Get("X-" + ra.HookServer.GetEventHeader())
+	deliveryID := r.Header.Get("X-" + ra.HookServer.GetDeliveryHeader())
+
+	// 保存 delivery 需要在解析前读取原始 payload
+	var body []byte
+	if ra.Deliveries != nil {
+		var err error
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			ra.observeDelivery(gitEventType, metrics.DecisionInvalid)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	event, err := ra.HookServer.Parse(r)
+	if err == ErrSignatureMismatch {
+		log.Println(err)
+		ra.observeDelivery(gitEventType, metrics.DecisionBadSignature)
+		ra.event(corev1.EventTypeWarning, reasonDeliveryRejected, "Rejected %s delivery %s: %s", gitEventType, deliveryID, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
 	if err != nil {
 		log.Println(err)
-		http.Error(w, err.Error(), 500)
+		ra.observeDelivery(gitEventType, metrics.DecisionInvalid)
+		ra.event(corev1.EventTypeWarning, reasonDeliveryRejected, "Rejected %s delivery %s: %s", gitEventType, deliveryID, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// git 服务重发的 delivery 不再重复触发 pipeline
+	if deliveryID != "" && ra.deliveries().contains(deliveryID) {
+		log.Printf("Dropping duplicate delivery %s", deliveryID)
+		ra.observeDelivery(gitEventType, metrics.DecisionDuplicate)
+		return
+	}
+
+	event.DeliveryID = deliveryID
+
+	record := &DeliveryRecord{ID: deliveryID, Event: event.Type, Received: received, Header: r.Header, Body: body}
+	delivery := &queuedDelivery{event: event, header: r.Header, received: received, record: record}
+	if ra.Queue == nil {
+		ra.process(delivery)
+		return
 	}
 
-	ra.HandleEvent(payload, r.Header)
+	// 排队的 delivery 先计入重复检查，处理失败时再移除
+	if deliveryID != "" {
+		ra.deliveries().add(deliveryID)
+	}
+	if err := ra.Queue.add(delivery); err != nil {
+		if deliveryID != "" {
+			ra.deliveries().remove(deliveryID)
+		}
+		log.Printf("Dropping %s delivery %s: %s", gitEventType, deliveryID, err)
+		if err == errShuttingDown {
+			ra.observeDelivery(gitEventType, metrics.DecisionShuttingDown)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		ra.observeDelivery(gitEventType, metrics.DecisionQueueFull)
+		ra.event(corev1.EventTypeWarning, reasonQueueFull, "Dropped %s delivery %s: the queue is full", gitEventType, deliveryID)
+		http.Error(w, "the receiver is busy", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
 }
 
 // HandleEvent is invoked whenever an event comes in from git
-func (ra *ReceiveAdapter) HandleEvent(payload interface{}, header http.Header) {
-	err := ra.handleEvent(payload, header)
+func (ra *ReceiveAdapter) HandleEvent(event *model.GitEvent, header http.Header) {
+	_, err := ra.handleEvent(event, header, time.Now())
 	if err != nil {
 		log.Printf("unexpected error handling git event: %s", err)
 	}
 }
 
-func (ra *ReceiveAdapter) handleEvent(payload interface{}, header http.Header) error {
-	gitEventType := header.Get("X-" + ra.HookServer.GetEventHeader())
+// handleEvent sends an event to the sink and creates its PipelineRun, it
+// reports whether the event was handled rather than filtered
+func (ra *ReceiveAdapter) handleEvent(event *model.GitEvent, header http.Header, received time.Time) (bool, error) {
+	return ra.handleDelivery(event, header, received, &DeliveryRecord{})
+}
+
+// handleDelivery handles an event like handleEvent, and records the decision
+// and the PipelineRuns created in the record of its delivery
+func (ra *ReceiveAdapter) handleDelivery(event *model.GitEvent, header http.Header, received time.Time, record *DeliveryRecord) (bool, error) {
+	gitEventType := event.Type
 
 	log.Printf("Handling %s", gitEventType)
 
 	if gitEventType == "" {
-		return fmt.Errorf("invalid event: %s", gitEventType)
+		ra.observeDelivery(gitEventType, metrics.DecisionInvalid)
+		return false, fmt.Errorf("invalid event: %s", gitEventType)
 	}
 
-	options := ra.HookServer.BuildOptionFromPayload(payload)
-	options.Namespace = ra.Namespace
-	options.Prefix = ra.Name
-	options.RunSpecJSON = ra.RunSpecJSON
+	// ping 等不对应仓库代码的事件不触发 pipeline
+	if event.CloneURL() == "" {
+		log.Printf("Ignoring %s without repository", gitEventType)
+		ra.observeDelivery(gitEventType, metrics.DecisionFiltered)
+		record.Decision = metrics.DecisionFiltered
+		return false, nil
+	}
+
+	config := ra.config()
+
+	// PR 评论中的斜杠命令作用于 PR 的 head commit，不作为普通事件处理
+	if cmd := ra.commandOf(config, event); cmd != nil {
+		return ra.runCommand(config, cmd, event, header, received, record)
+	}
 
-	pipelineRun, err := ra.TektonClient.CreatePipelineRun(options)
+	matched, expression, err := config.when.Match(event, header)
+	if !matched {
+		ra.skipEvent(event, expression, err)
+		record.Decision = metrics.DecisionFiltered
+		return false, nil
+	}
+
+	ra.observeDelivery(gitEventType, metrics.DecisionAccepted)
+	record.Decision = metrics.DecisionAccepted
+
+	// 发送到 sink 失败不影响触发器运行，错误在触发器运行后返回并记录到 delivery
+	var sendErr error
+	if ra.Sender != nil {
+		sendErr = ra.Sender.Send(event)
+		if sendErr != nil {
+			metrics.CloudEvents.WithLabelValues(metrics.CloudEventFailed).Inc()
+			ra.event(corev1.EventTypeWarning, reasonCloudEventFailed, "Failed to send %s event to the sink: %s", gitEventType, sendErr)
+		} else {
+			metrics.CloudEvents.WithLabelValues(metrics.CloudEventSent).Inc()
+		}
+	}
+
+	handled, err := ra.triggerDelivery(config, event, header, received, record)
+	if err == nil && sendErr != nil {
+		err = sendErr
+	}
+	return handled, err
+}
 
+// triggerDelivery applies the pull request policy and the rate limits to an
+// accepted event, and runs its triggers
+func (ra *ReceiveAdapter) triggerDelivery(config *compiledConfig, event *model.GitEvent, header http.Header, received time.Time, record *DeliveryRecord) (bool, error) {
+	gitEventType := event.Type
+
+	if handledBy(config.triggers, event) {
+		// 不受信任作者的 PR 按 untrustedPolicy 运行
+		admitted, reason, err := ra.admitPullRequest(config, event)
+		if err != nil {
+			ra.event(corev1.EventTypeWarning, reasonPullRequestUntrusted, "Failed to check the author of %s delivery %s: %s", gitEventType, event.DeliveryID, err)
+			return false, err
+		}
+		if !admitted {
+			log.Printf("Holding %s delivery %s: %s", gitEventType, event.DeliveryID, reason)
+			ra.event(corev1.EventTypeNormal, reasonPullRequestUntrusted, "Held %s delivery %s: %s", gitEventType, event.DeliveryID, reason)
+			record.Decision = decisionHeld
+			return true, nil
+		}
+
+		// 添加 ok-to-test 标签以 ok-to-test 为 action 重新运行 PR 的触发器
+		if isApproval(config, event) {
+			approved := *event
+			approved.Action = okToTestLabel
+			event = &approved
+		}
+	}
+
+	// 重放的 delivery 不受限流
+	if config.rateLimit != nil && ra.RateLimits != nil && record.Replays == 0 && handledBy(config.triggers, event) {
+		return ra.limitDelivery(config, event, header, received, record)
+	}
+	return ra.runTriggers(config, event, header, received, record)
+}
+
+// runTriggers creates the PipelineRuns of the triggers handling an event
+func (ra *ReceiveAdapter) runTriggers(config *compiledConfig, event *model.GitEvent, header http.Header, received time.Time, record *DeliveryRecord) (bool, error) {
+	// 每个匹配的触发器各自创建 PipelineRun，未指定触发器时只转发事件
+	var firstErr error
+	created := 0
+	for _, trigger := range config.triggers {
+		if !trigger.handles(event) {
+			continue
+		}
+
+		matched, expression, err := trigger.When.Match(event, header)
+		if !matched {
+			ra.reportSkipped(event, trigger.Name, expression, err)
+			continue
+		}
+
+		pipelineRun, err := ra.runTrigger(trigger, config, event, received)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		record.addRun(pipelineRun, config.dryRun)
+		created++
+	}
+
+	// 部分触发器已创建 PipelineRun 时不再重复处理该 delivery
+	return firstErr == nil || created > 0, firstErr
+}
+
+// runTrigger creates the PipelineRun of a trigger for an event and returns
+// it, in dry run it only renders and reports it
+func (ra *ReceiveAdapter) runTrigger(trigger *Trigger, config *compiledConfig, event *model.GitEvent, received time.Time) (*v1alpha1.PipelineRun, error) {
+	runSpecJSON, err := trigger.runSpecJSON()
 	if err != nil {
-		return err
+		metrics.PipelineRuns.WithLabelValues(metrics.PipelineRunFailed).Inc()
+		ra.event(corev1.EventTypeWarning, reasonPipelineRunFailed, "Failed to create PipelineRun%s for %s event: %s", forTrigger(trigger.Name), event.Type, err)
+		return nil, err
 	}
 
+	values, err := tekton.EvaluateParams(trigger.mergeParams(config.params), event)
+	if err != nil {
+		metrics.PipelineRuns.WithLabelValues(metrics.PipelineRunFailed).Inc()
+		ra.event(corev1.EventTypeWarning, reasonParamsFailed, "Failed to evaluate params%s for %s delivery %s: %s", forTrigger(trigger.Name), event.Type, event.DeliveryID, err)
+		return nil, err
+	}
+
+	options := tekton.PipelineOptions{
+		Namespace:   ra.Namespace,
+		Prefix:      trigger.prefix(ra.Name),
+		RunSpecJSON: runSpecJSON,
+		Params:      values,
+		Labels:      ra.runLabels(trigger, event),
+	}
+
+	if config.dryRun {
+		return ra.renderRun(trigger, options, event)
+	}
+
+	pipelineRun, err := ra.createPipelineRun(options, event)
+
+	if err != nil {
+		metrics.PipelineRuns.WithLabelValues(metrics.PipelineRunFailed).Inc()
+		ra.event(corev1.EventTypeWarning, reasonPipelineRunFailed, "Failed to create PipelineRun%s for %s event: %s", forTrigger(trigger.Name), event.Type, err)
+		return nil, err
+	}
+
+	metrics.PipelineRuns.WithLabelValues(metrics.PipelineRunCreated).Inc()
+	metrics.EventToPipelineRunDuration.Observe(time.Since(received).Seconds())
+
 	log.Printf("create pipeline run successfully %s", pipelineRun.Name)
+	ra.event(corev1.EventTypeNormal, reasonPipelineRunCreated, "Created PipelineRun %s%s for %s event", pipelineRun.Name, forTrigger(trigger.Name), event.Type)
+
+	if config.commitStatus {
+		ra.reportPending(trigger, pipelineRun, event)
+	}
+
+	return pipelineRun, nil
+}
+
+// renderRun renders the PipelineRun of a trigger in dry run and reports it
+// in an Event, the PipelineRun metrics are left untouched
+func (ra *ReceiveAdapter) renderRun(trigger *Trigger, options tekton.PipelineOptions, event *model.GitEvent) (*v1alpha1.PipelineRun, error) {
+	pipelineRun, err := ra.TektonClient.RenderPipelineRun(options, event)
+	if err != nil {
+		ra.event(corev1.EventTypeWarning, reasonPipelineRunFailed, "Failed to render PipelineRun%s for %s event in dry run: %s", forTrigger(trigger.Name), event.Type, err)
+		return nil, err
+	}
+
+	rendered, err := json.Marshal(pipelineRun)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Rendered pipeline run%s in dry run: %s", forTrigger(trigger.Name), rendered)
+	ra.event(corev1.EventTypeNormal, reasonPipelineRunRendered, "Rendered PipelineRun%s for %s event, not created in dry run: %s", forTrigger(trigger.Name), event.Type, rendered)
+
+	return pipelineRun, nil
+}
+
+// runName returns the name of a created PipelineRun, or the prefix of the
+// name of a rendered one
+func runName(pipelineRun *v1alpha1.PipelineRun) string {
+	if pipelineRun.Name != "" {
+		return pipelineRun.Name
+	}
+	return pipelineRun.GenerateName
+}
+
+// config returns the configuration the events are handled with
+func (ra *ReceiveAdapter) config() *compiledConfig {
+	if ra.Config != nil {
+		if config := ra.Config.config(); config != nil {
+			return config
+		}
+	}
+	return &compiledConfig{when: ra.When, params: ra.Params, triggers: ra.Triggers, chatops: ra.ChatOpsConfig, pullRequest: ra.PullRequestConfig, dryRun: ra.DryRun, rateLimit: ra.RateLimit, commitStatus: ra.CommitStatus}
+}
+
+// handledBy reports whether one of the triggers handles the type of an event
+func handledBy(triggers []*Trigger, event *model.GitEvent) bool {
+	for _, trigger := range triggers {
+		if trigger.handles(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// runLabels labels the PipelineRuns with the GitHook, the trigger and the
+// pull request they run for
+func (ra *ReceiveAdapter) runLabels(trigger *Trigger, event *model.GitEvent) map[string]string {
+	labels := map[string]string{tekton.LabelGitHook: ra.Name}
+	if trigger.Name != "" {
+		labels[tekton.LabelTrigger] = trigger.Name
+	}
+	if event.PullRequest != nil && event.PullRequest.Number != 0 {
+		labels[tekton.LabelPullRequest] = strconv.Itoa(event.PullRequest.Number)
+	}
+	return labels
+}
 
-	return nil
+// skipEvent reports the when expression of the GitHook an event failed
+func (ra *ReceiveAdapter) skipEvent(event *model.GitEvent, expression string, err error) {
+	ra.observeDelivery(event.Type, metrics.DecisionFiltered)
+	ra.reportSkipped(event, "", expression, err)
+}
+
+// reportSkipped reports the when expression of the GitHook, or of one of its
+// triggers, an event failed
+func (ra *ReceiveAdapter) reportSkipped(event *model.GitEvent, trigger, expression string, err error) {
+	delivery := fmt.Sprintf("%s delivery %s%s", event.Type, event.DeliveryID, forTrigger(trigger))
+
+	if expression == "" {
+		log.Printf("Skipping %s: %s", delivery, err)
+		ra.event(corev1.EventTypeWarning, reasonEventSkipped, "Skipped %s: %s", delivery, err)
+		return
+	}
+
+	if err != nil {
+		log.Printf("Skipping %s: when expression %q failed: %s", delivery, expression, err)
+		ra.event(corev1.EventTypeNormal, reasonEventSkipped, "Skipped %s: when expression %q failed: %s", delivery, expression, err)
+		return
+	}
+
+	log.Printf("Skipping %s: when expression %q is false", delivery, expression)
+	ra.event(corev1.EventTypeNormal, reasonEventSkipped, "Skipped %s: when expression %q is false", delivery, expression)
+}
+
+// forTrigger names a trigger in the messages of its events
+func forTrigger(trigger string) string {
+	if trigger == "" {
+		return ""
+	}
+	return fmt.Sprintf(" for trigger %s", trigger)
+}
+
+func (ra *ReceiveAdapter) deliveries() *deliveryCache {
+	ra.deliveriesOnce.Do(func() {
+		ra.deliveryCache = newDeliveryCache(defaultDeliveryCacheSize)
+	})
+	return ra.deliveryCache
+}
+
+func (ra *ReceiveAdapter) event(eventType, reason, messageFmt string, args ...interface{}) {
+	if ra.Recorder == nil || ra.Source == nil {
+		return
+	}
+	ra.Recorder.Eventf(ra.Source, eventType, reason, messageFmt, args...)
+}
+
+func (ra *ReceiveAdapter) observeDelivery(event, decision string) {
+	metrics.WebhooksReceived.WithLabelValues(ra.Provider, event, decision).Inc()
+}
+
+// tagName returns the tag name of a tag ref, and empty for other refs
+func tagName(ref string) string {
+	if !strings.HasPrefix(ref, "refs/tags/") {
+		return ""
+	}
+	return strings.TrimPrefix(ref, "refs/tags/")
 }
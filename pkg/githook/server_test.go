@@ -0,0 +1,169 @@
+package githook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/zhd173/githook/pkg/filter"
+	"github.com/zhd173/githook/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestHandleRequestRejectsBadSignature(t *testing.T) {
+	recorder := record.NewFakeRecorder(1)
+	ra := &ReceiveAdapter{
+		HookServer: &GogsHookServer{Secrets: &SecretTokens{Current: "secret"}},
+		Provider:   "gogs",
+		Recorder:   recorder,
+		Source:     &corev1.ObjectReference{Kind: "GitHook", Namespace: "default", Name: "hook"},
+	}
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{}`))
+	req.Header.Set("X-Gogs-Event", "push")
+	req.Header.Set("X-Gogs-Delivery", "delivery-1")
+	req.Header.Set("X-Gogs-Signature", "invalid")
+	w := httptest.NewRecorder()
+
+	ra.HandleRequest(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Warning DeliveryRejected Rejected push delivery delivery-1") {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Error("expected a DeliveryRejected event")
+	}
+}
+
+func TestHandleRequestReportsSkippedEvent(t *testing.T) {
+	when, err := filter.Compile([]string{`event.type == "push"`, `event.ref == "refs/heads/main"`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	recorder := record.NewFakeRecorder(1)
+	ra := &ReceiveAdapter{
+		HookServer: &GogsHookServer{Secrets: &SecretTokens{Current: "secret"}},
+		Provider:   "gogs",
+		When:       when,
+		Recorder:   recorder,
+		Source:     &corev1.ObjectReference{Kind: "GitHook", Namespace: "default", Name: "hook"},
+	}
+
+	body := `{"ref": "refs/heads/develop", "repository": {"clone_url": "http://gogs.example.com/owner/repo.git"}}`
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(body))
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	req.Header.Set("X-Gogs-Event", "push")
+	req.Header.Set("X-Gogs-Delivery", "delivery-1")
+	req.Header.Set("X-Gogs-Signature", hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+
+	ra.HandleRequest(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	select {
+	case event := <-recorder.Events:
+		expected := `Normal EventSkipped Skipped push delivery delivery-1: when expression "event.ref == \"refs/heads/main\"" is false`
+		if event != expected {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Error("expected an EventSkipped event")
+	}
+}
+
+func TestHandleRequestRendersPipelineRunInDryRun(t *testing.T) {
+	trigger, err := NewTrigger(TriggerConfig{Name: "build", EventTypes: []string{"push"}, RunSpec: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	recorder := record.NewFakeRecorder(1)
+	tektonClient := &fakePipelineRunClient{}
+	store := &ConfigMapDeliveryStore{Client: fake.NewSimpleClientset().CoreV1(), Namespace: "default", Name: "hook"}
+	ra := &ReceiveAdapter{
+		TektonClient: tektonClient,
+		HookServer:   &GogsHookServer{Secrets: &SecretTokens{Current: "secret"}},
+		Provider:     "gogs",
+		Namespace:    "default",
+		Name:         "hook",
+		Triggers:     []*Trigger{trigger},
+		Deliveries:   store,
+		DryRun:       true,
+		Recorder:     recorder,
+		Source:       &corev1.ObjectReference{Kind: "GitHook", Namespace: "default", Name: "hook"},
+	}
+
+	body := `{"ref": "refs/heads/main", "after": "abc123", "repository": {"clone_url": "http://gogs.example.com/owner/repo.git"}}`
+	ra.HandleRequest(httptest.NewRecorder(), newGogsPush(body))
+
+	if len(tektonClient.created) != 0 || len(tektonClient.rendered) != 1 {
+		t.Fatalf("expected one PipelineRun rendered and none created, got %d rendered and %d created",
+			len(tektonClient.rendered), len(tektonClient.created))
+	}
+
+	select {
+	case event := <-recorder.Events:
+		if !strings.HasPrefix(event, "Normal PipelineRunRendered Rendered PipelineRun for trigger build for push event, not created in dry run: {") {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Error("expected a PipelineRunRendered event")
+	}
+
+	record, err := store.Load("delivery-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !record.DryRun || len(record.Runs) != 0 || len(record.Rendered) != 1 || record.Rendered[0].GenerateName != "hook-build-" {
+		t.Errorf("expected the rendered PipelineRun to be stored, got %+v", record)
+	}
+}
+
+func TestHandleRequestRecordsDecisions(t *testing.T) {
+	when, err := filter.Compile([]string{`event.ref == "refs/heads/main"`})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ra := &ReceiveAdapter{
+		HookServer: &GogsHookServer{Secrets: &SecretTokens{Current: "secret"}},
+		Provider:   "decisions-test",
+		When:       when,
+	}
+
+	body := `{"ref": "refs/heads/develop", "repository": {"clone_url": "http://gogs.example.com/owner/repo.git"}}`
+	ra.HandleRequest(httptest.NewRecorder(), newGogsPush(body))
+
+	req := newGogsPush(body)
+	req.Header.Set("X-Gogs-Signature", "invalid")
+	ra.HandleRequest(httptest.NewRecorder(), req)
+
+	for decision, expected := range map[string]float64{
+		metrics.DecisionFiltered:     1,
+		metrics.DecisionBadSignature: 1,
+		metrics.DecisionAccepted:     0,
+	} {
+		if count := testutil.ToFloat64(metrics.WebhooksReceived.WithLabelValues("decisions-test", "push", decision)); count != expected {
+			t.Errorf("expected %v %s deliveries, got %v", expected, decision, count)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package githook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zhd173/githook/pkg/model"
+)
+
+// CloudEventTypePrefix prefixes the type of the CloudEvents sent to the sink,
+// followed by the event type as named in the GitHook eventTypes
+const CloudEventTypePrefix = "dev.githook."
+
+// CloudEventSender sends git events to a sink as binary mode CloudEvents
+type CloudEventSender struct {
+	Sink   string
+	Client *http.Client
+}
+
+// NewCloudEventSender creates a sender posting to sink
+func NewCloudEventSender(sink string) *CloudEventSender {
+	return &CloudEventSender{
+		Sink:   sink,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Send posts a git event to the sink, the raw payload of the git provider is
+// kept in its payload field. The delivery id of the git provider is used as
+// the event id so that consumers can drop duplicates.
+func (sender *CloudEventSender) Send(event *model.GitEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %s", event.Type, err)
+	}
+
+	req, err := http.NewRequest("POST", sender.Sink, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	deliveryID := event.DeliveryID
+	if deliveryID == "" {
+		deliveryID = uuid.New().String()
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ce-Specversion", "1.0")
+	req.Header.Set("Ce-Id", deliveryID)
+	req.Header.Set("Ce-Type", CloudEventTypePrefix+event.Type)
+	req.Header.Set("Ce-Source", eventSource(event))
+	req.Header.Set("Ce-Time", time.Now().UTC().Format(time.RFC3339))
+	req.Header.Set("Ce-Githookprovider", event.Provider)
+	if event.Ref != "" {
+		req.Header.Set("Ce-Subject", event.Ref)
+	}
+
+	resp, err := sender.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send %s event to %s: %s", event.Type, sender.Sink, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sink %s rejected %s event: %s", sender.Sink, event.Type, resp.Status)
+	}
+
+	return nil
+}
+
+// eventSource prefers the web url of the repository and falls back to its clone url
+func eventSource(event *model.GitEvent) string {
+	if event.Repository.URL != "" {
+		return event.Repository.URL
+	}
+	return event.Repository.CloneURL
+}
@@ -0,0 +1,117 @@
+package githook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zhd173/githook/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestCloudEventSenderSendsBinaryEvent(t *testing.T) {
+	var header http.Header
+	body := map[string]interface{}{}
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	event := &model.GitEvent{
+		Provider:   "github",
+		DeliveryID: "delivery-1",
+		Type:       "push",
+		Repository: model.GitRepository{
+			FullName: "owner/repo",
+			CloneURL: "https://github.com/owner/repo.git",
+			URL:      "https://github.com/owner/repo",
+		},
+		Ref:     "refs/heads/master",
+		Payload: []byte(`{"ref":"refs/heads/master"}`),
+	}
+
+	if err := NewCloudEventSender(sink.URL).Send(event); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for key, expected := range map[string]string{
+		"Ce-Specversion":     "1.0",
+		"Ce-Id":              "delivery-1",
+		"Ce-Type":            "dev.githook.push",
+		"Ce-Source":          "https://github.com/owner/repo",
+		"Ce-Subject":         "refs/heads/master",
+		"Ce-Githookprovider": "github",
+		"Content-Type":       "application/json",
+	} {
+		if header.Get(key) != expected {
+			t.Errorf("expected %s %q, got %q", key, expected, header.Get(key))
+		}
+	}
+	if body["ref"] != "refs/heads/master" || body["payload"].(map[string]interface{})["ref"] != "refs/heads/master" {
+		t.Errorf("unexpected event data %v", body)
+	}
+}
+
+func TestCloudEventSenderReportsRejectedEvent(t *testing.T) {
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer sink.Close()
+
+	err := NewCloudEventSender(sink.URL).Send(&model.GitEvent{Provider: "gogs", Type: "push"})
+	if err == nil {
+		t.Error("expected error for rejected event")
+	}
+}
+
+func TestHandleRequestRunsTriggersWhenSinkFails(t *testing.T) {
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer sink.Close()
+
+	trigger, err := NewTrigger(TriggerConfig{Name: "build", EventTypes: []string{"push"}, RunSpec: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	recorder := record.NewFakeRecorder(2)
+	tektonClient := &fakePipelineRunClient{}
+	store := &ConfigMapDeliveryStore{Client: fake.NewSimpleClientset().CoreV1(), Namespace: "default", Name: "hook"}
+	ra := &ReceiveAdapter{
+		TektonClient: tektonClient,
+		HookServer:   &GogsHookServer{Secrets: &SecretTokens{Current: "secret"}},
+		Provider:     "gogs",
+		Namespace:    "default",
+		Name:         "hook",
+		Triggers:     []*Trigger{trigger},
+		Deliveries:   store,
+		Sender:       NewCloudEventSender(sink.URL),
+		Recorder:     recorder,
+		Source:       &corev1.ObjectReference{Kind: "GitHook", Namespace: "default", Name: "hook"},
+	}
+
+	body := `{"ref": "refs/heads/main", "after": "abc123", "repository": {"clone_url": "http://gogs.example.com/owner/repo.git"}}`
+	ra.HandleRequest(httptest.NewRecorder(), newGogsPush(body))
+
+	if len(tektonClient.created) != 1 {
+		t.Fatalf("expected the trigger to run, got %d PipelineRuns", len(tektonClient.created))
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning CloudEventFailed") {
+		t.Errorf("expected a CloudEventFailed event, got %q", event)
+	}
+
+	record, err := store.Load("delivery-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(record.Runs) != 1 || !strings.Contains(record.Error, "sink") {
+		t.Errorf("expected the run and the sink error to be stored, got %+v", record)
+	}
+}
@@ -0,0 +1,48 @@
+package githook
+
+import (
+	"log"
+
+	"github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/zhd173/githook/pkg/model"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// CommitStatusClient provides the git provider api reporting the status of
+// the commits
+type CommitStatusClient interface {
+	CreateStatus(options *model.HookOptions, status *model.CommitStatus) error
+}
+
+// statusContext returns the context of the commit statuses of a trigger, one
+// status per trigger of the GitHook
+func (ra *ReceiveAdapter) statusContext(trigger *Trigger) string {
+	if trigger.Name == "" {
+		return "githook/" + ra.Name
+	}
+	return "githook/" + ra.Name + "/" + trigger.Name
+}
+
+// reportPending reports the pending status of the commit of an event a
+// PipelineRun was created for. The PipelineRun runs whether the status is
+// reported or not.
+func (ra *ReceiveAdapter) reportPending(trigger *Trigger, pipelineRun *v1alpha1.PipelineRun, event *model.GitEvent) {
+	if event.After == "" {
+		return
+	}
+	if ra.ChatOps == nil || ra.ChatOps.Statuses == nil {
+		ra.event(corev1.EventTypeWarning, reasonCommitStatusFailed, "No git provider client to report the status of commit %s", event.After)
+		return
+	}
+
+	status := &model.CommitStatus{
+		SHA:         event.After,
+		State:       model.CommitStatusPending,
+		Description: "PipelineRun " + pipelineRun.Name + " created",
+		Context:     ra.statusContext(trigger),
+	}
+	if err := ra.ChatOps.Statuses.CreateStatus(ra.ChatOps.Options, status); err != nil {
+		log.Printf("failed to report the status of commit %s: %s", event.After, err)
+		ra.event(corev1.EventTypeWarning, reasonCommitStatusFailed, "Failed to report the status of commit %s for PipelineRun %s: %s", event.After, pipelineRun.Name, err)
+	}
+}
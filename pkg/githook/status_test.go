@@ -0,0 +1,48 @@
+package githook
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zhd173/githook/pkg/model"
+)
+
+// fakeStatusClient records the commit statuses reported
+type fakeStatusClient struct {
+	statuses []*model.CommitStatus
+}
+
+func (client *fakeStatusClient) CreateStatus(options *model.HookOptions, status *model.CommitStatus) error {
+	client.statuses = append(client.statuses, status)
+	return nil
+}
+
+func TestHandleRequestReportsPendingCommitStatus(t *testing.T) {
+	trigger, err := NewTrigger(TriggerConfig{Name: "build", EventTypes: []string{"push"}, RunSpec: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	statuses := &fakeStatusClient{}
+	ra := &ReceiveAdapter{
+		TektonClient: &fakePipelineRunClient{},
+		HookServer:   &GogsHookServer{Secrets: &SecretTokens{Current: "secret"}},
+		Provider:     "gogs",
+		Namespace:    "default",
+		Name:         "hook",
+		Triggers:     []*Trigger{trigger},
+		ChatOps:      &ChatOps{Client: &fakeChatOpsClient{}, Options: &model.HookOptions{}, Statuses: statuses},
+		CommitStatus: true,
+	}
+
+	body := `{"ref": "refs/heads/main", "after": "abc123", "repository": {"clone_url": "http://gogs.example.com/owner/repo.git"}}`
+	ra.HandleRequest(httptest.NewRecorder(), newGogsPush(body))
+
+	if len(statuses.statuses) != 1 {
+		t.Fatalf("expected one commit status, got %d", len(statuses.statuses))
+	}
+	status := statuses.statuses[0]
+	if status.SHA != "abc123" || status.State != model.CommitStatusPending || status.Context != "githook/hook/build" {
+		t.Errorf("unexpected commit status %+v", status)
+	}
+}
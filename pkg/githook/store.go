@@ -0,0 +1,279 @@
+package githook
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	pipelinev1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/zhd173/githook/pkg/tekton"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// LabelDelivery marks the ConfigMaps of the stored deliveries, together with
+// the tekton.LabelGitHook label of their GitHook
+const LabelDelivery = "githook.tools/delivery"
+
+// Keys of the stored deliveries in their ConfigMap
+const (
+	deliveryRecordKey = "delivery.json"
+	deliveryHeaderKey = "header.json"
+	deliveryBodyKey   = "body"
+)
+
+// Defaults of the delivery store
+const (
+	DefaultDeliveryLimit = 50
+	// DefaultDeliveryBodySize keeps the ConfigMaps well below their 1MiB limit
+	DefaultDeliveryBodySize = 512 * 1024
+)
+
+// decisionHeld is the decision of the deliveries held by the pull request policy
+const decisionHeld = "held"
+
+// decisionFailed is the decision of the deliveries which failed to be
+// handled, they can be replayed
+const decisionFailed = "failed"
+
+// secretHeaders carry credentials and are not stored
+var secretHeaders = []string{"Authorization", "X-Gitlab-Token"}
+
+var invalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
+// DeliveryRecord is a delivery received by the receiver, with the outcome of
+// its latest handling
+type DeliveryRecord struct {
+	ID       string    `json:"id"`
+	Event    string    `json:"event"`
+	Received time.Time `json:"received"`
+	// Decision is accepted, filtered, held, failed, or the rate limit
+	// outcome, see the metrics decisions and rate limit outcomes
+	Decision string   `json:"decision"`
+	Runs     []string `json:"runs,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	// Replays counts the handlings after the first one
+	Replays int `json:"replays,omitempty"`
+	// ReplayNonces are the nonces of the replay requests still within their
+	// max age, with the time they were used
+	ReplayNonces map[string]time.Time `json:"replayNonces,omitempty"`
+	// CoalescedInto is the later delivery of the same ref a debounced
+	// delivery was coalesced into
+	CoalescedInto string `json:"coalescedInto,omitempty"`
+	// Truncated deliveries are stored without body and cannot be replayed
+	Truncated bool `json:"truncated,omitempty"`
+	// DryRun deliveries were handled in dry run, their PipelineRuns are
+	// Rendered instead of created
+	DryRun   bool                            `json:"dryRun,omitempty"`
+	Rendered []*pipelinev1alpha1.PipelineRun `json:"rendered,omitempty"`
+
+	Header http.Header `json:"-"`
+	Body   []byte      `json:"-"`
+}
+
+// addRun records a PipelineRun created for the delivery, or rendered in dry run
+func (record *DeliveryRecord) addRun(pipelineRun *pipelinev1alpha1.PipelineRun, dryRun bool) {
+	if dryRun {
+		record.DryRun = true
+		record.Rendered = append(record.Rendered, pipelineRun)
+		return
+	}
+	record.Runs = append(record.Runs, pipelineRun.Name)
+}
+
+// useReplayNonce records the nonce of a replay request, false when it was used
+// before. Nonces older than the max age of the replay requests are dropped.
+func (record *DeliveryRecord) useReplayNonce(nonce string, now time.Time) bool {
+	for used, at := range record.ReplayNonces {
+		if now.Sub(at) > replayMaxAge {
+			delete(record.ReplayNonces, used)
+		}
+	}
+
+	if _, ok := record.ReplayNonces[nonce]; ok {
+		return false
+	}
+	if record.ReplayNonces == nil {
+		record.ReplayNonces = map[string]time.Time{}
+	}
+	record.ReplayNonces[nonce] = now
+	return true
+}
+
+// DeliveryStore persists the deliveries of the receiver so that they can be
+// inspected and replayed
+type DeliveryStore interface {
+	Save(record *DeliveryRecord) error
+	Load(id string) (*DeliveryRecord, error)
+}
+
+// ConfigMapDeliveryStore stores each delivery in a ConfigMap owned by the
+// GitHook, keeping the latest Limit deliveries
+type ConfigMapDeliveryStore struct {
+	Client    typedcorev1.ConfigMapsGetter
+	Namespace string
+	// Name is the name of the GitHook
+	Name string
+	// Owner is the GitHook owning the ConfigMaps, nil leaves them unowned
+	Owner *metav1.OwnerReference
+
+	// Limit bounds the stored deliveries, the oldest being deleted first
+	Limit int
+	// BodySize bounds the stored payloads, larger payloads are not stored
+	BodySize int
+}
+
+// DeliveryConfigMapName returns the name of the ConfigMap of a delivery
+func DeliveryConfigMapName(name, id string) string {
+	configMapName := fmt.Sprintf("%s-delivery-%s", name, strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(id), "-"), "-"))
+	if len(configMapName) > 253 {
+		configMapName = strings.TrimRight(configMapName[:253], "-")
+	}
+	return configMapName
+}
+
+// Save creates or updates the ConfigMap of a delivery and deletes the
+// oldest deliveries over the limit
+func (store *ConfigMapDeliveryStore) Save(record *DeliveryRecord) error {
+	configMap, err := store.configMap(record)
+	if err != nil {
+		return err
+	}
+
+	configMaps := store.Client.ConfigMaps(store.Namespace)
+	existing, err := configMaps.Get(configMap.Name, metav1.GetOptions{})
+	switch {
+	case apierrs.IsNotFound(err):
+		if _, err := configMaps.Create(configMap); err != nil {
+			return fmt.Errorf("failed to store delivery %s: %s", record.ID, err)
+		}
+	case err != nil:
+		return fmt.Errorf("failed to store delivery %s: %s", record.ID, err)
+	default:
+		existing.Data = configMap.Data
+		if _, err := configMaps.Update(existing); err != nil {
+			return fmt.Errorf("failed to store delivery %s: %s", record.ID, err)
+		}
+	}
+
+	return store.prune()
+}
+
+// Load returns a stored delivery, with a NotFound error when it is not stored
+func (store *ConfigMapDeliveryStore) Load(id string) (*DeliveryRecord, error) {
+	configMap, err := store.Client.ConfigMaps(store.Namespace).Get(DeliveryConfigMapName(store.Name, id), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return ParseDeliveryRecord(configMap)
+}
+
+// ParseDeliveryRecord returns the delivery stored in a ConfigMap
+func ParseDeliveryRecord(configMap *corev1.ConfigMap) (*DeliveryRecord, error) {
+	record := &DeliveryRecord{}
+	if err := json.Unmarshal([]byte(configMap.Data[deliveryRecordKey]), record); err != nil {
+		return nil, fmt.Errorf("invalid delivery %s: %s", configMap.Name, err)
+	}
+
+	if header, ok := configMap.Data[deliveryHeaderKey]; ok {
+		if err := json.Unmarshal([]byte(header), &record.Header); err != nil {
+			return nil, fmt.Errorf("invalid delivery %s: %s", configMap.Name, err)
+		}
+	}
+	if body, ok := configMap.Data[deliveryBodyKey]; ok {
+		record.Body = []byte(body)
+	}
+
+	return record, nil
+}
+
+func (store *ConfigMapDeliveryStore) configMap(record *DeliveryRecord) (*corev1.ConfigMap, error) {
+	header := http.Header{}
+	for key, values := range record.Header {
+		header[key] = values
+	}
+	for _, key := range secretHeaders {
+		header.Del(key)
+	}
+
+	stored := *record
+	stored.Truncated = store.BodySize > 0 && len(record.Body) > store.BodySize
+
+	data := map[string]string{}
+	recordJSON, err := json.Marshal(&stored)
+	if err != nil {
+		return nil, err
+	}
+	data[deliveryRecordKey] = string(recordJSON)
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	data[deliveryHeaderKey] = string(headerJSON)
+
+	if !stored.Truncated {
+		data[deliveryBodyKey] = string(record.Body)
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DeliveryConfigMapName(store.Name, record.ID),
+			Namespace: store.Namespace,
+			Labels: map[string]string{
+				tekton.LabelGitHook: store.Name,
+				LabelDelivery:       "true",
+			},
+		},
+		Data: data,
+	}
+	if store.Owner != nil {
+		configMap.OwnerReferences = []metav1.OwnerReference{*store.Owner}
+	}
+	return configMap, nil
+}
+
+// prune deletes the oldest deliveries over the limit
+func (store *ConfigMapDeliveryStore) prune() error {
+	limit := store.Limit
+	if limit <= 0 {
+		limit = DefaultDeliveryLimit
+	}
+
+	configMaps := store.Client.ConfigMaps(store.Namespace)
+	selector := labels.SelectorFromSet(map[string]string{tekton.LabelGitHook: store.Name, LabelDelivery: "true"}).String()
+	list, err := configMaps.List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("failed to list stored deliveries: %s", err)
+	}
+	if len(list.Items) <= limit {
+		return nil
+	}
+
+	sort.SliceStable(list.Items, func(i, j int) bool {
+		return receivedAt(&list.Items[i]).Before(receivedAt(&list.Items[j]))
+	})
+	for _, configMap := range list.Items[:len(list.Items)-limit] {
+		if err := configMaps.Delete(configMap.Name, &metav1.DeleteOptions{}); err != nil && !apierrs.IsNotFound(err) {
+			return fmt.Errorf("failed to delete stored delivery %s: %s", configMap.Name, err)
+		}
+	}
+	return nil
+}
+
+// receivedAt returns when a stored delivery was received, the creation time
+// of its ConfigMap when the record is invalid
+func receivedAt(configMap *corev1.ConfigMap) time.Time {
+	record := &DeliveryRecord{}
+	if err := json.Unmarshal([]byte(configMap.Data[deliveryRecordKey]), record); err != nil {
+		return configMap.CreationTimestamp.Time
+	}
+	return record.Received
+}
@@ -0,0 +1,88 @@
+package githook
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestConfigMapDeliveryStoreSavesAndLoads(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := &ConfigMapDeliveryStore{Client: client.CoreV1(), Namespace: "default", Name: "hook"}
+
+	header := http.Header{}
+	header.Set("X-Gitlab-Event", "Push Hook")
+	header.Set("X-Gitlab-Token", "secret")
+	record := &DeliveryRecord{
+		ID:       "6d2c0c5c-59f3-4ab4-8f6d-0d1c0f3b2a1e",
+		Event:    "push",
+		Received: time.Unix(1600000000, 0).UTC(),
+		Decision: "accepted",
+		Runs:     []string{"hook-abc12"},
+		Header:   header,
+		Body:     []byte(`{"ref": "refs/heads/main"}`),
+	}
+	if err := store.Save(record); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	loaded, err := store.Load(record.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if loaded.Header.Get("X-Gitlab-Token") != "" {
+		t.Error("expected the secret token not to be stored")
+	}
+	if loaded.Header.Get("X-Gitlab-Event") != "Push Hook" || string(loaded.Body) != string(record.Body) {
+		t.Errorf("unexpected delivery %+v", loaded)
+	}
+	if !reflect.DeepEqual(loaded.Runs, record.Runs) || !loaded.Received.Equal(record.Received) {
+		t.Errorf("expected runs %v received %s, got %v %s", record.Runs, record.Received, loaded.Runs, loaded.Received)
+	}
+
+	configMap, err := client.CoreV1().ConfigMaps("default").Get("hook-delivery-6d2c0c5c-59f3-4ab4-8f6d-0d1c0f3b2a1e", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if configMap.Labels[LabelDelivery] != "true" {
+		t.Errorf("unexpected labels %v", configMap.Labels)
+	}
+}
+
+func TestConfigMapDeliveryStoreKeepsLatestDeliveries(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	store := &ConfigMapDeliveryStore{Client: client.CoreV1(), Namespace: "default", Name: "hook", Limit: 2, BodySize: 8}
+
+	received := time.Now()
+	for i := 0; i < 3; i++ {
+		record := &DeliveryRecord{ID: fmt.Sprintf("d%d", i), Event: "push", Received: received.Add(time.Duration(i) * time.Second), Body: []byte(`{}`)}
+		if err := store.Save(record); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if _, err := store.Load("d0"); err == nil {
+		t.Error("expected the oldest delivery to be deleted")
+	}
+	for _, id := range []string{"d1", "d2"} {
+		if _, err := store.Load(id); err != nil {
+			t.Errorf("expected delivery %s to be stored: %s", id, err)
+		}
+	}
+
+	if err := store.Save(&DeliveryRecord{ID: "large", Event: "push", Received: received.Add(time.Minute), Body: []byte(`{"large": true}`)}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	large, err := store.Load("large")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !large.Truncated || large.Body != nil {
+		t.Errorf("expected the large payload not to be stored, got %+v", large)
+	}
+}
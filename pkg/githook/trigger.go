@@ -0,0 +1,122 @@
+package githook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/zhd173/githook/pkg/filter"
+	"github.com/zhd173/githook/pkg/model"
+	"github.com/zhd173/githook/pkg/tekton"
+	"sigs.k8s.io/yaml"
+)
+
+// TriggerConfig is the configuration of a trigger passed to the receiver, in
+// the shape of the GitHook triggers
+type TriggerConfig struct {
+	// Name is empty for the run spec of the GitHook itself
+	Name       string          `json:"name,omitempty"`
+	EventTypes []string        `json:"eventTypes,omitempty"`
+	When       []string        `json:"when,omitempty"`
+	Params     []tekton.Param  `json:"params,omitempty"`
+	RunSpec    json.RawMessage `json:"runSpec,omitempty"`
+	// RunTemplate is the path of the mounted run spec template, used instead of RunSpec
+	RunTemplate string `json:"runTemplate,omitempty"`
+}
+
+// Trigger creates a PipelineRun for the events of its types satisfying its
+// when expressions
+type Trigger struct {
+	Name string
+	// EventTypes selects the events of the trigger, all events when empty
+	EventTypes  []string
+	When        *filter.When
+	Params      []tekton.Param
+	RunSpecJSON string
+	// RunTemplate is read for every event, so that template changes apply
+	// without restarting the receiver
+	RunTemplate string
+}
+
+// NewTrigger compiles the when expressions of a trigger configuration
+func NewTrigger(config TriggerConfig) (*Trigger, error) {
+	when, err := filter.Compile(config.When)
+	if err != nil {
+		return nil, fmt.Errorf("trigger %s: %s", config.Name, err)
+	}
+
+	return &Trigger{
+		Name:        config.Name,
+		EventTypes:  config.EventTypes,
+		When:        when,
+		Params:      config.Params,
+		RunSpecJSON: string(config.RunSpec),
+		RunTemplate: config.RunTemplate,
+	}, nil
+}
+
+// runSpecJSON returns the run spec of the trigger, loading its template
+func (trigger *Trigger) runSpecJSON() (string, error) {
+	if trigger.RunTemplate == "" {
+		return trigger.RunSpecJSON, nil
+	}
+
+	template, err := ioutil.ReadFile(trigger.RunTemplate)
+	if err != nil {
+		return "", fmt.Errorf("failed to read run template: %s", err)
+	}
+
+	runSpecJSON, err := yaml.YAMLToJSON(template)
+	if err != nil {
+		return "", fmt.Errorf("invalid run template %s: %s", trigger.RunTemplate, err)
+	}
+
+	return string(runSpecJSON), nil
+}
+
+// handles reports whether the event is one of the types of the trigger
+func (trigger *Trigger) handles(event *model.GitEvent) bool {
+	if len(trigger.EventTypes) == 0 {
+		return true
+	}
+
+	for _, eventType := range trigger.EventTypes {
+		if eventType == event.Type {
+			return true
+		}
+	}
+
+	return false
+}
+
+// prefix returns the name prefix of the PipelineRuns of the trigger
+func (trigger *Trigger) prefix(name string) string {
+	if trigger.Name == "" {
+		return name
+	}
+	return fmt.Sprintf("%s-%s", name, trigger.Name)
+}
+
+// mergeParams appends the params of the trigger to the params of the GitHook,
+// replacing the GitHook params of the same name
+func (trigger *Trigger) mergeParams(params []tekton.Param) []tekton.Param {
+	if len(trigger.Params) == 0 {
+		return params
+	}
+
+	merged := make([]tekton.Param, 0, len(params)+len(trigger.Params))
+	for _, param := range params {
+		replaced := false
+		for _, triggerParam := range trigger.Params {
+			if triggerParam.Name == param.Name {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, param)
+		}
+	}
+
+	return append(merged, trigger.Params...)
+}
@@ -0,0 +1,78 @@
+package githook
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zhd173/githook/pkg/model"
+	"github.com/zhd173/githook/pkg/tekton"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestTriggerHandles(t *testing.T) {
+	trigger, err := NewTrigger(TriggerConfig{Name: "test", EventTypes: []string{"pull_request"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if !trigger.handles(&model.GitEvent{Type: "pull_request"}) || trigger.handles(&model.GitEvent{Type: "push"}) {
+		t.Error("expected the trigger to handle only pull requests")
+	}
+	if all := (&Trigger{}); !all.handles(&model.GitEvent{Type: "push"}) {
+		t.Error("expected a trigger without event types to handle all events")
+	}
+}
+
+func TestTriggerMergeParams(t *testing.T) {
+	trigger := &Trigger{Params: []tekton.Param{{Name: "target", Value: "release"}}}
+
+	merged := trigger.mergeParams([]tekton.Param{{Name: "target", Value: "test"}, {Name: "repo", Value: "app"}})
+
+	expected := []tekton.Param{{Name: "repo", Value: "app"}, {Name: "target", Value: "release"}}
+	if !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected params %v, got %v", expected, merged)
+	}
+}
+
+func TestNewTriggerRejectsInvalidWhen(t *testing.T) {
+	if _, err := NewTrigger(TriggerConfig{Name: "build", When: []string{"event.ref =="}}); err == nil {
+		t.Error("expected error for invalid when expression")
+	}
+}
+
+func TestHandleEventReportsSkippedTrigger(t *testing.T) {
+	trigger, err := NewTrigger(TriggerConfig{
+		Name:       "release",
+		EventTypes: []string{"push"},
+		When:       []string{`event.tag != ""`},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	recorder := record.NewFakeRecorder(1)
+	ra := &ReceiveAdapter{
+		Provider: "github",
+		Triggers: []*Trigger{trigger},
+		Recorder: recorder,
+		Source:   &corev1.ObjectReference{Kind: "GitHook", Namespace: "default", Name: "hook"},
+	}
+
+	ra.HandleEvent(&model.GitEvent{
+		Type:       "push",
+		DeliveryID: "delivery-1",
+		Ref:        "refs/heads/main",
+		Repository: model.GitRepository{CloneURL: "https://github.com/owner/repo.git"},
+	}, nil)
+
+	select {
+	case event := <-recorder.Events:
+		expected := `Normal EventSkipped Skipped push delivery delivery-1 for trigger release: when expression "event.tag != \"\"" is false`
+		if event != expected {
+			t.Errorf("unexpected event %q", event)
+		}
+	default:
+		t.Error("expected an EventSkipped event")
+	}
+}
@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "githook"
+
+// Webhook delivery decisions of the receive adapter
+const (
+	DecisionAccepted     = "accepted"
+	DecisionFiltered     = "filtered"
+	DecisionBadSignature = "bad_signature"
+	DecisionDuplicate    = "duplicate"
+	DecisionInvalid      = "invalid"
+	DecisionQueueFull    = "queue_full"
+	DecisionShuttingDown = "shutting_down"
+)
+
+// Rate limit outcomes of the accepted deliveries of the receive adapter
+const (
+	RateLimitDebounced = "debounced"
+	RateLimitCoalesced = "coalesced"
+	RateLimitDropped   = "rate_limited"
+)
+
+// CloudEvent results of the receive adapter
+const (
+	CloudEventSent   = "sent"
+	CloudEventFailed = "failed"
+)
+
+// PipelineRun creation results of the receive adapter
+const (
+	PipelineRunCreated = "created"
+	PipelineRunFailed  = "failed"
+)
+
+var (
+	// Reconciles counts the GitHook reconciles by result
+	Reconciles = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconcile_total",
+		Help:      "Number of GitHook reconciles by result.",
+	}, []string{"result"})
+
+	// ProviderRequests counts the provider api requests by provider, operation and status code
+	ProviderRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_requests_total",
+		Help:      "Number of git provider api requests by provider, operation and status code, each retry counted. The code is error for requests that got no response.",
+	}, []string{"provider", "operation", "code"})
+
+	// ProviderRequestDuration observes the latency of provider api requests
+	ProviderRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_request_duration_seconds",
+		Help:      "Latency of git provider api requests by provider and operation, each retry observed separately.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider", "operation"})
+
+	// WebhooksReceived counts the webhook deliveries by provider, event and decision
+	WebhooksReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhooks_received_total",
+		Help:      "Number of webhook deliveries received by provider, event and decision.",
+	}, []string{"provider", "event", "decision"})
+
+	// PipelineRuns counts the PipelineRuns created for webhook deliveries by result
+	PipelineRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipelineruns_total",
+		Help:      "Number of PipelineRuns created for webhook deliveries by result.",
+	}, []string{"result"})
+
+	// CloudEvents counts the CloudEvents sent to the sink by result
+	CloudEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cloudevents_total",
+		Help:      "Number of CloudEvents sent to the sink by result.",
+	}, []string{"result"})
+
+	// RateLimitedEvents counts the accepted deliveries debounced, coalesced
+	// into a later delivery or dropped by the rate limits
+	RateLimitedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_events_total",
+		Help:      "Number of accepted webhook deliveries debounced, coalesced into a later delivery or dropped over the rate limit, by outcome.",
+	}, []string{"outcome"})
+
+	// QueuedDeliveries is the number of deliveries waiting for a worker
+	QueuedDeliveries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queued_deliveries",
+		Help:      "Number of webhook deliveries waiting in the queue of the receiver.",
+	})
+
+	// EventToPipelineRunDuration observes the latency from receiving a delivery to creating its PipelineRun
+	EventToPipelineRunDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "event_to_pipelinerun_seconds",
+		Help:      "Latency from receiving a webhook delivery to creating its PipelineRun.",
+		Buckets:   prometheus.DefBuckets,
+	})
+)
+
+// RegisterController registers the collectors of the controller
+func RegisterController(registerer prometheus.Registerer) {
+	registerer.MustRegister(Reconciles, ProviderRequests, ProviderRequestDuration)
+}
+
+// RegisterReceiver registers the collectors of the receive adapter
+func RegisterReceiver(registerer prometheus.Registerer) {
+	registerer.MustRegister(WebhooksReceived, PipelineRuns, CloudEvents, RateLimitedEvents, QueuedDeliveries, EventToPipelineRunDuration,
+		ProviderRequests, ProviderRequestDuration)
+}
+
+// ObserveProviderRequest records a provider api request, code is the status
+// code of its response, 0 when the request got no response
+func ObserveProviderRequest(provider, operation string, code int, duration time.Duration) {
+	label := "error"
+	if code != 0 {
+		label = strconv.Itoa(code)
+	}
+
+	ProviderRequests.WithLabelValues(provider, operation, label).Inc()
+	ProviderRequestDuration.WithLabelValues(provider, operation).Observe(duration.Seconds())
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRegisterReceiverProviderRequests(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	RegisterReceiver(registry)
+
+	ObserveProviderRequest("github", "post_statuses", 201, time.Second)
+	ObserveProviderRequest("github", "post_statuses", 0, time.Second)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	codes := map[string]float64{}
+	observed := false
+	for _, family := range families {
+		switch family.GetName() {
+		case "githook_provider_requests_total":
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "code" {
+						codes[label.GetValue()] += metric.GetCounter().GetValue()
+					}
+				}
+			}
+		case "githook_provider_request_duration_seconds":
+			observed = true
+		}
+	}
+
+	if codes["201"] != 1 || codes["error"] != 1 {
+		t.Errorf("expected a 201 and an error request, got %v", codes)
+	}
+	if !observed {
+		t.Error("expected the receiver to register the provider request latency")
+	}
+}
@@ -0,0 +1,18 @@
+package model
+
+// Commit status states shared by the git providers
+const (
+	CommitStatusPending = "pending"
+	CommitStatusSuccess = "success"
+	CommitStatusFailure = "failure"
+	CommitStatusError   = "error"
+)
+
+// CommitStatus keeps the status reported for a commit
+type CommitStatus struct {
+	SHA         string
+	State       string
+	TargetURL   string
+	Description string
+	Context     string
+}
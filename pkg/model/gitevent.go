@@ -0,0 +1,167 @@
+package model
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// GitEvent is a webhook event of a git provider in a provider neutral shape
+type GitEvent struct {
+	Provider   string `json:"provider"`
+	DeliveryID string `json:"deliveryId,omitempty"`
+	// Type is the event type, named as in the GitHook eventTypes
+	Type string `json:"type"`
+	// Action qualifies the event, such as opened or synchronize for pull requests
+	Action string `json:"action,omitempty"`
+
+	Repository GitRepository `json:"repository"`
+	// Ref is the full ref the event happened on, such as refs/heads/master
+	Ref    string `json:"ref,omitempty"`
+	Before string `json:"before,omitempty"`
+	// After is the commit the event points to, the head commit for pull requests
+	After string `json:"after,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+	// Label is the label added or removed by pull request label events
+	Label string `json:"label,omitempty"`
+
+	Sender  GitUser     `json:"sender"`
+	Commits []GitCommit `json:"commits,omitempty"`
+
+	PullRequest *GitPullRequest `json:"pullRequest,omitempty"`
+	Issue       *GitIssue       `json:"issue,omitempty"`
+	Comment     *GitComment     `json:"comment,omitempty"`
+
+	// Payload is the raw payload delivered by the git provider
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// GitRepository is the repository of a git event
+type GitRepository struct {
+	FullName      string `json:"fullName"`
+	Name          string `json:"name"`
+	Owner         string `json:"owner"`
+	CloneURL      string `json:"cloneUrl"`
+	URL           string `json:"url,omitempty"`
+	DefaultBranch string `json:"defaultBranch,omitempty"`
+}
+
+// GitUser is the user or commit author of a git event
+type GitUser struct {
+	Login string `json:"login,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// GitCommit is a commit pushed by a git event
+type GitCommit struct {
+	ID       string   `json:"id"`
+	Message  string   `json:"message,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	Author   GitUser  `json:"author"`
+	Added    []string `json:"added,omitempty"`
+	Modified []string `json:"modified,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+}
+
+// GitPullRequest is the pull request, or gitlab merge request, of a git event
+type GitPullRequest struct {
+	Number int    `json:"number"`
+	Title  string `json:"title,omitempty"`
+	State  string `json:"state,omitempty"`
+	URL    string `json:"url,omitempty"`
+	// HeadRef and BaseRef are branch names
+	HeadRef string `json:"headRef,omitempty"`
+	HeadSHA string `json:"headSha,omitempty"`
+	// HeadCloneURL is the clone url of the head repository, which differs from the event repository for forks
+	HeadCloneURL string   `json:"headCloneUrl,omitempty"`
+	BaseRef      string   `json:"baseRef,omitempty"`
+	BaseSHA      string   `json:"baseSha,omitempty"`
+	Author       GitUser  `json:"author"`
+	Merged       bool     `json:"merged,omitempty"`
+	Labels       []string `json:"labels,omitempty"`
+}
+
+// GitIssue is the issue of a git event
+type GitIssue struct {
+	Number int     `json:"number"`
+	Title  string  `json:"title,omitempty"`
+	State  string  `json:"state,omitempty"`
+	URL    string  `json:"url,omitempty"`
+	Author GitUser `json:"author"`
+	// PullRequest reports whether the issue is a pull request, as for comments on pull requests
+	PullRequest bool `json:"pullRequest,omitempty"`
+}
+
+// GitComment is the comment of a git event
+type GitComment struct {
+	ID     int64   `json:"id"`
+	Body   string  `json:"body"`
+	URL    string  `json:"url,omitempty"`
+	Author GitUser `json:"author"`
+}
+
+// CloneURL returns the url to clone the code of the event from, the head
+// repository for pull requests
+func (event *GitEvent) CloneURL() string {
+	if event.PullRequest != nil && event.PullRequest.HeadCloneURL != "" {
+		return event.PullRequest.HeadCloneURL
+	}
+	return event.Repository.CloneURL
+}
+
+// Revision prefers the commit sha and falls back to the short name of the ref
+func (event *GitEvent) Revision() string {
+	if event.After != "" {
+		return event.After
+	}
+
+	ref := strings.TrimPrefix(event.Ref, "refs/heads/")
+	return strings.TrimPrefix(ref, "refs/tags/")
+}
+
+// ChangedFiles returns the files added, modified or removed by the commits of the event
+func (event *GitEvent) ChangedFiles() []string {
+	files := map[string]bool{}
+	for _, commit := range event.Commits {
+		for _, changed := range [][]string{commit.Added, commit.Modified, commit.Removed} {
+			for _, file := range changed {
+				files[file] = true
+			}
+		}
+	}
+
+	changedFiles := make([]string, 0, len(files))
+	for file := range files {
+		changedFiles = append(changedFiles, file)
+	}
+	sort.Strings(changedFiles)
+
+	return changedFiles
+}
+
+// BranchRef returns the full ref of a branch name
+func BranchRef(branch string) string {
+	if branch == "" || strings.HasPrefix(branch, "refs/") {
+		return branch
+	}
+	return "refs/heads/" + branch
+}
+
+// TagRef returns the full ref of a tag name
+func TagRef(tag string) string {
+	if tag == "" || strings.HasPrefix(tag, "refs/") {
+		return tag
+	}
+	return "refs/tags/" + tag
+}
+
+// HasLabel reports whether the pull request has a label
+func (pr *GitPullRequest) HasLabel(label string) bool {
+	for _, name := range pr.Labels {
+		if name == label {
+			return true
+		}
+	}
+	return false
+}
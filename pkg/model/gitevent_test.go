@@ -0,0 +1,47 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGitEventRevision(t *testing.T) {
+	tests := []struct {
+		event    GitEvent
+		revision string
+	}{
+		{GitEvent{Ref: "refs/heads/master", After: "abc"}, "abc"},
+		{GitEvent{Ref: "refs/heads/master"}, "master"},
+		{GitEvent{Ref: "refs/tags/v1.0"}, "v1.0"},
+	}
+
+	for _, test := range tests {
+		if revision := test.event.Revision(); revision != test.revision {
+			t.Errorf("expected revision %q for %+v, got %q", test.revision, test.event, revision)
+		}
+	}
+}
+
+func TestGitEventCloneURL(t *testing.T) {
+	event := GitEvent{Repository: GitRepository{CloneURL: "https://github.com/owner/repo.git"}}
+	if event.CloneURL() != "https://github.com/owner/repo.git" {
+		t.Errorf("unexpected clone url %q", event.CloneURL())
+	}
+
+	event.PullRequest = &GitPullRequest{HeadCloneURL: "https://github.com/fork/repo.git"}
+	if event.CloneURL() != "https://github.com/fork/repo.git" {
+		t.Errorf("expected the head repository for pull requests, got %q", event.CloneURL())
+	}
+}
+
+func TestGitEventChangedFiles(t *testing.T) {
+	event := GitEvent{Commits: []GitCommit{
+		{Added: []string{"b.go"}, Modified: []string{"a.go"}},
+		{Modified: []string{"a.go"}, Removed: []string{"c.go"}},
+	}}
+
+	expected := []string{"a.go", "b.go", "c.go"}
+	if files := event.ChangedFiles(); !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected changed files %v, got %v", expected, files)
+	}
+}
@@ -1,5 +1,23 @@
 package model
 
+// TransportOptions keeps the settings of the http transport used to call the provider api
+type TransportOptions struct {
+	// CABundle holds PEM encoded certificates trusted in addition to the system roots
+	CABundle           []byte
+	InsecureSkipVerify bool
+	ProxyURL           string
+	NoProxy            []string
+}
+
+// GithubAppOptions keeps the credentials used to authenticate as a GitHub App
+type GithubAppOptions struct {
+	AppID int64
+	// InstallationID is looked up from the repository when zero
+	InstallationID int64
+	// PrivateKey holds the PEM encoded private key of the app
+	PrivateKey []byte
+}
+
 // HookOptions keeps webhook options
 type HookOptions struct {
 	AccessToken string
@@ -10,4 +28,10 @@ type HookOptions struct {
 	URL         string
 	Owner       string
 	Events      []string
+	SSLVerify   bool
+	Repository  *Repository
+	Transport   *TransportOptions
+	GithubApp   *GithubAppOptions
+	// Marker identifies webhooks registered for the GitHook when the receiver URL changed
+	Marker string
 }
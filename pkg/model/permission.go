@@ -0,0 +1,21 @@
+package model
+
+// Repository permissions of a user, shared by the git providers
+const (
+	PermissionNone  = "none"
+	PermissionRead  = "read"
+	PermissionWrite = "write"
+	PermissionAdmin = "admin"
+)
+
+var permissionLevels = map[string]int{
+	PermissionNone:  0,
+	PermissionRead:  1,
+	PermissionWrite: 2,
+	PermissionAdmin: 3,
+}
+
+// PermissionAllows reports whether a permission includes the required one
+func PermissionAllows(permission, required string) bool {
+	return permissionLevels[permission] >= permissionLevels[required]
+}
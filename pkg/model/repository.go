@@ -0,0 +1,163 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Repository locates a git repository hosted by a git provider
+type Repository struct {
+	Provider string
+	// Scheme and Host (with optional port) of the provider web and api endpoints
+	Scheme string
+	Host   string
+	// ContextPath is set when the provider is served below a sub path, e.g. /gitlab
+	ContextPath string
+	// Namespace is the owner of the repository, nested for gitlab subgroups
+	Namespace string
+	Name      string
+}
+
+// ParseRepository parses http(s), ssh and scp-like project urls. serverURL is
+// optional and tells where the provider is served, which is required when the
+// provider runs below a context path or its api is not served from the ssh host.
+func ParseRepository(provider, projectURL, serverURL string) (*Repository, error) {
+	u, err := parseProjectURL(projectURL)
+	if err != nil {
+		return nil, err
+	}
+
+	repo := &Repository{
+		Provider: provider,
+		Scheme:   u.Scheme,
+		Host:     u.Host,
+	}
+
+	projectPath := u.Path
+	if u.Scheme != "http" && u.Scheme != "https" {
+		// ssh 地址的端口不是 api 端口
+		repo.Scheme = "https"
+		repo.Host = u.Hostname()
+	}
+
+	if serverURL != "" {
+		server, err := url.Parse(serverURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server url %s: %s", serverURL, err)
+		}
+		repo.Scheme = server.Scheme
+		repo.Host = server.Host
+		repo.ContextPath = strings.TrimSuffix(server.Path, "/")
+
+		if (u.Scheme == "http" || u.Scheme == "https") && strings.HasPrefix(projectPath, repo.ContextPath+"/") {
+			projectPath = strings.TrimPrefix(projectPath, repo.ContextPath)
+		}
+	}
+
+	namespace, name, err := splitProjectPath(provider, projectPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid project url %s: %s", projectURL, err)
+	}
+	repo.Namespace = namespace
+	repo.Name = name
+
+	return repo, nil
+}
+
+// parseProjectURL converts scp-like urls such as git@host:owner/repo.git to ssh urls
+func parseProjectURL(projectURL string) (*url.URL, error) {
+	if !strings.Contains(projectURL, "://") {
+		colon := strings.Index(projectURL, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid project url %s", projectURL)
+		}
+		projectURL = "ssh://" + projectURL[:colon] + "/" + strings.TrimPrefix(projectURL[colon+1:], "/")
+	}
+
+	u, err := url.Parse(projectURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid project url %s: missing host", projectURL)
+	}
+
+	return u, nil
+}
+
+func splitProjectPath(provider, projectPath string) (string, string, error) {
+	projectPath = strings.Trim(projectPath, "/")
+
+	// gitlab 页面地址，如 group/project/-/tree/master
+	if i := strings.Index(projectPath, "/-/"); i >= 0 {
+		projectPath = projectPath[:i]
+	}
+
+	segments := []string{}
+	for _, segment := range strings.Split(projectPath, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	// 只有 gitlab 支持嵌套的 group，其余平台忽略 tree/master 等页面路径
+	if provider != "gitlab" && len(segments) > 2 {
+		segments = segments[:2]
+	}
+
+	if len(segments) < 2 {
+		return "", "", fmt.Errorf("expected <owner>/<repository> in path %q", projectPath)
+	}
+
+	name := strings.TrimSuffix(segments[len(segments)-1], ".git")
+	if name == "" {
+		return "", "", fmt.Errorf("missing repository name in path %q", projectPath)
+	}
+
+	return strings.Join(segments[:len(segments)-1], "/"), name, nil
+}
+
+// FullName returns the namespaced repository path, e.g. group/subgroup/project
+func (repo *Repository) FullName() string {
+	return repo.Namespace + "/" + repo.Name
+}
+
+// Hostname returns the provider host without port
+func (repo *Repository) Hostname() string {
+	u := url.URL{Host: repo.Host}
+	return u.Hostname()
+}
+
+// BaseURL returns the web root of the provider
+func (repo *Repository) BaseURL() string {
+	return fmt.Sprintf("%s://%s%s", repo.Scheme, repo.Host, repo.ContextPath)
+}
+
+// CloneURL returns the http(s) clone url of the repository
+func (repo *Repository) CloneURL() string {
+	return fmt.Sprintf("%s/%s.git", repo.BaseURL(), repo.FullName())
+}
+
+// IsPublicGithubHost reports whether host is one of the hosts of github.com
+// rather than a GitHub Enterprise Server
+func IsPublicGithubHost(host string) bool {
+	return host == "github.com" || host == "www.github.com" || host == "api.github.com"
+}
+
+// APIURL returns the base url of the provider rest api
+func (repo *Repository) APIURL() string {
+	switch repo.Provider {
+	case "github":
+		if IsPublicGithubHost(repo.Hostname()) {
+			return "https://api.github.com/"
+		}
+		return repo.BaseURL() + "/api/v3/"
+	case "gitlab":
+		return repo.BaseURL() + "/api/v4/"
+	case "gogs":
+		return repo.BaseURL() + "/api/v1/"
+	default:
+		return repo.BaseURL() + "/"
+	}
+}
@@ -0,0 +1,58 @@
+package model
+
+import "testing"
+
+func TestParseRepository(t *testing.T) {
+	tests := []struct {
+		provider, projectURL, serverURL string
+		fullName, cloneURL, apiURL      string
+	}{
+		{"github", "https://github.com/owner/repo", "",
+			"owner/repo", "https://github.com/owner/repo.git", "https://api.github.com/"},
+		{"github", "https://github.com/owner/repo.git", "",
+			"owner/repo", "https://github.com/owner/repo.git", "https://api.github.com/"},
+		{"github", "https://github.com/owner/repo/tree/master", "",
+			"owner/repo", "https://github.com/owner/repo.git", "https://api.github.com/"},
+		{"github", "git@github.com:owner/repo.git", "",
+			"owner/repo", "https://github.com/owner/repo.git", "https://api.github.com/"},
+		{"github", "https://www.github.com/owner/repo", "",
+			"owner/repo", "https://www.github.com/owner/repo.git", "https://api.github.com/"},
+		{"github", "https://ghe.example.com/owner/repo", "",
+			"owner/repo", "https://ghe.example.com/owner/repo.git", "https://ghe.example.com/api/v3/"},
+		{"gitlab", "https://gitlab.example.com:8443/group/sub/project.git", "",
+			"group/sub/project", "https://gitlab.example.com:8443/group/sub/project.git", "https://gitlab.example.com:8443/api/v4/"},
+		{"gitlab", "https://gitlab.example.com/group/project/-/tree/master", "",
+			"group/project", "https://gitlab.example.com/group/project.git", "https://gitlab.example.com/api/v4/"},
+		{"gitlab", "https://example.com/gitlab/group/sub/project", "https://example.com/gitlab",
+			"group/sub/project", "https://example.com/gitlab/group/sub/project.git", "https://example.com/gitlab/api/v4/"},
+		{"gitlab", "ssh://git@example.com:2222/group/sub/project.git", "https://example.com/gitlab/",
+			"group/sub/project", "https://example.com/gitlab/group/sub/project.git", "https://example.com/gitlab/api/v4/"},
+		{"gogs", "http://gogs.example.com:3000/owner/repo", "",
+			"owner/repo", "http://gogs.example.com:3000/owner/repo.git", "http://gogs.example.com:3000/api/v1/"},
+	}
+
+	for _, test := range tests {
+		repo, err := ParseRepository(test.provider, test.projectURL, test.serverURL)
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", test.projectURL, err)
+			continue
+		}
+		if repo.FullName() != test.fullName {
+			t.Errorf("%s: expected full name %s, got %s", test.projectURL, test.fullName, repo.FullName())
+		}
+		if repo.CloneURL() != test.cloneURL {
+			t.Errorf("%s: expected clone url %s, got %s", test.projectURL, test.cloneURL, repo.CloneURL())
+		}
+		if repo.APIURL() != test.apiURL {
+			t.Errorf("%s: expected api url %s, got %s", test.projectURL, test.apiURL, repo.APIURL())
+		}
+	}
+}
+
+func TestParseRepositoryInvalid(t *testing.T) {
+	for _, projectURL := range []string{"https://github.com/owner", "https://github.com/", "owner/repo", "git@github.com:"} {
+		if _, err := ParseRepository("github", projectURL, ""); err == nil {
+			t.Errorf("%s: expected an error", projectURL)
+		}
+	}
+}
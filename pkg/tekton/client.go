@@ -6,10 +6,19 @@ import (
 
 	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
 	"github.com/tektoncd/pipeline/pkg/client/clientset/versioned"
+	"github.com/zhd173/githook/pkg/model"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
+// Labels of the PipelineRuns created for git events
+const (
+	LabelGitHook     = "githook.tools/githook"
+	LabelTrigger     = "githook.tools/trigger"
+	LabelPullRequest = "githook.tools/pull-request"
+)
+
 // Client provides tekton client
 type Client struct {
 	tekton *versioned.Clientset
@@ -19,10 +28,11 @@ type Client struct {
 type PipelineOptions struct {
 	Namespace   string
 	Prefix      string
-	GitURL      string
-	GitRevision string
-	GitCommit   string
 	RunSpecJSON string
+	// Params are the evaluated params appended to the params of the run spec
+	Params []v1alpha1.Param
+	// Labels are added to the PipelineRun
+	Labels map[string]string
 }
 
 // New creates new tekton client instance
@@ -40,14 +50,15 @@ func New() (*Client, error) {
 	}, nil
 }
 
-func (client *Client) getOrCreateGitPipelineResource(namespace, prefix, gitURL, revision string) (string, error) {
-
+// findGitPipelineResource returns the git PipelineResource of a repository,
+// empty when there is none
+func (client *Client) findGitPipelineResource(namespace, gitURL string) (string, error) {
 	tektonClient := client.tekton.TektonV1alpha1()
 	// search for existing resource
 	list, err := tektonClient.PipelineResources(namespace).List(metav1.ListOptions{})
 
 	if err != nil {
-		return "", fmt.Errorf("failed to list pipeline resource: %s", err)
+		return "", fmt.Errorf("failed to list pipeline resource: %w", err)
 	}
 
 	for _, item := range list.Items {
@@ -59,10 +70,19 @@ func (client *Client) getOrCreateGitPipelineResource(namespace, prefix, gitURL,
 		}
 	}
 
+	return "", nil
+}
+
+func (client *Client) getOrCreateGitPipelineResource(namespace, prefix, gitURL, revision string) (string, error) {
+	name, err := client.findGitPipelineResource(namespace, gitURL)
+	if err != nil || name != "" {
+		return name, err
+	}
+
 	// create new
 	gitResource := &v1alpha1.PipelineResource{
 		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: fmt.Sprintf("%s-git-source-", prefix),
+			GenerateName: gitPipelineResourcePrefix(prefix),
 			Namespace:    namespace,
 		},
 		Spec: v1alpha1.PipelineResourceSpec{
@@ -80,24 +100,50 @@ func (client *Client) getOrCreateGitPipelineResource(namespace, prefix, gitURL,
 		},
 	}
 
-	gitResource, err = tektonClient.PipelineResources(namespace).Create(gitResource)
+	gitResource, err = client.tekton.TektonV1alpha1().PipelineResources(namespace).Create(gitResource)
 
 	if err != nil {
-		return "", fmt.Errorf("failed to create pipeline resource: %s", err)
+		return "", fmt.Errorf("failed to create pipeline resource: %w", err)
 	}
 
 	return gitResource.Name, nil
 }
 
-// CreatePipelineRun creates new pipeline run
-func (client *Client) CreatePipelineRun(options PipelineOptions) (*v1alpha1.PipelineRun, error) {
-	return client.generatePipelineRun(options)
+func gitPipelineResourcePrefix(prefix string) string {
+	return fmt.Sprintf("%s-git-source-", prefix)
+}
+
+// CreatePipelineRun creates new pipeline run for a git event
+func (client *Client) CreatePipelineRun(options PipelineOptions, event *model.GitEvent) (*v1alpha1.PipelineRun, error) {
+	pipelineRun, err := client.generatePipelineRun(options, event, false)
+	if err != nil {
+		return nil, err
+	}
+
+	tektonClient := client.tekton.TektonV1alpha1()
+
+	pipelineRun, err = tektonClient.PipelineRuns(options.Namespace).Create(pipelineRun)
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating pipeline run: %w", err)
+	}
+
+	return pipelineRun, nil
+}
+
+// RenderPipelineRun returns the pipeline run CreatePipelineRun would create
+// for a git event, without creating it nor its git pipeline resource
+func (client *Client) RenderPipelineRun(options PipelineOptions, event *model.GitEvent) (*v1alpha1.PipelineRun, error) {
+	return client.generatePipelineRun(options, event, true)
 }
 
-func (client *Client) generatePipelineRun(options PipelineOptions) (*v1alpha1.PipelineRun, error) {
+// generatePipelineRun renders the pipeline run of a git event. The git
+// pipeline resource of the repository is created when missing, unless
+// dryRun, which references it by the prefix of its generated name instead.
+func (client *Client) generatePipelineRun(options PipelineOptions, event *model.GitEvent, dryRun bool) (*v1alpha1.PipelineRun, error) {
 
 	pipelineRunSpec := &v1alpha1.PipelineRunSpec{}
-	err := json.Unmarshal([]byte(replaceVars(options.RunSpecJSON, options)), pipelineRunSpec)
+	err := json.Unmarshal([]byte(replaceVars(options.RunSpecJSON, event)), pipelineRunSpec)
 
 	if err != nil {
 		return nil, err
@@ -105,13 +151,25 @@ func (client *Client) generatePipelineRun(options PipelineOptions) (*v1alpha1.Pi
 
 	pipelineRun := &v1alpha1.PipelineRun{}
 	pipelineRun.Spec = *pipelineRunSpec
+	if len(options.Params) > 0 {
+		pipelineRun.Spec.Params = mergeParams(pipelineRun.Spec.Params, options.Params)
+	}
 	pipelineRun.ObjectMeta = metav1.ObjectMeta{
 		GenerateName: fmt.Sprintf("%s-", options.Prefix),
 		Namespace:    options.Namespace,
+		Labels:       options.Labels,
 	}
 
 	if len(pipelineRun.Spec.Resources) == 0 {
-		gitResourceName, err := client.getOrCreateGitPipelineResource(options.Namespace, options.Prefix, options.GitURL, options.GitRevision)
+		var gitResourceName string
+		if dryRun {
+			gitResourceName, err = client.findGitPipelineResource(options.Namespace, event.CloneURL())
+			if gitResourceName == "" {
+				gitResourceName = gitPipelineResourcePrefix(options.Prefix)
+			}
+		} else {
+			gitResourceName, err = client.getOrCreateGitPipelineResource(options.Namespace, options.Prefix, event.CloneURL(), event.Revision())
+		}
 
 		if err != nil {
 			return nil, err
@@ -127,13 +185,32 @@ func (client *Client) generatePipelineRun(options PipelineOptions) (*v1alpha1.Pi
 		}
 	}
 
-	tektonClient := client.tekton.TektonV1alpha1()
+	return pipelineRun, nil
+}
 
-	pipelineRun, err = tektonClient.PipelineRuns(options.Namespace).Create(pipelineRun)
+// CancelPipelineRuns cancels the running PipelineRuns with the given labels,
+// and returns the names of the cancelled PipelineRuns
+func (client *Client) CancelPipelineRuns(namespace string, selector map[string]string) ([]string, error) {
+	pipelineRuns := client.tekton.TektonV1alpha1().PipelineRuns(namespace)
 
+	list, err := pipelineRuns.List(metav1.ListOptions{LabelSelector: labels.SelectorFromSet(selector).String()})
 	if err != nil {
-		return nil, fmt.Errorf("error creating pipeline run: %s", err)
+		return nil, fmt.Errorf("failed to list pipeline runs: %s", err)
 	}
 
-	return pipelineRun, nil
+	var cancelled []string
+	for i := range list.Items {
+		pipelineRun := &list.Items[i]
+		if pipelineRun.IsDone() || pipelineRun.IsCancelled() {
+			continue
+		}
+
+		pipelineRun.Spec.Status = v1alpha1.PipelineRunSpecStatusCancelled
+		if _, err := pipelineRuns.Update(pipelineRun); err != nil {
+			return cancelled, fmt.Errorf("failed to cancel pipeline run %s: %s", pipelineRun.Name, err)
+		}
+		cancelled = append(cancelled, pipelineRun.Name)
+	}
+
+	return cancelled, nil
 }
@@ -0,0 +1,139 @@
+package tekton
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	v1alpha1 "github.com/tektoncd/pipeline/pkg/apis/pipeline/v1alpha1"
+	"github.com/zhd173/githook/pkg/model"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// Param maps a git event to a PipelineRun param, either by a JSONPath
+// evaluated against the event or by a fixed value
+type Param struct {
+	Name     string  `json:"name"`
+	JSONPath string  `json:"jsonPath,omitempty"`
+	Value    string  `json:"value,omitempty"`
+	Default  *string `json:"default,omitempty"`
+}
+
+// EvaluateParams evaluates the params against a git event. The JSONPaths see
+// the event as it is sent to the sink, the raw payload of the git provider
+// being under .payload.
+func EvaluateParams(params []Param, event *model.GitEvent) ([]v1alpha1.Param, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+
+	data, err := eventData(event)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]v1alpha1.Param, 0, len(params))
+	for _, param := range params {
+		value := param.Value
+		if param.JSONPath != "" {
+			value, err = evaluateJSONPath(param, data)
+			if err != nil {
+				return nil, err
+			}
+		}
+		values = append(values, v1alpha1.Param{Name: param.Name, Value: value})
+	}
+
+	return values, nil
+}
+
+// eventData converts the event to the generic shape jsonpath navigates
+func eventData(event *model.GitEvent) (interface{}, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s event: %s", event.Type, err)
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("failed to decode %s event: %s", event.Type, err)
+	}
+
+	return data, nil
+}
+
+func evaluateJSONPath(param Param, data interface{}) (string, error) {
+	path := param.JSONPath
+	if !strings.HasPrefix(path, "{") {
+		path = fmt.Sprintf("{%s}", path)
+	}
+
+	parser := jsonpath.New(param.Name).AllowMissingKeys(true)
+	if err := parser.Parse(path); err != nil {
+		return "", fmt.Errorf("invalid jsonPath %s of param %s: %s", param.JSONPath, param.Name, err)
+	}
+
+	results, err := parser.FindResults(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to evaluate jsonPath %s of param %s: %s", param.JSONPath, param.Name, err)
+	}
+
+	var values []interface{}
+	for _, result := range results {
+		for _, value := range result {
+			if value.IsValid() && value.CanInterface() && value.Interface() != nil {
+				values = append(values, value.Interface())
+			}
+		}
+	}
+
+	switch len(values) {
+	case 0:
+		if param.Default == nil {
+			return "", fmt.Errorf("jsonPath %s of param %s matched nothing", param.JSONPath, param.Name)
+		}
+		return *param.Default, nil
+	case 1:
+		return formatValue(values[0])
+	default:
+		return formatValue(values)
+	}
+}
+
+// formatValue returns strings as they are, and other values as JSON
+func formatValue(value interface{}) (string, error) {
+	if value, ok := value.(string); ok {
+		return value, nil
+	}
+
+	buf := &bytes.Buffer{}
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return "", fmt.Errorf("failed to encode %s value: %s", reflect.TypeOf(value), err)
+	}
+
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// mergeParams appends params to the params of the run spec, replacing the
+// run spec params of the same name
+func mergeParams(runParams, params []v1alpha1.Param) []v1alpha1.Param {
+	merged := make([]v1alpha1.Param, 0, len(runParams)+len(params))
+	for _, runParam := range runParams {
+		replaced := false
+		for _, param := range params {
+			if param.Name == runParam.Name {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, runParam)
+		}
+	}
+
+	return append(merged, params...)
+}
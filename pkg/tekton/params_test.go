@@ -0,0 +1,74 @@
+package tekton
+
+import (
+	"testing"
+
+	"github.com/zhd173/githook/pkg/model"
+)
+
+func TestEvaluateParams(t *testing.T) {
+	event := &model.GitEvent{
+		Type:   "pull_request",
+		Sender: model.GitUser{Login: "jane", Email: "jane@example.com"},
+		PullRequest: &model.GitPullRequest{
+			Number: 7,
+			Title:  "Add <feature>",
+		},
+		Commits: []model.GitCommit{{ID: "a"}, {ID: "b"}},
+		Payload: []byte(`{"pull_request": {"labels": [{"name": "bug"}]}}`),
+	}
+	unknown := "none"
+
+	params, err := EvaluateParams([]Param{
+		{Name: "title", JSONPath: "{.pullRequest.title}"},
+		{Name: "number", JSONPath: ".pullRequest.number"},
+		{Name: "email", JSONPath: "{.sender.email}"},
+		{Name: "commits", JSONPath: "{.commits[*].id}"},
+		{Name: "labels", JSONPath: "{.payload.pull_request.labels}"},
+		{Name: "issue", JSONPath: "{.issue.title}", Default: &unknown},
+		{Name: "fixed", Value: "value"},
+	}, event)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := map[string]string{
+		"title":   "Add <feature>",
+		"number":  "7",
+		"email":   "jane@example.com",
+		"commits": `["a","b"]`,
+		"labels":  `[{"name":"bug"}]`,
+		"issue":   "none",
+		"fixed":   "value",
+	}
+	if len(params) != len(expected) {
+		t.Fatalf("expected %d params, got %v", len(expected), params)
+	}
+	for _, param := range params {
+		if param.Value != expected[param.Name] {
+			t.Errorf("expected param %s %q, got %q", param.Name, expected[param.Name], param.Value)
+		}
+	}
+}
+
+func TestEvaluateParamsReportsMissingField(t *testing.T) {
+	_, err := EvaluateParams([]Param{{Name: "title", JSONPath: "{.pullRequest.title}"}}, &model.GitEvent{Type: "push"})
+	if err == nil {
+		t.Error("expected error for missing field without default")
+	}
+
+	_, err = EvaluateParams([]Param{{Name: "title", JSONPath: "{.pullRequest[}"}}, &model.GitEvent{Type: "push"})
+	if err == nil {
+		t.Error("expected error for invalid jsonPath")
+	}
+}
+
+func TestReplaceVars(t *testing.T) {
+	event := &model.GitEvent{Type: "manual", After: "0123456789abcdef"}
+
+	replaced := replaceVars(`{"params": [{"name": "event", "value": "$EVENT"}, {"name": "commit", "value": "$COMMIT"}]}`, event)
+	expected := `{"params": [{"name": "event", "value": "manual"}, {"name": "commit", "value": "0123456789"}]}`
+	if replaced != expected {
+		t.Errorf("expected %s, got %s", expected, replaced)
+	}
+}
@@ -3,10 +3,13 @@ package tekton
 import (
 	"fmt"
 	"strings"
+
+	"github.com/zhd173/githook/pkg/model"
 )
 
-func replaceVars(input string, opts PipelineOptions) string {
-	return replaceVar(input, "COMMIT", shorten(opts.GitCommit))
+func replaceVars(input string, event *model.GitEvent) string {
+	input = replaceVar(input, "EVENT", event.Type)
+	return replaceVar(input, "COMMIT", shorten(event.After))
 }
 
 func replaceVar(input, varName, value string) string {